@@ -0,0 +1,70 @@
+// Package broadcast renders messages of broadcasts for their recipients,
+// templates are checked by the database service and rendered by the telegram bot
+package broadcast
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/Iamnotagenius/test/db/service"
+)
+
+// MaxLength is the longest text telegram sends in one message, in characters
+const MaxLength = 4096
+
+// ErrTooLong is returned when a rendered message does not fit in one telegram message
+var ErrTooLong = errors.New("message is longer than 4096 characters")
+
+// Recipient is data available to templates, e.g. "Hello, {{.Name}}!"
+type Recipient struct {
+	ID          int64
+	Name        string
+	PhoneNumber string
+	// Role is lowercase name without prefix, e.g. read_only_admin
+	Role       string
+	Groups     []string
+	Attributes map[string]string
+}
+
+// RecipientFromUser returns template data of a user
+func RecipientFromUser(user *service.User) Recipient {
+	attributes := user.GetAttributes()
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+	return Recipient{
+		ID:          user.GetId(),
+		Name:        user.GetName(),
+		PhoneNumber: user.GetPhoneNumber(),
+		Role:        strings.ToLower(strings.TrimPrefix(user.GetRole().String(), "ROLE_")),
+		Groups:      user.GetGroups(),
+		Attributes:  attributes,
+	}
+}
+
+// Parse parses a template and checks that it can be rendered,
+// so mistakes like unknown fields are found before anything is sent
+func Parse(text string) (*template.Template, error) {
+	tmpl, err := template.New("broadcast").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Render(tmpl, &service.User{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Render renders a message for a user
+func Render(tmpl *template.Template, user *service.User) (string, error) {
+	var text strings.Builder
+	if err := tmpl.Execute(&text, RecipientFromUser(user)); err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(text.String()) > MaxLength {
+		return "", ErrTooLong
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker rejects calls for a cooldown after a number of consecutive failures,
+// then lets a single probe call through to check if the service is back
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// isFailure reports whether an error of a call made with ctx means the service is unhealthy
+// rather than the call being wrong. An expired deadline only counts if it was the default one
+// or the caller still has time left, a short deadline chosen by the caller says nothing about the service.
+func isFailure(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return true
+	case codes.DeadlineExceeded:
+		return hasDefaultDeadline(ctx) || ctx.Err() == nil
+	}
+	return false
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return status.Error(codes.Unavailable, "Database service is unavailable, circuit breaker is open")
+		}
+		b.state = breakerHalfOpen
+		b.openedAt = time.Now()
+	case breakerHalfOpen:
+		// A probe that never finished, e.g. an abandoned stream, is replaced after a cooldown
+		if time.Since(b.openedAt) < b.cooldown {
+			return status.Error(codes.Unavailable, "Database service is unavailable, waiting for probe call")
+		}
+		b.openedAt = time.Now()
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.record(isFailure(ctx, err))
+	return err
+}
+
+func (b *circuitBreaker) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		b.record(isFailure(ctx, err))
+		return nil, err
+	}
+	return &breakerStream{ClientStream: stream, breaker: b, ctx: ctx}, nil
+}
+
+// breakerStream records outcome of a stream once it ends
+type breakerStream struct {
+	grpc.ClientStream
+	breaker *circuitBreaker
+	ctx     context.Context
+	once    sync.Once
+}
+
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			s.breaker.record(err != io.EOF && isFailure(s.ctx, err))
+		})
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// slowServer answers after a delay
+type slowServer struct {
+	service.UnimplementedDatabaseTestServer
+	delay time.Duration
+}
+
+func (s slowServer) GetUserByID(ctx context.Context, req *service.UserByIDRequest) (*service.User, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+	}
+	return &service.User{Id: req.GetId()}, nil
+}
+
+func TestBreakerIgnoresDeadlinesOfCallers(t *testing.T) {
+	c := dialTest(t, slowServer{delay: 50 * time.Millisecond}, nil,
+		WithTimeout(time.Second), WithCircuitBreaker(2, time.Minute))
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, err := c.GetUserByID(ctx, &service.UserByIDRequest{Id: 1})
+		cancel()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("got %v, want DeadlineExceeded", err)
+		}
+	}
+	if _, err := c.GetUserByID(context.Background(), &service.UserByIDRequest{Id: 1}); err != nil {
+		t.Errorf("impatient callers opened the circuit: %v", err)
+	}
+}
+
+func TestBreakerOpensWhenDefaultDeadlineExpires(t *testing.T) {
+	c := dialTest(t, slowServer{delay: time.Second}, nil,
+		WithTimeout(5*time.Millisecond), WithCircuitBreaker(2, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetUserByID(context.Background(), &service.UserByIDRequest{Id: 1}); status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("got %v, want DeadlineExceeded", err)
+		}
+	}
+	_, err := c.GetUserByID(context.Background(), &service.UserByIDRequest{Id: 1})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want the circuit to be open", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package client contains a resilient client of the database service
+// shared by the REST API and the telegram bot
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Client wraps DatabaseTestClient with default timeouts, retries of idempotent calls,
+// a circuit breaker, keepalives and tracing
+type Client struct {
+	service.DatabaseTestClient
+	conn *grpc.ClientConn
+}
+
+type options struct {
+	timeout          time.Duration
+	maxAttempts      int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	keepalive        keepalive.ClientParameters
+	serviceToken     string
+	dialOptions      []grpc.DialOption
+}
+
+// Option configures a Client
+type Option func(*options)
+
+// WithTimeout sets deadline of calls made with a context without one, long-lived streams are not limited
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetries sets how many times an idempotent call is attempted when the service is unavailable
+// and the delay before the first retry, which doubles with every next one
+func WithRetries(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = maxAttempts
+		o.initialBackoff = initialBackoff
+	}
+}
+
+// WithCircuitBreaker makes calls fail fast for cooldown after threshold consecutive failures
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breakerThreshold = threshold
+		o.breakerCooldown = cooldown
+	}
+}
+
+// WithKeepalive sets keepalive parameters of the connection
+func WithKeepalive(params keepalive.ClientParameters) Option {
+	return func(o *options) {
+		o.keepalive = params
+	}
+}
+
+// WithServiceToken authenticates calls with the token shared with the database service, calls are not authenticated if it is empty
+func WithServiceToken(token string) Option {
+	return func(o *options) {
+		o.serviceToken = token
+	}
+}
+
+// WithDialOptions passes additional options to grpc.Dial, e.g. interceptors
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// idempotentMethods can be safely retried
+var idempotentMethods = map[string]bool{
+	"GetUserByID":              true,
+	"SearchUsersByName":        true,
+	"GetUserAt":                true,
+	"ListUserVersions":         true,
+	"DefineAttribute":          true,
+	"ListAttributeDefinitions": true,
+	"ListWebhooks":             true,
+	"ListWebhookDeliveries":    true,
+	"ListRoleChangeRequests":   true,
+	"ListInvitations":          true,
+	"RevokeInvitation":         true,
+	"ListRedemptions":          true,
+	"ListAPITokens":            true,
+	"RevokeAPIToken":           true,
+	"AuthenticateAPIToken":     true,
+}
+
+// longLivedMethods are streams which are not limited by the default timeout
+var longLivedMethods = map[string]bool{
+	"WatchUserEvents": true,
+}
+
+type methodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+type retryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type methodConfig struct {
+	Name        []methodName `json:"name"`
+	RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%gs", d.Seconds())
+}
+
+// serviceConfig builds gRPC service config with retry policies of every method
+func serviceConfig(o *options) (string, error) {
+	names := make([]string, 0)
+	for _, method := range service.DatabaseTest_ServiceDesc.Methods {
+		names = append(names, method.MethodName)
+	}
+	for _, stream := range service.DatabaseTest_ServiceDesc.Streams {
+		names = append(names, stream.StreamName)
+	}
+
+	configs := make([]methodConfig, 0, len(names))
+	for _, name := range names {
+		config := methodConfig{
+			Name: []methodName{{Service: service.DatabaseTest_ServiceDesc.ServiceName, Method: name}},
+		}
+		if o.maxAttempts > 1 && idempotentMethods[name] {
+			config.RetryPolicy = &retryPolicy{
+				MaxAttempts:          o.maxAttempts,
+				InitialBackoff:       seconds(o.initialBackoff),
+				MaxBackoff:           seconds(o.maxBackoff),
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: []string{"UNAVAILABLE"},
+			}
+		}
+		configs = append(configs, config)
+	}
+
+	raw, err := json.Marshal(struct {
+		MethodConfig []methodConfig `json:"methodConfig"`
+	}{configs})
+	return string(raw), err
+}
+
+// Dial connects to the database service at addr
+func Dial(addr string, opts ...Option) (*Client, error) {
+	o := &options{
+		timeout:          5 * time.Second,
+		maxAttempts:      4,
+		initialBackoff:   100 * time.Millisecond,
+		maxBackoff:       2 * time.Second,
+		breakerThreshold: 5,
+		breakerCooldown:  10 * time.Second,
+		keepalive: keepalive.ClientParameters{
+			Time:                30 * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	config, err := serviceConfig(o)
+	if err != nil {
+		return nil, err
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(config),
+		grpc.WithKeepaliveParams(o.keepalive),
+		grpc.WithChainUnaryInterceptor(readOnlyUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(readOnlyStreamInterceptor),
+	}
+	if o.serviceToken != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(serviceToken(o.serviceToken)))
+	}
+	if o.timeout > 0 {
+		deadline := &defaultDeadline{timeout: o.timeout}
+		dialOptions = append(dialOptions,
+			grpc.WithChainUnaryInterceptor(deadline.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(deadline.streamInterceptor))
+	}
+	dialOptions = append(dialOptions,
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor()))
+	if o.breakerThreshold > 0 {
+		breaker := &circuitBreaker{threshold: o.breakerThreshold, cooldown: o.breakerCooldown}
+		dialOptions = append(dialOptions,
+			grpc.WithChainUnaryInterceptor(breaker.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(breaker.streamInterceptor))
+	}
+	dialOptions = append(dialOptions, o.dialOptions...)
+
+	conn, err := grpc.Dial(addr, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{DatabaseTestClient: service.NewDatabaseTestClient(conn), conn: conn}, nil
+}
+
+// Close closes connection to the database service
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialTest serves impl in memory and connects to it with opts, calls are not retried
+func dialTest(t *testing.T, impl service.DatabaseTestServer, serverOpts []grpc.ServerOption, opts ...Option) *Client {
+	t.Helper()
+	listener := bufconn.Listen(1 << 16)
+	grpcServer := grpc.NewServer(serverOpts...)
+	service.RegisterDatabaseTestServer(grpcServer, impl)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	opts = append(opts, WithRetries(1, 0), WithDialOptions(grpc.WithContextDialer(
+		func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) })))
+	c, err := Dial("bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"path"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readOnlyMethods do not change any data, so they are allowed in read-only contexts
+var readOnlyMethods = map[string]bool{
+	"GetUserByID":              true,
+	"SearchUsersByName":        true,
+	"GetUserAt":                true,
+	"ListUserVersions":         true,
+	"ListAttributeDefinitions": true,
+	"ListWebhooks":             true,
+	"ListWebhookDeliveries":    true,
+	"ListRoleChangeRequests":   true,
+	"ListInvitations":          true,
+	"ListRedemptions":          true,
+	"WatchUserEvents":          true,
+	"ListAPITokens":            true,
+	"GetBroadcast":             true,
+	"ListBroadcastRecipients":  true,
+}
+
+type readOnlyKey struct{}
+
+// ReadOnly returns a context in which calls that may change data are refused
+// before reaching the service, e.g. for commands previewed by admins
+func ReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+func checkReadOnly(ctx context.Context, method string) error {
+	if readOnly, _ := ctx.Value(readOnlyKey{}).(bool); readOnly && !readOnlyMethods[path.Base(method)] {
+		return status.Errorf(codes.PermissionDenied, "%v cannot be called in read-only mode", path.Base(method))
+	}
+	return nil
+}
+
+func readOnlyUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := checkReadOnly(ctx, method); err != nil {
+		return err
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func readOnlyStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := checkReadOnly(ctx, method); err != nil {
+		return nil, err
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// defaultDeadline sets a deadline on calls made with a context without one,
+// so callers with longer work, e.g. exports, can still choose their own
+type defaultDeadline struct {
+	timeout time.Duration
+}
+
+type defaultDeadlineKey struct{}
+
+func (d *defaultDeadline) context(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || longLivedMethods[path.Base(method)] {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(context.WithValue(ctx, defaultDeadlineKey{}, true), d.timeout)
+}
+
+// hasDefaultDeadline reports whether the deadline of ctx is the default one rather than chosen by the caller
+func hasDefaultDeadline(ctx context.Context) bool {
+	applied, _ := ctx.Value(defaultDeadlineKey{}).(bool)
+	return applied
+}
+
+func (d *defaultDeadline) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, cancel := d.context(ctx, method)
+	defer cancel()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (d *defaultDeadline) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, cancel := d.context(ctx, method)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &deadlineStream{ClientStream: stream, cancel: cancel}, nil
+}
+
+// deadlineStream releases the deadline once the stream ends
+type deadlineStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (s *deadlineStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
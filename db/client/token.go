@@ -0,0 +1,19 @@
+package client
+
+import (
+	"context"
+
+	"github.com/Iamnotagenius/test/db/service"
+)
+
+// serviceToken authenticates calls to the database service, see service.ServiceTokenMetadataKey
+type serviceToken string
+
+func (t serviceToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{service.ServiceTokenMetadataKey: string(t)}, nil
+}
+
+// RequireTransportSecurity is false, the token protects the service from other clients of a private network
+func (t serviceToken) RequireTransportSecurity() bool {
+	return false
+}
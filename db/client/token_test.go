@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/server"
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type echoServer struct {
+	service.UnimplementedDatabaseTestServer
+}
+
+func (echoServer) GetUserByID(ctx context.Context, req *service.UserByIDRequest) (*service.User, error) {
+	return &service.User{Id: req.GetId()}, nil
+}
+
+func TestServiceTokenAuthenticatesCalls(t *testing.T) {
+	for name, test := range map[string]struct {
+		opts []Option
+		code codes.Code
+	}{
+		"right token":   {[]Option{WithServiceToken("secret")}, codes.OK},
+		"wrong token":   {[]Option{WithServiceToken("guess")}, codes.Unauthenticated},
+		"without token": {nil, codes.Unauthenticated},
+	} {
+		c := dialTest(t, echoServer{}, server.RequireServiceToken("secret"), test.opts...)
+		_, err := c.GetUserByID(context.Background(), &service.UserByIDRequest{Id: 1})
+		if status.Code(err) != test.code {
+			t.Errorf("%v: got %v, want %v", name, err, test.code)
+		}
+	}
+}
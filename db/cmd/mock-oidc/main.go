@@ -0,0 +1,303 @@
+// Mock OpenID Connect provider for local development and integration tests.
+// Issues ID tokens for any ISU number entered on the login page, or given in
+// "isu" parameter of authorization request to skip the page.
+//
+// Run REST API or the bot with -oidc-issuer pointing to this provider, e.g.
+//
+//	mock-oidc -addr localhost:9096
+//	rest -oidc-issuer http://localhost:9096 -oidc-client-id dev
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+var (
+	addr         = flag.String("addr", "localhost:9096", "Address to listen on")
+	issuer       = flag.String("issuer", "", "Issuer URL, http://<addr> by default")
+	clientSecret = flag.String("client-secret", "", "Require this client secret on token requests, any client is accepted if empty")
+	isuClaim     = flag.String("isu-claim", "isu", "Name of the claim with ISU number")
+	tokenTTL     = flag.Duration("token-ttl", time.Hour, "Lifetime of issued tokens")
+)
+
+const (
+	codeTTL = time.Minute
+	keyID   = "mock"
+)
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	clientID            string
+	redirectURI         string
+	nonce               string
+	codeChallenge       string
+	codeChallengeMethod string
+	isu                 int64
+	name                string
+	expiresAt           time.Time
+}
+
+type provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock login</title></head>
+<body>
+<h1>Mock identity provider</h1>
+<p>Log in to <b>{{.ClientID}}</b> as any user.</p>
+<form method="post">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<label>ISU <input name="isu" required pattern="[0-9]+" autofocus></label>
+<label>Name <input name="name"></label>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+func randomString() string {
+	buffer := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		log.Panicf("Failed to generate random string: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func tokenError(w http.ResponseWriter, code string, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"scopes_supported":                      []string{"openid"},
+		"claims_supported":                      []string{"sub", "name", *isuClaim},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &p.key.PublicKey,
+		KeyID:     keyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+// authorize shows login page, or issues a code right away if ISU is given
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.Form
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "redirect_uri must be an absolute URL", http.StatusBadRequest)
+		return
+	}
+	if params.Get("response_type") != "code" {
+		http.Error(w, "Only code response type is supported", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("isu") == "" {
+		shown := url.Values{}
+		for name, values := range params {
+			if name != "isu" && name != "name" {
+				shown[name] = values
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, struct {
+			ClientID string
+			Params   url.Values
+		}{params.Get("client_id"), shown})
+		return
+	}
+	isu, err := strconv.ParseInt(params.Get("isu"), 10, 64)
+	if err != nil {
+		http.Error(w, "ISU must be a number", http.StatusBadRequest)
+		return
+	}
+	name := params.Get("name")
+	if name == "" {
+		name = "User " + params.Get("isu")
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	now := time.Now()
+	for pending, g := range p.grants {
+		if now.After(g.expiresAt) {
+			delete(p.grants, pending)
+		}
+	}
+	p.grants[code] = &grant{
+		clientID:            params.Get("client_id"),
+		redirectURI:         params.Get("redirect_uri"),
+		nonce:               params.Get("nonce"),
+		codeChallenge:       params.Get("code_challenge"),
+		codeChallengeMethod: params.Get("code_challenge_method"),
+		isu:                 isu,
+		name:                name,
+		expiresAt:           now.Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	if state := params.Get("state"); state != "" {
+		query.Set("state", state)
+	}
+	redirectURI.RawQuery = query.Encode()
+	log.Printf("Issued code for ISU %v to client %q", isu, params.Get("client_id"))
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func verifyChallenge(g *grant, verifier string) bool {
+	if g.codeChallenge == "" {
+		return true
+	}
+	expected := verifier
+	if g.codeChallengeMethod == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return verifier != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(g.codeChallenge)) == 1
+}
+
+// token exchanges authorization code for ID token
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "Only authorization_code grant is supported")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if *clientSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(*clientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+	switch {
+	case !ok || time.Now().After(g.expiresAt):
+		tokenError(w, "invalid_grant", "Code is unknown, used or expired")
+		return
+	case g.clientID != clientID:
+		tokenError(w, "invalid_grant", "Code was issued to another client")
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant", "redirect_uri does not match authorization request")
+		return
+	case !verifyChallenge(g, r.PostForm.Get("code_verifier")):
+		tokenError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       p.issuer,
+		"sub":       strconv.FormatInt(g.isu, 10),
+		"aud":       g.clientID,
+		"iat":       now.Unix(),
+		"auth_time": now.Unix(),
+		"exp":       now.Add(*tokenTTL).Unix(),
+		"name":      g.name,
+		*isuClaim:   g.isu,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	idToken, err := jwt.Signed(p.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func main() {
+	flag.Parse()
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID))
+	if err != nil {
+		log.Fatalf("Failed to create signer: %v", err)
+	}
+	p := &provider{issuer: *issuer, key: key, signer: signer, grants: make(map[string]*grant)}
+
+	http.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	http.HandleFunc("/jwks", p.jwks)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+
+	log.Printf("Mock OIDC provider with issuer %v listening at %v", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config is read from a YAML file, e.g.
+//
+//	address: db.internal:50051
+//	timeout: 10s
+//	actor: 284555
+//	output: table
+//	service_token: <token shared with the database service>
+type config struct {
+	// Address of database service
+	Address string `yaml:"address"`
+	// Default deadline of calls
+	Timeout time.Duration `yaml:"timeout"`
+	// ID of the operator, changes are recorded as made by them
+	// and role escalations are requested on their behalf
+	Actor int64 `yaml:"actor"`
+	// Default output format
+	Output string `yaml:"output"`
+	// Token shared with the database service, DB_SERVICE_TOKEN environment variable by default
+	ServiceToken string `yaml:"service_token"`
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("USERSCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "usersctl", "config.yaml")
+}
+
+// loadConfig reads config from path, missing file at the default path leaves default values
+func loadConfig(path string, explicit bool) (*config, error) {
+	conf := &config{
+		Address:      "localhost:50051",
+		Timeout:      5 * time.Second,
+		Output:       formatTable,
+		ServiceToken: os.Getenv("DB_SERVICE_TOKEN"),
+	}
+	if path == "" {
+		return conf, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(raw, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
@@ -0,0 +1,293 @@
+// Command line tool for on-call admins to inspect and fix user records
+// through database service, without the browser login of REST API.
+//
+// Usage:
+//
+//	usersctl [-config file] [-addr host:port] [-o table|json|yaml] <command> [arguments]
+//
+// Commands:
+//
+//	get <id>                     show a user
+//	search [query]               search users by part of a name
+//	set-role <id> <role>         change role, admin roles are requested for approval
+//	set-phone <id> [phone]       change phone number, clears it if omitted
+//	delete <id>                  soft-delete a user
+//	watch [-after id] [-type t]  stream user change events
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/client"
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	configPath = flag.String("config", "", "Path to config file, $USERSCTL_CONFIG or usersctl/config.yaml in user config directory by default")
+	address    = flag.String("addr", "", "Address of database service, overrides config")
+	output     = flag.String("o", "", "Output format: table, json or yaml, overrides config")
+)
+
+// env holds what every command needs
+type env struct {
+	ctx    context.Context
+	db     service.DatabaseTestClient
+	conf   *config
+	out    *printer
+	params *flag.FlagSet
+}
+
+type command struct {
+	usage string
+	run   func(e *env) error
+}
+
+var commands = map[string]command{
+	"get":       {"get <id>", get},
+	"search":    {"search [query]", search},
+	"set-role":  {"set-role <id> <user|read_only_admin|read_write_admin>", setRole},
+	"set-phone": {"set-phone <id> [phone]", setPhone},
+	"delete":    {"delete <id>", deleteUser},
+	"watch":     {"watch [-after id] [-type event type]...", watch},
+}
+
+// stringList is a flag which can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var (
+	watchFlags = flag.NewFlagSet("watch", flag.ExitOnError)
+	watchAfter = watchFlags.Int64("after", 0, "Stream events after the event with this ID, 0 streams whole history")
+	watchTypes stringList
+)
+
+func init() {
+	watchFlags.Var(&watchTypes, "type", "Stream only events of this type, e.g. user.role_changed, can be repeated")
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, name := range []string{"get", "search", "set-role", "set-phone", "delete", "watch"} {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v\n", commands[name].usage)
+	}
+	fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(cmd, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(cmd command, args []string) error {
+	path := *configPath
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	conf, err := loadConfig(path, explicit)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if *address != "" {
+		conf.Address = *address
+	}
+	if *output != "" {
+		conf.Output = *output
+	}
+	out, err := newPrinter(os.Stdout, conf.Output)
+	if err != nil {
+		return err
+	}
+
+	params := flag.NewFlagSet(args[0], flag.ExitOnError)
+	if args[0] == "watch" {
+		params = watchFlags
+	}
+	params.Usage = func() {
+		fmt.Fprintf(params.Output(), "Usage: %v %v\n", os.Args[0], cmd.usage)
+		params.PrintDefaults()
+	}
+	params.Parse(args[1:])
+
+	dbClient, err := client.Dial(conf.Address, client.WithTimeout(conf.Timeout), client.WithServiceToken(conf.ServiceToken))
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if conf.Actor != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, service.ActorMetadataKey, strconv.FormatInt(conf.Actor, 10))
+	}
+
+	err = cmd.run(&env{ctx: ctx, db: dbClient, conf: conf, out: out, params: params})
+	if s, ok := status.FromError(err); ok && err != nil {
+		return errors.New(s.Message())
+	}
+	return err
+}
+
+// idArg parses user ID at position i of command arguments
+func (e *env) idArg(i int) (int64, error) {
+	if e.params.NArg() <= i {
+		e.params.Usage()
+		os.Exit(2)
+	}
+	id, err := strconv.ParseInt(e.params.Arg(i), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong user ID %q", e.params.Arg(i))
+	}
+	return id, nil
+}
+
+func get(e *env) error {
+	id, err := e.idArg(0)
+	if err != nil {
+		return err
+	}
+	found, err := e.db.GetUserByID(e.ctx, &service.UserByIDRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	return e.out.user(userFromProto(found))
+}
+
+func search(e *env) error {
+	stream, err := e.db.SearchUsersByName(e.ctx, &service.SearchByNameRequest{Query: strings.Join(e.params.Args(), " ")})
+	if err != nil {
+		return err
+	}
+	users := make([]*user, 0)
+	for {
+		found, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		users = append(users, userFromProto(found))
+	}
+	return e.out.users(users)
+}
+
+func setRole(e *env) error {
+	id, err := e.idArg(0)
+	if err != nil {
+		return err
+	}
+	if e.params.NArg() < 2 {
+		e.params.Usage()
+		os.Exit(2)
+	}
+	value, ok := service.Role_value["ROLE_"+strings.ToUpper(e.params.Arg(1))]
+	if !ok || value == int32(service.Role_ROLE_UNSPECIFIED) {
+		return fmt.Errorf("unknown role %q", e.params.Arg(1))
+	}
+	role := service.Role(value)
+
+	found, err := e.db.GetUserByID(e.ctx, &service.UserByIDRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	// Roles granting admin privileges are requested by the database service on behalf of the actor
+	found.Role = role
+	res, err := e.db.AddOrUpdateUser(e.ctx, found)
+	if err != nil {
+		return err
+	}
+	if request := res.GetRoleChangeRequest(); request != nil {
+		return e.out.roleChangeRequest(roleChangeRequestFromProto(request))
+	}
+	return e.out.user(userFromProto(found))
+}
+
+func setPhone(e *env) error {
+	id, err := e.idArg(0)
+	if err != nil {
+		return err
+	}
+	found, err := e.db.GetUserByID(e.ctx, &service.UserByIDRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	found.PhoneNumber = nil
+	if phone := e.params.Arg(1); phone != "" {
+		found.PhoneNumber = &phone
+	}
+	if _, err := e.db.AddOrUpdateUser(e.ctx, found); err != nil {
+		return err
+	}
+	return e.out.user(userFromProto(found))
+}
+
+func deleteUser(e *env) error {
+	id, err := e.idArg(0)
+	if err != nil {
+		return err
+	}
+	if _, err := e.db.DeleteUser(e.ctx, &service.UserByIDRequest{Id: id}); err != nil {
+		return err
+	}
+	if e.conf.Output == formatTable {
+		fmt.Printf("User %v deleted\n", id)
+	}
+	return nil
+}
+
+func watch(e *env) error {
+	stream, err := e.db.WatchUserEvents(e.ctx, &service.WatchUserEventsRequest{
+		AfterId:    *watchAfter,
+		EventTypes: watchTypes,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF || e.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.out.event(eventFromProto(event)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+type user struct {
+	ID             int64             `json:"id" yaml:"id"`
+	Name           string            `json:"name" yaml:"name"`
+	PhoneNumber    *string           `json:"phone_number,omitempty" yaml:"phone_number,omitempty"`
+	Role           string            `json:"role" yaml:"role"`
+	TelegramChatID *int64            `json:"telegram_chat_id,omitempty" yaml:"telegram_chat_id,omitempty"`
+	Attributes     map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Groups         []string          `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+type event struct {
+	ID             int64     `json:"id" yaml:"id"`
+	Type           string    `json:"type" yaml:"type"`
+	Tags           []string  `json:"tags,omitempty" yaml:"tags,omitempty"`
+	UserID         int64     `json:"user_id" yaml:"user_id"`
+	User           *user     `json:"user,omitempty" yaml:"user,omitempty"`
+	Previous       *user     `json:"previous,omitempty" yaml:"previous,omitempty"`
+	ActorID        *int64    `json:"actor_id,omitempty" yaml:"actor_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at" yaml:"created_at"`
+	ImpersonatedBy *int64    `json:"impersonated_by,omitempty" yaml:"impersonated_by,omitempty"`
+}
+
+type roleChangeRequest struct {
+	ID            int64     `json:"id" yaml:"id"`
+	UserID        int64     `json:"user_id" yaml:"user_id"`
+	FromRole      string    `json:"from_role" yaml:"from_role"`
+	RequestedRole string    `json:"requested_role" yaml:"requested_role"`
+	RequestedBy   int64     `json:"requested_by" yaml:"requested_by"`
+	ExpiresAt     time.Time `json:"expires_at" yaml:"expires_at"`
+}
+
+func roleName(role service.Role) string {
+	return strings.ToLower(strings.TrimPrefix(role.String(), "ROLE_"))
+}
+
+func userFromProto(u *service.User) *user {
+	if u == nil {
+		return nil
+	}
+	return &user{
+		ID:             u.GetId(),
+		Name:           u.GetName(),
+		PhoneNumber:    u.PhoneNumber,
+		Role:           roleName(u.GetRole()),
+		TelegramChatID: u.TelegramChatId,
+		Attributes:     u.GetAttributes(),
+		Groups:         u.GetGroups(),
+	}
+}
+
+func eventFromProto(e *service.UserEvent) event {
+	return event{
+		ID:             e.GetId(),
+		Type:           e.GetType(),
+		Tags:           e.GetTags(),
+		UserID:         e.GetUserId(),
+		User:           userFromProto(e.GetUser()),
+		Previous:       userFromProto(e.GetPrevious()),
+		ActorID:        e.ActorId,
+		CreatedAt:      e.GetCreatedAt().AsTime(),
+		ImpersonatedBy: e.ImpersonatedBy,
+	}
+}
+
+func roleChangeRequestFromProto(r *service.RoleChangeRequest) roleChangeRequest {
+	return roleChangeRequest{
+		ID:            r.GetId(),
+		UserID:        r.GetUserId(),
+		FromRole:      roleName(r.GetFromRole()),
+		RequestedRole: roleName(r.GetRequestedRole()),
+		RequestedBy:   r.GetRequestedBy(),
+		ExpiresAt:     r.GetExpiresAt().AsTime(),
+	}
+}
+
+// printer writes values in one of output formats
+type printer struct {
+	w      io.Writer
+	format string
+	// documents counts YAML documents written to separate them
+	documents int
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case formatTable, formatJSON, formatYAML:
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected table, json or yaml", format)
+}
+
+// structured writes value as JSON or YAML, reports false for table format
+func (p *printer) structured(value interface{}) (bool, error) {
+	switch p.format {
+	case formatJSON:
+		raw, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return true, err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", raw)
+		return true, err
+	case formatYAML:
+		raw, err := yaml.Marshal(value)
+		if err != nil {
+			return true, err
+		}
+		if p.documents > 0 {
+			fmt.Fprintln(p.w, "---")
+		}
+		p.documents++
+		_, err = p.w.Write(raw)
+		return true, err
+	}
+	return false, nil
+}
+
+func optional[T any](value *T) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprint(*value)
+}
+
+func (p *printer) users(users []*user) error {
+	if done, err := p.structured(users); done {
+		return err
+	}
+	table := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tPHONE\tROLE\tTELEGRAM\tGROUPS")
+	for _, u := range users {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			u.ID, u.Name, optional(u.PhoneNumber), u.Role, optional(u.TelegramChatID), strings.Join(u.Groups, ","))
+	}
+	return table.Flush()
+}
+
+func (p *printer) user(u *user) error {
+	if done, err := p.structured(u); done {
+		return err
+	}
+	table := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "ID:\t%v\n", u.ID)
+	fmt.Fprintf(table, "Name:\t%v\n", u.Name)
+	fmt.Fprintf(table, "Phone:\t%v\n", optional(u.PhoneNumber))
+	fmt.Fprintf(table, "Role:\t%v\n", u.Role)
+	fmt.Fprintf(table, "Telegram chat:\t%v\n", optional(u.TelegramChatID))
+	fmt.Fprintf(table, "Groups:\t%v\n", strings.Join(u.Groups, ", "))
+	for name, value := range u.Attributes {
+		fmt.Fprintf(table, "%v:\t%v\n", name, value)
+	}
+	return table.Flush()
+}
+
+// event writes one event, in JSON format events are written one per line to be piped
+func (p *printer) event(e event) error {
+	switch p.format {
+	case formatJSON:
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", raw)
+		return err
+	case formatYAML:
+		_, err := p.structured(e)
+		return err
+	}
+	actor := optional(e.ActorID)
+	if e.ImpersonatedBy != nil {
+		actor += fmt.Sprintf(" (impersonated by %v)", *e.ImpersonatedBy)
+	}
+	_, err := fmt.Fprintf(p.w, "%v\t#%v\t%v\tuser=%v\tactor=%v\t%v\n",
+		e.CreatedAt.Local().Format(time.RFC3339), e.ID, e.Type, e.UserID, actor, strings.Join(e.Tags, ","))
+	return err
+}
+
+func (p *printer) roleChangeRequest(r roleChangeRequest) error {
+	if done, err := p.structured(r); done {
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "Role change request %v created: %v -> %v for user %v, needs approval of another read-write admin until %v\n",
+		r.ID, r.FromRole, r.RequestedRole, r.UserID, r.ExpiresAt.Local().Format(time.RFC1123))
+	return err
+}
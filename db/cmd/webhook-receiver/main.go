@@ -0,0 +1,50 @@
+// Local HTTP receiver for testing webhooks of database service.
+// Verifies signatures and logs received events.
+package main
+
+import (
+	"crypto/hmac"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Iamnotagenius/test/db/server"
+)
+
+var (
+	addr       = flag.String("addr", "localhost:9000", "Address to listen on")
+	failStatus = flag.Int("fail-status", 0, "Respond with this status to every request to test retries")
+)
+
+func main() {
+	flag.Parse()
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		log.Println("WEBHOOK_SECRET is not set, signatures will not be verified")
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signature := r.Header.Get(server.SignatureHeader)
+		if secret != "" && !hmac.Equal([]byte(signature), []byte(server.Sign(secret, body))) {
+			log.Printf("Delivery %v has invalid signature %q", r.Header.Get(server.DeliveryHeader), signature)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("Delivery %v of %v: %s", r.Header.Get(server.DeliveryHeader), r.Header.Get(server.EventHeader), body)
+		if *failStatus != 0 {
+			w.WriteHeader(*failStatus)
+		}
+	})
+
+	log.Printf("Receiver listening at %v", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
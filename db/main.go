@@ -2,38 +2,95 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Iamnotagenius/test/db/server"
 	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
 	"github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 var (
 	serviceAddr = flag.String("service-addr", "localhost:50051", "The service address")
 	dbAddr      = flag.String("db-addr", "localhost:5432", "The database address")
 	dbUser      = flag.String("db-user", "postgres", "Database user")
+
+	webhookPollInterval = flag.Duration("webhook-poll-interval", time.Second, "How often pending webhook deliveries are checked")
+
+	tracingConfig = tracing.Flags("db-service")
 )
 
+// isLoopback reports whether addr can only be reached from the same host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func main() {
 	flag.Parse()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), *tracingConfig)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	lis, err := net.Listen("tcp", *serviceAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	var opts []grpc.ServerOption
+	opts := []grpc.ServerOption{
+		// Clients of db/client ping idle connections every 30 seconds
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	}
+	// Calls name their actors in metadata, so only trusted clients may reach the service
+	if token := os.Getenv("DB_SERVICE_TOKEN"); token != "" {
+		opts = append(opts, server.RequireServiceToken(token)...)
+	} else if !isLoopback(*serviceAddr) {
+		log.Fatalf("DB_SERVICE_TOKEN must be set when the service is not listening on a loopback address")
+	} else {
+		log.Printf("DB_SERVICE_TOKEN is not set, calls are not authenticated")
+	}
 	grpcServer := grpc.NewServer(opts...)
-	service.RegisterDatabaseTestServer(grpcServer, server.NewDatabaseServer(&pg.Options{
+	dbServer := server.NewDatabaseServer(&pg.Options{
 		User:     *dbUser,
 		Addr:     *dbAddr,
 		Password: os.Getenv("POSTGRESQL_PASSWORD"),
 		Network:  "tcp",
-	}))
+	})
+	service.RegisterDatabaseTestServer(grpcServer, dbServer)
+	go dbServer.RunWebhookDispatcher(context.Background(), *webhookPollInterval)
+
+	// Stop gracefully so that remaining spans are exported
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signals.Done()
+		grpcServer.GracefulStop()
+	}()
+
 	log.Printf("Server listening at %v", lis.Addr())
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
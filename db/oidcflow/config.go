@@ -0,0 +1,77 @@
+package oidcflow
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultIssuer is ITMO.ID realm
+const DefaultIssuer = "https://id.itmo.ru/auth/realms/itmo"
+
+// Config describes OpenID Connect provider and client registration
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// ISUClaim is name of ID token claim with ISU number of the user
+	ISUClaim string
+}
+
+// Flags registers command line flags of provider config, values are set after flag.Parse.
+// Client credentials default to ITMOID_CLIENT_ID and ITMOID_CLIENT_SECRET environment variables,
+// the secret can only be set by environment.
+func Flags(defaultRedirectURL string) *Config {
+	config := &Config{
+		ClientSecret: os.Getenv("ITMOID_CLIENT_SECRET"),
+		Scopes:       []string{"openid"},
+	}
+	flag.StringVar(&config.Issuer, "oidc-issuer", DefaultIssuer, "Issuer URL of OpenID Connect provider")
+	flag.StringVar(&config.ClientID, "oidc-client-id", os.Getenv("ITMOID_CLIENT_ID"), "Client ID registered at the provider")
+	flag.StringVar(&config.RedirectURL, "oidc-redirect-url", defaultRedirectURL, "URL the provider redirects to after login")
+	flag.Func("oidc-scopes", "Comma separated scopes to request (default \"openid\")", func(value string) error {
+		config.Scopes = strings.Split(value, ",")
+		return nil
+	})
+	flag.StringVar(&config.ISUClaim, "oidc-isu-claim", "isu", "ID token claim with ISU number of the user")
+	return config
+}
+
+// OAuth2 returns client config for the provider endpoint
+func (c *Config) OAuth2(endpoint oauth2.Endpoint) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       c.Scopes,
+	}
+}
+
+// ISU reads ISU number from ID token claims, the claim may be a number or a numeric string
+func (c *Config) ISU(claims map[string]json.RawMessage) (int64, error) {
+	raw, ok := claims[c.ISUClaim]
+	if !ok {
+		return 0, fmt.Errorf("ID token has no %q claim", c.ISUClaim)
+	}
+	var value json.Number
+	if err := json.Unmarshal(raw, &value); err != nil {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return 0, fmt.Errorf("claim %q is neither a number nor a string", c.ISUClaim)
+		}
+		value = json.Number(text)
+	}
+	isu, err := strconv.ParseInt(value.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("claim %q is not an ISU number: %v", c.ISUClaim, err)
+	}
+	return isu, nil
+}
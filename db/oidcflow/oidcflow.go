@@ -0,0 +1,114 @@
+// Package oidcflow keeps per-login state of OpenID Connect authorization code flow
+// with nonce and PKCE, shared by the REST API and the telegram bot
+package oidcflow
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNonceMismatch is returned when ID token was not issued for the login attempt
+var ErrNonceMismatch = errors.New("ID token nonce does not match login attempt")
+
+// Attempt is a single login attempt, it can be finished once before it expires
+type Attempt struct {
+	// State is sent to the provider and identifies the attempt on callback
+	State string
+	// Nonce must be returned in ID token
+	Nonce string
+	// Verifier is PKCE code verifier, only its S256 challenge is sent with authorization request
+	Verifier string
+	// Data is kept for the caller until the callback, e.g. an invitation code
+	Data      string
+	ExpiresAt time.Time
+}
+
+// Store keeps pending login attempts in memory
+type Store struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*Attempt
+}
+
+// NewStore creates a store of attempts which expire after ttl
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, attempts: make(map[string]*Attempt)}
+}
+
+func randomString() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// Begin starts a login attempt with fresh state, nonce and code verifier
+func (s *Store) Begin(data string) (*Attempt, error) {
+	attempt := &Attempt{Data: data, ExpiresAt: time.Now().Add(s.ttl)}
+	for _, field := range []*string{&attempt.State, &attempt.Nonce, &attempt.Verifier} {
+		value, err := randomString()
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for state, pending := range s.attempts {
+		if now.After(pending.ExpiresAt) {
+			delete(s.attempts, state)
+		}
+	}
+	s.attempts[attempt.State] = attempt
+	return attempt, nil
+}
+
+// Take finishes the attempt with given state, so it cannot be used again.
+// Returns false if there is no such attempt or it has expired.
+func (s *Store) Take(state string) (*Attempt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt, ok := s.attempts[state]
+	if !ok {
+		return nil, false
+	}
+	delete(s.attempts, state)
+	if time.Now().After(attempt.ExpiresAt) {
+		return nil, false
+	}
+	return attempt, true
+}
+
+// AuthCodeURL builds URL of authorization request with nonce and PKCE challenge
+func (a *Attempt) AuthCodeURL(config *oauth2.Config, state string) string {
+	challenge := sha256.Sum256([]byte(a.Verifier))
+	return config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", a.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// ExchangeOptions must be passed to Exchange of authorization code
+func (a *Attempt) ExchangeOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", a.Verifier)}
+}
+
+// VerifyNonce checks nonce claim of ID token
+func (a *Attempt) VerifyNonce(nonce string) error {
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(a.Nonce)) != 1 {
+		return ErrNonceMismatch
+	}
+	return nil
+}
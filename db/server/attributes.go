@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// attributeDefinition is a row of custom attribute schema registry
+type attributeDefinition struct {
+	tableName struct{} `pg:"attribute_definitions"`
+
+	Name            string                `pg:",pk"`
+	Type            service.AttributeType `pg:",use_zero"`
+	ValidationRegex string                `pg:",use_zero"`
+	Required        bool                  `pg:",use_zero"`
+	UserVisible     bool                  `pg:",use_zero"`
+	Description     string                `pg:",use_zero"`
+}
+
+var attributeNameRegex = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+func (d *attributeDefinition) toProto() *service.AttributeDefinition {
+	return &service.AttributeDefinition{
+		Name:            d.Name,
+		Type:            d.Type,
+		ValidationRegex: d.ValidationRegex,
+		Required:        d.Required,
+		UserVisible:     d.UserVisible,
+		Description:     d.Description,
+	}
+}
+
+// validate checks a value in its string form against the definition
+func (d *attributeDefinition) validate(value string) error {
+	switch d.Type {
+	case service.AttributeType_ATTRIBUTE_TYPE_INTEGER:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%v must be an integer", d.Name)
+		}
+	case service.AttributeType_ATTRIBUTE_TYPE_BOOLEAN:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%v must be true or false", d.Name)
+		}
+	}
+	if d.ValidationRegex != "" {
+		// Expression is validated when the attribute is defined
+		if !regexp.MustCompile(d.ValidationRegex).MatchString(value) {
+			return fmt.Errorf("%v does not match '%v'", d.Name, d.ValidationRegex)
+		}
+	}
+	return nil
+}
+
+// validateAttributes checks attributes of a user against the schema registry.
+// Only values that differ from the stored ones are validated,
+// so changing a definition does not lock users out of unrelated updates.
+func validateAttributes(ctx context.Context, db orm.DB, stored, attributes map[string]string) error {
+	var definitions []*attributeDefinition
+	if err := db.ModelContext(ctx, &definitions).Select(); err != nil {
+		return err
+	}
+	byName := make(map[string]*attributeDefinition, len(definitions))
+	for _, definition := range definitions {
+		byName[definition.Name] = definition
+	}
+
+	var problems []string
+	for name, value := range attributes {
+		if old, ok := stored[name]; ok && old == value {
+			continue
+		}
+		definition, ok := byName[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown attribute %v", name))
+			continue
+		}
+		if err := definition.validate(value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return status.Errorf(codes.InvalidArgument, "Invalid attributes: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DefineAttribute adds a custom user attribute or replaces its definition
+func (s *DatabaseTestServer) DefineAttribute(ctx context.Context, req *service.AttributeDefinition) (*service.UpdateResponse, error) {
+	if !attributeNameRegex.MatchString(req.GetName()) {
+		return nil, status.Errorf(codes.InvalidArgument, "Attribute name must match '%v'", attributeNameRegex)
+	}
+	if _, ok := service.AttributeType_name[int32(req.GetType())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown attribute type %v", req.GetType())
+	}
+	if _, err := regexp.Compile(req.GetValidationRegex()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid validation regex: %v", err)
+	}
+
+	definition := &attributeDefinition{
+		Name:            req.GetName(),
+		Type:            req.GetType(),
+		ValidationRegex: req.GetValidationRegex(),
+		Required:        req.GetRequired(),
+		UserVisible:     req.GetUserVisible(),
+		Description:     req.GetDescription(),
+	}
+	_, err := s.db.ModelContext(ctx, definition).
+		OnConflict("(name) DO UPDATE").
+		Set("type = EXCLUDED.type").
+		Set("validation_regex = EXCLUDED.validation_regex").
+		Set("required = EXCLUDED.required").
+		Set("user_visible = EXCLUDED.user_visible").
+		Set("description = EXCLUDED.description").
+		Insert()
+	if err != nil {
+		log.Printf("Error in DefineAttribute: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("Defined an attribute: %v", req)
+	return &service.UpdateResponse{}, nil
+}
+
+// ListAttributeDefinitions lists definitions of custom user attributes
+func (s *DatabaseTestServer) ListAttributeDefinitions(req *service.ListAttributeDefinitionsRequest, stream service.DatabaseTest_ListAttributeDefinitionsServer) error {
+	var definitions []*attributeDefinition
+	query := s.db.ModelContext(stream.Context(), &definitions).Order("name ASC")
+	if req.GetUserVisibleOnly() {
+		query.Where("user_visible")
+	}
+	if err := query.Select(); err != nil {
+		log.Printf("Error in ListAttributeDefinitions: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	for _, definition := range definitions {
+		if err := stream.Send(definition.toProto()); err != nil {
+			log.Printf("Error while sending attribute definition: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// DeleteAttributeDefinition removes a custom attribute and its values from every user
+func (s *DatabaseTestServer) DeleteAttributeDefinition(ctx context.Context, req *service.AttributeByNameRequest) (*service.UpdateResponse, error) {
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		res, err := tx.ModelContext(ctx, &attributeDefinition{Name: req.GetName()}).WherePK().Delete()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return status.Errorf(codes.NotFound, "Attribute %v not found", req.GetName())
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users SET attributes = attributes - ?0 WHERE attributes->?0 IS NOT NULL",
+			req.GetName())
+		return err
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in DeleteAttributeDefinition: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("Deleted an attribute: %v", req.GetName())
+	return &service.UpdateResponse{}, nil
+}
+
+// SetUserAttribute sets one custom attribute of a user inside a transaction,
+// so concurrent changes of other fields are not overwritten
+func (s *DatabaseTestServer) SetUserAttribute(ctx context.Context, req *service.SetUserAttributeRequest) (*service.User, error) {
+	user := &service.User{Id: req.GetUserId()}
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := selectForUpdate(ctx, tx, user); err != nil {
+			return err
+		}
+		previous := proto.Clone(user).(*service.User)
+		attributes := make(map[string]string, len(user.GetAttributes())+1)
+		for name, value := range user.GetAttributes() {
+			attributes[name] = value
+		}
+		attributes[req.GetName()] = req.GetValue()
+		if err := validateAttributes(ctx, tx, user.GetAttributes(), attributes); err != nil {
+			return err
+		}
+		user.Attributes = attributes
+
+		if _, err := tx.ModelContext(ctx, user).Column("attributes").WherePK().Update(); err != nil {
+			return err
+		}
+		log.Printf("Set attribute %v of user %v", req.GetName(), req.GetUserId())
+		return recordChange(ctx, tx, previous, user)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in SetUserAttribute: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return user, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// checkServiceToken fails with Unauthenticated unless the call carries the shared token
+func checkServiceToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(service.ServiceTokenMetadataKey)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) != 1 {
+		return status.Error(codes.Unauthenticated, "Service token is missing or wrong")
+	}
+	return nil
+}
+
+// RequireServiceToken returns server options rejecting calls without the token shared with trusted clients.
+// Role approvals rely on actors named in metadata, so only callers knowing the token may name them.
+func RequireServiceToken(token string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkServiceToken(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkServiceToken(stream.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
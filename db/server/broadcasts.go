@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/broadcast"
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// broadcastLease is for how long recipients are claimed by a bot,
+	// a failed delivery is retried once the lease is over
+	broadcastLease = 5 * time.Minute
+	// broadcastClaimLimit is the default number of recipients claimed at once
+	broadcastClaimLimit = 100
+)
+
+// broadcastRow is a row of broadcasts, filter fields are prefixed
+type broadcastRow struct {
+	tableName struct{} `pg:"broadcasts"`
+
+	Id             int64
+	Template       string       `pg:",notnull"`
+	FilterRole     service.Role `pg:",use_zero"`
+	FilterGroup    string       `pg:",use_zero"`
+	FilterHasPhone *bool
+	ScheduledAt    time.Time               `pg:",notnull"`
+	CreatedBy      int64                   `pg:",use_zero"`
+	CreatedAt      time.Time               `pg:"default:now(),notnull"`
+	Status         service.BroadcastStatus `pg:",use_zero"`
+	FinishedAt     *time.Time
+}
+
+// broadcastRecipient is a row of deliveries of broadcasts, recipients are added when a broadcast is started
+type broadcastRecipient struct {
+	tableName struct{} `pg:"broadcast_recipients"`
+
+	BroadcastID    int64                   `pg:",pk"`
+	UserID         int64                   `pg:",pk"`
+	TelegramChatID int64                   `pg:",notnull"`
+	Status         service.RecipientStatus `pg:",use_zero"`
+	Attempts       int32                   `pg:",use_zero"`
+	LastError      string                  `pg:",use_zero"`
+	SentAt         *time.Time
+	// LeasedUntil is when the claim of a bot sending to the recipient is over
+	LeasedUntil *time.Time
+}
+
+// recipientCount is a number of recipients of a broadcast with one status
+type recipientCount struct {
+	Status service.RecipientStatus
+	Count  int32
+}
+
+func (b *broadcastRow) toProto(counts []recipientCount) *service.Broadcast {
+	res := &service.Broadcast{
+		Id:       b.Id,
+		Template: b.Template,
+		Filter: &service.BroadcastFilter{
+			Role:     b.FilterRole,
+			Group:    b.FilterGroup,
+			HasPhone: b.FilterHasPhone,
+		},
+		ScheduledAt: timestamppb.New(b.ScheduledAt),
+		CreatedBy:   b.CreatedBy,
+		CreatedAt:   timestamppb.New(b.CreatedAt),
+		Status:      b.Status,
+	}
+	if b.FinishedAt != nil {
+		res.FinishedAt = timestamppb.New(*b.FinishedAt)
+	}
+	for _, count := range counts {
+		switch count.Status {
+		case service.RecipientStatus_RECIPIENT_STATUS_PENDING:
+			res.Pending = count.Count
+		case service.RecipientStatus_RECIPIENT_STATUS_SENT:
+			res.Sent = count.Count
+		case service.RecipientStatus_RECIPIENT_STATUS_FAILED:
+			res.Failed = count.Count
+		}
+	}
+	return res
+}
+
+func (r *broadcastRecipient) toProto() *service.BroadcastRecipient {
+	recipient := &service.BroadcastRecipient{
+		BroadcastId:    r.BroadcastID,
+		UserId:         r.UserID,
+		TelegramChatId: r.TelegramChatID,
+		Status:         r.Status,
+		Attempts:       r.Attempts,
+		LastError:      r.LastError,
+	}
+	if r.SentAt != nil {
+		recipient.SentAt = timestamppb.New(*r.SentAt)
+	}
+	return recipient
+}
+
+// broadcastWithCounts converts a broadcast counting its recipients
+func (s *DatabaseTestServer) broadcastWithCounts(ctx context.Context, b *broadcastRow) (*service.Broadcast, error) {
+	var counts []recipientCount
+	err := s.db.ModelContext(ctx, (*broadcastRecipient)(nil)).
+		Column("status").
+		ColumnExpr("count(*) AS count").
+		Where("broadcast_id = ?", b.Id).
+		Group("status").
+		Select(&counts)
+	if err != nil {
+		return nil, err
+	}
+	return b.toProto(counts), nil
+}
+
+// startBroadcast adds users matching the filter as pending recipients, a broadcast without them is finished at once
+func startBroadcast(ctx context.Context, tx *pg.Tx, b *broadcastRow) error {
+	users := tx.ModelContext(ctx, (*service.User)(nil)).
+		ColumnExpr("?, id, telegram_chat_id, ?, 0, ''", b.Id, service.RecipientStatus_RECIPIENT_STATUS_PENDING).
+		Where("telegram_chat_id IS NOT NULL").
+		Where("deleted IS NOT TRUE")
+	if b.FilterRole != service.Role_ROLE_UNSPECIFIED {
+		users.Where("role = ?", b.FilterRole)
+	}
+	if b.FilterGroup != "" {
+		group, err := json.Marshal([]string{b.FilterGroup})
+		if err != nil {
+			return err
+		}
+		users.Where("coalesce(groups, '[]'::jsonb) @> ?::jsonb", string(group))
+	}
+	if b.FilterHasPhone != nil {
+		users.Where("(coalesce(phone_number, '') <> '') = ?", *b.FilterHasPhone)
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO broadcast_recipients (broadcast_id, user_id, telegram_chat_id, status, attempts, last_error)
+		?`, users)
+	if err != nil {
+		return err
+	}
+
+	b.Status = service.BroadcastStatus_BROADCAST_STATUS_SENDING
+	if res.RowsAffected() == 0 {
+		now := time.Now()
+		b.Status = service.BroadcastStatus_BROADCAST_STATUS_FINISHED
+		b.FinishedAt = &now
+	}
+	if _, err := tx.ModelContext(ctx, b).Column("status", "finished_at").WherePK().Update(); err != nil {
+		return err
+	}
+	log.Printf("Started broadcast %v to %v recipients", b.Id, res.RowsAffected())
+	return nil
+}
+
+// CreateBroadcast schedules a message to every user with a linked chat who matches the filter, not while impersonating.
+// The creator is the actor of the call, who must be a read-write admin.
+func (s *DatabaseTestServer) CreateBroadcast(ctx context.Context, req *service.Broadcast) (*service.Broadcast, error) {
+	if err := checkNotImpersonated(ctx, "Broadcasts cannot be created while impersonating"); err != nil {
+		return nil, err
+	}
+	actor := actorFromContext(ctx)
+	if actor == nil {
+		return nil, status.Error(codes.PermissionDenied, "Broadcasts must be created on behalf of an admin")
+	}
+	if err := checkReadWriteAdmin(ctx, s.db, *actor, "Only read-write admins can create broadcasts"); err != nil {
+		return nil, err
+	}
+	if req.GetTemplate() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Broadcast must have a template")
+	}
+	if _, err := broadcast.Parse(req.GetTemplate()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Template cannot be rendered: %v", err)
+	}
+	filter := req.GetFilter()
+	if _, ok := service.Role_name[int32(filter.GetRole())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown role %v", filter.GetRole())
+	}
+
+	scheduledAt := time.Now()
+	if req.GetScheduledAt() != nil {
+		scheduledAt = req.GetScheduledAt().AsTime()
+	}
+	created := &broadcastRow{
+		Template:       req.GetTemplate(),
+		FilterRole:     filter.GetRole(),
+		FilterGroup:    filter.GetGroup(),
+		FilterHasPhone: filter.HasPhone,
+		ScheduledAt:    scheduledAt,
+		CreatedBy:      *actor,
+		CreatedAt:      time.Now(),
+		Status:         service.BroadcastStatus_BROADCAST_STATUS_SCHEDULED,
+	}
+	if _, err := s.db.ModelContext(ctx, created).Insert(); err != nil {
+		log.Printf("Error in CreateBroadcast: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("User %v scheduled broadcast %v at %v", created.CreatedBy, created.Id, created.ScheduledAt.Format(time.RFC3339))
+	return created.toProto(nil), nil
+}
+
+// GetBroadcast returns a broadcast with counts of its recipients by delivery status
+func (s *DatabaseTestServer) GetBroadcast(ctx context.Context, req *service.BroadcastByIDRequest) (*service.Broadcast, error) {
+	stored := &broadcastRow{Id: req.GetId()}
+	if err := s.db.ModelContext(ctx, stored).WherePK().Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "Broadcast with id %v not found", req.GetId())
+		}
+		log.Printf("Error in GetBroadcast: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res, err := s.broadcastWithCounts(ctx, stored)
+	if err != nil {
+		log.Printf("Error in GetBroadcast: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return res, nil
+}
+
+// ListBroadcastRecipients lists recipients of a broadcast ordered by user ID
+func (s *DatabaseTestServer) ListBroadcastRecipients(req *service.ListBroadcastRecipientsRequest, stream service.DatabaseTest_ListBroadcastRecipientsServer) error {
+	var recipients []*broadcastRecipient
+	query := s.db.ModelContext(stream.Context(), &recipients).
+		Where("broadcast_id = ?", req.GetBroadcastId()).
+		Order("user_id ASC")
+	if req.GetStatus() != service.RecipientStatus_RECIPIENT_STATUS_UNSPECIFIED {
+		query.Where("status = ?", req.GetStatus())
+	}
+	if err := query.Select(); err != nil {
+		log.Printf("Error in ListBroadcastRecipients: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, recipient := range recipients {
+		if err := stream.Send(recipient.toProto()); err != nil {
+			log.Printf("Error while sending broadcast recipient: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// StartDueBroadcasts resolves recipients of scheduled broadcasts whose time has come,
+// then lists every broadcast which is being sent, oldest first
+func (s *DatabaseTestServer) StartDueBroadcasts(req *service.StartDueBroadcastsRequest, stream service.DatabaseTest_StartDueBroadcastsServer) error {
+	ctx := stream.Context()
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var due []*broadcastRow
+		err := tx.ModelContext(ctx, &due).
+			Where("status = ?", service.BroadcastStatus_BROADCAST_STATUS_SCHEDULED).
+			Where("scheduled_at <= now()").
+			Order("id ASC").
+			For("UPDATE SKIP LOCKED").
+			Select()
+		if err != nil {
+			return err
+		}
+		for _, b := range due {
+			if err := startBroadcast(ctx, tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error in StartDueBroadcasts: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	var sending []*broadcastRow
+	err = s.db.ModelContext(ctx, &sending).
+		Where("status = ?", service.BroadcastStatus_BROADCAST_STATUS_SENDING).
+		Order("id ASC").
+		Select()
+	if err != nil {
+		log.Printf("Error in StartDueBroadcasts: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, b := range sending {
+		res, err := s.broadcastWithCounts(ctx, b)
+		if err != nil {
+			log.Printf("Error in StartDueBroadcasts: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := stream.Send(res); err != nil {
+			log.Printf("Error while sending broadcast: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// ClaimBroadcastRecipients leases pending recipients of a broadcast to the caller, ordered by user ID,
+// so several bots sending at once do not message anyone twice. Recipients are claimed again once the lease is over.
+func (s *DatabaseTestServer) ClaimBroadcastRecipients(req *service.ClaimBroadcastRecipientsRequest, stream service.DatabaseTest_ClaimBroadcastRecipientsServer) error {
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = broadcastClaimLimit
+	}
+	var recipients []*broadcastRecipient
+	_, err := s.db.QueryContext(stream.Context(), &recipients, `
+		UPDATE broadcast_recipients SET leased_until = now() + ?0 * interval '1 second'
+		WHERE (broadcast_id, user_id) IN (
+			SELECT broadcast_id, user_id FROM broadcast_recipients
+			WHERE broadcast_id = ?1 AND status = ?2 AND (leased_until IS NULL OR leased_until <= now())
+			ORDER BY user_id LIMIT ?3
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		int(broadcastLease.Seconds()), req.GetBroadcastId(), service.RecipientStatus_RECIPIENT_STATUS_PENDING, limit)
+	if err != nil {
+		log.Printf("Error in ClaimBroadcastRecipients: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	sort.Slice(recipients, func(i, j int) bool { return recipients[i].UserID < recipients[j].UserID })
+	for _, recipient := range recipients {
+		if err := stream.Send(recipient.toProto()); err != nil {
+			log.Printf("Error while sending broadcast recipient: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// RecordBroadcastDelivery records an attempt to send a broadcast to a recipient.
+// The broadcast is finished when none of its recipients are pending.
+func (s *DatabaseTestServer) RecordBroadcastDelivery(ctx context.Context, req *service.BroadcastDelivery) (*service.BroadcastRecipient, error) {
+	if _, ok := service.RecipientStatus_name[int32(req.GetStatus())]; !ok || req.GetStatus() == service.RecipientStatus_RECIPIENT_STATUS_UNSPECIFIED {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown recipient status %v", req.GetStatus())
+	}
+
+	recipient := &broadcastRecipient{}
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		query := tx.ModelContext(ctx, recipient).
+			Set("status = ?", req.GetStatus()).
+			Set("attempts = attempts + 1").
+			Set("last_error = ?", req.GetError()).
+			Where("broadcast_id = ?", req.GetBroadcastId()).
+			Where("user_id = ?", req.GetUserId()).
+			Where("status = ?", service.RecipientStatus_RECIPIENT_STATUS_PENDING).
+			Returning("*")
+		if req.GetStatus() == service.RecipientStatus_RECIPIENT_STATUS_SENT {
+			query.Set("sent_at = now()")
+		}
+		res, err := query.Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return status.Errorf(codes.NotFound, "Broadcast %v has no pending recipient %v", req.GetBroadcastId(), req.GetUserId())
+		}
+
+		res, err = tx.ExecContext(ctx, `
+			UPDATE broadcasts SET status = ?0, finished_at = now()
+			WHERE id = ?1 AND status = ?2 AND NOT EXISTS (
+				SELECT 1 FROM broadcast_recipients WHERE broadcast_id = ?1 AND status = ?3)`,
+			service.BroadcastStatus_BROADCAST_STATUS_FINISHED, req.GetBroadcastId(),
+			service.BroadcastStatus_BROADCAST_STATUS_SENDING, service.RecipientStatus_RECIPIENT_STATUS_PENDING)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() != 0 {
+			log.Printf("Finished broadcast %v", req.GetBroadcastId())
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in RecordBroadcastDelivery: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return recipient.toProto(), nil
+}
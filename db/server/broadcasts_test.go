@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateBroadcastIsMadeByActor(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "Reader", Role: service.Role_ROLE_READ_ONLY_ADMIN})
+	req := &service.Broadcast{Template: "Hello, {{.Name}}!", CreatedBy: 2}
+
+	created, err := s.CreateBroadcast(asActor(1), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetCreatedBy() != 1 {
+		t.Errorf("creator is %v, want the actor", created.GetCreatedBy())
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"without actor":   context.Background(),
+		"read-only admin": asActor(2),
+	} {
+		if _, err := s.CreateBroadcast(ctx, req); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: got %v, want PermissionDenied", name, err)
+		}
+	}
+}
+
+// startedBroadcast creates a broadcast to three users with linked chats and starts it
+func startedBroadcast(t *testing.T, s *DatabaseTestServer) int64 {
+	t.Helper()
+	addUsers(t, s, &service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+	for id := int64(2); id <= 4; id++ {
+		chat := id * 10
+		addUsers(t, s, &service.User{Id: id, Name: "User", Role: service.Role_ROLE_USER, TelegramChatId: &chat})
+	}
+	created, err := s.CreateBroadcast(asActor(1), &service.Broadcast{
+		Template: "Hello!",
+		Filter:   &service.BroadcastFilter{Role: service.Role_ROLE_USER},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartDueBroadcasts(&service.StartDueBroadcastsRequest{}, newSentStream[service.Broadcast](context.Background())); err != nil {
+		t.Fatal(err)
+	}
+	return created.GetId()
+}
+
+func claim(t *testing.T, s *DatabaseTestServer, id int64, limit int32) []int64 {
+	t.Helper()
+	stream := newSentStream[service.BroadcastRecipient](context.Background())
+	if err := s.ClaimBroadcastRecipients(&service.ClaimBroadcastRecipientsRequest{BroadcastId: id, Limit: limit}, stream); err != nil {
+		t.Fatal(err)
+	}
+	claimed := make([]int64, 0, len(stream.sent))
+	for _, recipient := range stream.sent {
+		claimed = append(claimed, recipient.GetUserId())
+	}
+	return claimed
+}
+
+func TestClaimBroadcastRecipientsLeasesEveryRecipientOnce(t *testing.T) {
+	s := newTestServer(t)
+	id := startedBroadcast(t, s)
+
+	first := claim(t, s, id, 2)
+	second := claim(t, s, id, 2)
+	if len(first) != 2 || len(second) != 1 || first[0] != 2 || first[1] != 3 || second[0] != 4 {
+		t.Fatalf("claimed %v then %v, want [2 3] then [4]", first, second)
+	}
+	if rest := claim(t, s, id, 2); len(rest) != 0 {
+		t.Errorf("leased recipients %v were claimed again", rest)
+	}
+}
+
+func TestClaimBroadcastRecipientsRetriesOnceLeaseIsOver(t *testing.T) {
+	s := newTestServer(t)
+	id := startedBroadcast(t, s)
+	claim(t, s, id, 0)
+
+	_, err := s.RecordBroadcastDelivery(context.Background(), &service.BroadcastDelivery{
+		BroadcastId: id,
+		UserId:      2,
+		Status:      service.RecipientStatus_RECIPIENT_STATUS_PENDING,
+		Error:       "network is down",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried := claim(t, s, id, 0); len(retried) != 0 {
+		t.Fatalf("recipients %v were retried before the lease was over", retried)
+	}
+
+	if _, err := s.db.Exec("UPDATE broadcast_recipients SET leased_until = now() - interval '1 second'"); err != nil {
+		t.Fatal(err)
+	}
+	if retried := claim(t, s, id, 0); len(retried) != 3 {
+		t.Errorf("claimed %v after the lease was over, want every pending recipient", retried)
+	}
+}
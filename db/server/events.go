@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchPollInterval is how often WatchUserEvents checks for new events
+const watchPollInterval = time.Second
+
+// watchBatchSize limits how many events are read at once
+const watchBatchSize = 100
+
+// watchGapTimeout is how long WatchUserEvents waits for a skipped event ID to be committed,
+// IDs are taken when events are inserted, so a transaction committed later can have a lower one
+const watchGapTimeout = time.Minute
+
+// watchGapWindow is how far below the last seen event ID skipped IDs are waited for,
+// older ones, e.g. after a large jump of the sequence, are given up at once
+const watchGapWindow = watchBatchSize
+
+// eventGaps are event IDs below the last seen one which were not seen yet, with the time they were skipped at
+type eventGaps map[int64]time.Time
+
+// skip records IDs between lastID and the next seen ID which are within watchGapWindow
+func (gaps eventGaps) skip(lastID, nextID int64, now time.Time) {
+	from := lastID + 1
+	if from < nextID-watchGapWindow {
+		from = nextID - watchGapWindow
+	}
+	for id := from; id < nextID; id++ {
+		gaps[id] = now
+	}
+}
+
+// expire gives up IDs skipped longer than watchGapTimeout ago or out of watchGapWindow below lastID
+func (gaps eventGaps) expire(lastID int64, now time.Time) {
+	for id, skipped := range gaps {
+		if now.Sub(skipped) > watchGapTimeout || id < lastID-watchGapWindow {
+			delete(gaps, id)
+		}
+	}
+}
+
+func (gaps eventGaps) ids() []int64 {
+	ids := make([]int64, 0, len(gaps))
+	for id := range gaps {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Types of user events
+const (
+	EventUserCreated           = "user.created"
+	EventUserUpdated           = "user.updated"
+	EventUserDeleted           = "user.deleted"
+	EventUserNameChanged       = "user.name_changed"
+	EventUserPhoneChanged      = "user.phone_changed"
+	EventUserRoleChanged       = "user.role_changed"
+	EventUserAttributesChanged = "user.attributes_changed"
+	EventUserGroupsChanged     = "user.groups_changed"
+)
+
+// EventTypes lists every event type a subscriber can filter by
+var EventTypes = []string{
+	EventUserCreated,
+	EventUserUpdated,
+	EventUserDeleted,
+	EventUserNameChanged,
+	EventUserPhoneChanged,
+	EventUserRoleChanged,
+	EventUserAttributesChanged,
+	EventUserGroupsChanged,
+}
+
+// userEvent is a row of the outbox of user changes.
+// Type is one of created, updated or deleted, more specific types are listed in Tags.
+// User has an explicit type, otherwise it is taken for a relation by UserID and never stored.
+type userEvent struct {
+	tableName struct{} `pg:"user_events"`
+
+	Id        int64
+	Type      string        `pg:",notnull"`
+	Tags      []string      `pg:",array"`
+	UserID    int64         `pg:",notnull"`
+	User      *service.User `pg:"type:jsonb"`
+	Previous  *service.User
+	ActorID   *int64
+	CreatedAt time.Time `pg:"default:now(),notnull"`
+	// ImpersonatedBy is the admin who acted on behalf of ActorID
+	ImpersonatedBy *int64
+}
+
+func (e *userEvent) toProto() *service.UserEvent {
+	return &service.UserEvent{
+		Id:             e.Id,
+		Type:           e.Type,
+		Tags:           e.Tags,
+		UserId:         e.UserID,
+		User:           e.User,
+		Previous:       e.Previous,
+		ActorId:        e.ActorID,
+		CreatedAt:      timestamppb.New(e.CreatedAt),
+		ImpersonatedBy: e.ImpersonatedBy,
+	}
+}
+
+// matches reports whether the event passes a filter of event types, empty filter matches everything
+func (e *userEvent) matches(filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, t := range filter {
+		if t == e.Type {
+			return true
+		}
+		for _, tag := range e.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attributesEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func groupsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newUserEvent describes a change of the user, previous is nil if the user was created
+func newUserEvent(ctx context.Context, previous, user *service.User) *userEvent {
+	event := &userEvent{
+		Type:           EventUserUpdated,
+		Tags:           make([]string, 0),
+		UserID:         user.GetId(),
+		User:           user,
+		Previous:       previous,
+		ActorID:        actorFromContext(ctx),
+		CreatedAt:      time.Now(),
+		ImpersonatedBy: impersonatorFromContext(ctx),
+	}
+	switch {
+	case previous == nil:
+		event.Type = EventUserCreated
+		return event
+	case user.GetDeleted():
+		event.Type = EventUserDeleted
+		return event
+	}
+
+	if previous.GetName() != user.GetName() {
+		event.Tags = append(event.Tags, EventUserNameChanged)
+	}
+	if previous.GetPhoneNumber() != user.GetPhoneNumber() {
+		event.Tags = append(event.Tags, EventUserPhoneChanged)
+	}
+	if previous.GetRole() != user.GetRole() {
+		event.Tags = append(event.Tags, EventUserRoleChanged)
+	}
+	if !attributesEqual(previous.GetAttributes(), user.GetAttributes()) {
+		event.Tags = append(event.Tags, EventUserAttributesChanged)
+	}
+	if !groupsEqual(previous.GetGroups(), user.GetGroups()) {
+		event.Tags = append(event.Tags, EventUserGroupsChanged)
+	}
+	return event
+}
+
+// enqueueEvent stores the event and schedules its delivery to subscribed webhooks
+func enqueueEvent(ctx context.Context, tx *pg.Tx, event *userEvent) error {
+	if _, err := tx.ModelContext(ctx, event).Insert(); err != nil {
+		return err
+	}
+
+	var webhooks []*webhook
+	if err := tx.ModelContext(ctx, &webhooks).Select(); err != nil {
+		return err
+	}
+	for _, hook := range webhooks {
+		if !event.matches(hook.EventTypes) {
+			continue
+		}
+		_, err := tx.ModelContext(ctx, &webhookDelivery{
+			WebhookID:     hook.Id,
+			EventID:       event.Id,
+			Status:        service.DeliveryStatus_DELIVERY_STATUS_PENDING,
+			NextAttemptAt: event.CreatedAt,
+		}).Insert()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange records a new version of the user and emits an event about the change,
+// previous is nil if the user was created
+func recordChange(ctx context.Context, tx *pg.Tx, previous, user *service.User) error {
+	if err := recordVersion(ctx, tx, user); err != nil {
+		return err
+	}
+	return enqueueEvent(ctx, tx, newUserEvent(ctx, previous, user))
+}
+
+// WatchUserEvents streams user change events recorded after given event,
+// then keeps streaming new events until the call is cancelled.
+// Events committed after ones with higher IDs are sent late rather than skipped, see watchGapTimeout.
+func (s *DatabaseTestServer) WatchUserEvents(req *service.WatchUserEventsRequest, stream service.DatabaseTest_WatchUserEventsServer) error {
+	ctx := stream.Context()
+	lastID := req.GetAfterId()
+	if req.GetOnlyNew() {
+		err := s.db.ModelContext(ctx, (*userEvent)(nil)).ColumnExpr("coalesce(max(id), 0)").Select(&lastID)
+		if err != nil {
+			log.Printf("Error in WatchUserEvents: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	gaps := make(eventGaps)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		gaps.expire(lastID, time.Now())
+		var events []*userEvent
+		query := s.db.ModelContext(ctx, &events).
+			Order("id ASC").
+			Limit(watchBatchSize)
+		if len(gaps) > 0 {
+			query.Where("id > ? OR id IN (?)", lastID, pg.In(gaps.ids()))
+		} else {
+			query.Where("id > ?", lastID)
+		}
+		if err := query.Select(); err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			log.Printf("Error in WatchUserEvents: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+		for _, event := range events {
+			if event.Id > lastID {
+				gaps.skip(lastID, event.Id, time.Now())
+				lastID = event.Id
+			} else {
+				delete(gaps, event.Id)
+			}
+			if !event.matches(req.GetEventTypes()) {
+				continue
+			}
+			if err := stream.Send(event.toProto()); err != nil {
+				log.Printf("Error while sending user event: %v", err)
+				return status.Error(codes.Internal, err.Error())
+			}
+		}
+		if len(events) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
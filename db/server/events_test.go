@@ -0,0 +1,51 @@
+package server
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEventGapsAreBoundedByWindow(t *testing.T) {
+	gaps := make(eventGaps)
+	now := time.Now()
+
+	gaps.skip(0, 1_000_000, now)
+	if len(gaps) != watchGapWindow {
+		t.Fatalf("a jump of the sequence left %v gaps, want %v", len(gaps), watchGapWindow)
+	}
+	ids := gaps.ids()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if ids[0] != 1_000_000-watchGapWindow || ids[len(ids)-1] != 999_999 {
+		t.Errorf("gaps are from %v to %v, want the ones right below the seen ID", ids[0], ids[len(ids)-1])
+	}
+
+	gaps.skip(1_000_000, 1_000_050, now)
+	gaps.expire(1_000_050, now)
+	if len(gaps) > watchGapWindow+1 {
+		t.Errorf("%v gaps are kept, want at most %v", len(gaps), watchGapWindow+1)
+	}
+	for id := range gaps {
+		if id < 1_000_050-watchGapWindow {
+			t.Errorf("gap %v is out of the window", id)
+		}
+	}
+}
+
+func TestEventGapsExpire(t *testing.T) {
+	gaps := make(eventGaps)
+	now := time.Now()
+	gaps.skip(1, 4, now)
+	if len(gaps) != 2 {
+		t.Fatalf("got gaps %v, want 2 and 3", gaps)
+	}
+
+	gaps.expire(4, now.Add(watchGapTimeout/2))
+	if len(gaps) != 2 {
+		t.Errorf("gaps %v expired too early", gaps)
+	}
+	gaps.expire(4, now.Add(2*watchGapTimeout))
+	if len(gaps) != 0 {
+		t.Errorf("gaps %v did not expire", gaps)
+	}
+}
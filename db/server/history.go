@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// userVersion is a row of temporal user history.
+// A version is valid from ValidFrom until ValidFrom of the next version.
+type userVersion struct {
+	tableName struct{} `pg:"user_versions"`
+
+	UserID      int64  `pg:",pk"`
+	Version     int64  `pg:",pk"`
+	Name        string `pg:",use_zero"`
+	PhoneNumber *string
+	Role        service.Role `pg:",use_zero"`
+	ValidFrom   time.Time    `pg:"default:now(),notnull"`
+	MergedFrom  *int64
+	Attributes  map[string]string
+	ChangedBy   *int64
+	Groups      []string
+	// ImpersonatedBy is the admin who made the change on behalf of ChangedBy
+	ImpersonatedBy *int64
+}
+
+// backfillHistoryQuery records a first version for users which existed before history was kept
+const backfillHistoryQuery = `
+INSERT INTO user_versions (user_id, version, name, phone_number, role, valid_from, attributes, groups)
+SELECT id, 1, coalesce(name, ''), phone_number, coalesce(role, 0), now(), attributes, groups FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM user_versions v WHERE v.user_id = u.id)`
+
+func (v *userVersion) toProto() *service.UserVersion {
+	return &service.UserVersion{
+		Version: v.Version,
+		User: &service.User{
+			Id:          v.UserID,
+			Name:        v.Name,
+			PhoneNumber: v.PhoneNumber,
+			Role:        v.Role,
+			Attributes:  v.Attributes,
+			Groups:      v.Groups,
+		},
+		ValidFrom:      timestamppb.New(v.ValidFrom),
+		MergedFrom:     v.MergedFrom,
+		ChangedBy:      v.ChangedBy,
+		ImpersonatedBy: v.ImpersonatedBy,
+	}
+}
+
+// actorFromContext returns ID of the user on whose behalf the call is made, if the caller provided it
+func actorFromContext(ctx context.Context) *int64 {
+	return idFromMetadata(ctx, service.ActorMetadataKey)
+}
+
+// impersonatorFromContext returns ID of the admin who impersonates the actor, nil if the actor is not impersonated
+func impersonatorFromContext(ctx context.Context) *int64 {
+	return idFromMetadata(ctx, service.ImpersonatorMetadataKey)
+}
+
+// checkNotImpersonated refuses calls made while an admin impersonates the actor,
+// records they make, e.g. role change requests, name only the actor and not the admin
+func checkNotImpersonated(ctx context.Context, message string) error {
+	if impersonatorFromContext(ctx) != nil {
+		return status.Error(codes.PermissionDenied, message)
+	}
+	return nil
+}
+
+func idFromMetadata(ctx context.Context, key string) *int64 {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return nil
+	}
+	actor, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &actor
+}
+
+// lastVersion returns number of the latest version of the user, 0 if there are none
+func lastVersion(ctx context.Context, tx *pg.Tx, userID int64) (last int64, err error) {
+	_, err = tx.QueryOneContext(ctx, pg.Scan(&last),
+		"SELECT coalesce(max(version), 0) FROM user_versions WHERE user_id = ?", userID)
+	return
+}
+
+// recordVersion appends current state of the user to its history
+func recordVersion(ctx context.Context, tx *pg.Tx, user *service.User) error {
+	last, err := lastVersion(ctx, tx, user.GetId())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ModelContext(ctx, &userVersion{
+		UserID:         user.GetId(),
+		Version:        last + 1,
+		Name:           user.GetName(),
+		PhoneNumber:    user.PhoneNumber,
+		Role:           user.GetRole(),
+		ValidFrom:      time.Now(),
+		Attributes:     user.GetAttributes(),
+		ChangedBy:      actorFromContext(ctx),
+		Groups:         user.GetGroups(),
+		ImpersonatedBy: impersonatorFromContext(ctx),
+	}).Insert()
+	return err
+}
+
+// GetUserAt retrieves the version of a user that was current at given time
+func (s *DatabaseTestServer) GetUserAt(ctx context.Context, req *service.UserAtRequest) (*service.User, error) {
+	at := time.Now()
+	if req.GetTimestamp() != nil {
+		at = req.GetTimestamp().AsTime()
+	}
+
+	version := &userVersion{}
+	err := s.db.ModelContext(ctx, version).
+		Where("user_id = ?", req.GetId()).
+		Where("valid_from <= ?", at).
+		Where("merged_from IS NULL").
+		Order("version DESC").
+		Limit(1).
+		Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "User with id %v has no version at %v", req.GetId(), at)
+		}
+		log.Printf("Error in GetUserAt: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return version.toProto().GetUser(), nil
+}
+
+// ListUserVersions lists every recorded version of a user, oldest first
+func (s *DatabaseTestServer) ListUserVersions(req *service.UserByIDRequest, stream service.DatabaseTest_ListUserVersionsServer) error {
+	var versions []*userVersion
+	err := s.db.ModelContext(stream.Context(), &versions).
+		Where("user_id = ?", req.GetId()).
+		Order("version ASC").
+		Select()
+	if err != nil {
+		log.Printf("Error in ListUserVersions: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	if len(versions) == 0 {
+		return status.Errorf(codes.NotFound, "User with id %v has no recorded versions", req.GetId())
+	}
+
+	for _, version := range versions {
+		if err := stream.Send(version.toProto()); err != nil {
+			log.Printf("Error while sending version: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
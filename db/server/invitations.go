@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// invitationCodeLength is length of random part of a code in bytes,
+// encoded code must fit into Telegram deep link parameter
+const invitationCodeLength = 12
+
+// invitation is a row of invitation codes
+type invitation struct {
+	tableName struct{} `pg:"invitations"`
+
+	Code      string       `pg:",pk"`
+	Role      service.Role `pg:",use_zero"`
+	Groups    []string     `pg:",array"`
+	ExpiresAt time.Time    `pg:",notnull"`
+	MaxUses   int32        `pg:",use_zero"`
+	Uses      int32        `pg:",use_zero"`
+	CreatedBy int64        `pg:",use_zero"`
+	CreatedAt time.Time    `pg:"default:now(),notnull"`
+	Revoked   bool         `pg:",use_zero"`
+}
+
+// redemption is a row of invitation uses
+type redemption struct {
+	tableName struct{} `pg:"invitation_redemptions"`
+
+	Code       string    `pg:",pk"`
+	UserID     int64     `pg:",pk"`
+	RedeemedAt time.Time `pg:"default:now(),notnull"`
+}
+
+func (i *invitation) toProto() *service.Invitation {
+	return &service.Invitation{
+		Code:      i.Code,
+		Role:      i.Role,
+		Groups:    i.Groups,
+		ExpiresAt: timestamppb.New(i.ExpiresAt),
+		MaxUses:   i.MaxUses,
+		Uses:      i.Uses,
+		CreatedBy: i.CreatedBy,
+		CreatedAt: timestamppb.New(i.CreatedAt),
+		Revoked:   i.Revoked,
+	}
+}
+
+func generateInvitationCode() (string, error) {
+	buffer := make([]byte, invitationCodeLength)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// addGroups returns union of groups keeping their order
+func addGroups(groups []string, added ...string) []string {
+	result := append([]string{}, groups...)
+	for _, group := range added {
+		found := false
+		for _, existing := range result {
+			found = found || existing == group
+		}
+		if !found {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// CreateInvitation creates an invitation code which grants a role and groups, not while impersonating.
+// The creator is the actor of the call, who must be a read-write admin.
+func (s *DatabaseTestServer) CreateInvitation(ctx context.Context, req *service.Invitation) (*service.Invitation, error) {
+	if err := checkNotImpersonated(ctx, "Invitations cannot be created while impersonating"); err != nil {
+		return nil, err
+	}
+	actor := actorFromContext(ctx)
+	if actor == nil {
+		return nil, status.Error(codes.PermissionDenied, "Invitations must be created on behalf of an admin")
+	}
+	if err := checkReadWriteAdmin(ctx, s.db, *actor, "Only read-write admins can create invitations"); err != nil {
+		return nil, err
+	}
+	if _, ok := service.Role_name[int32(req.GetRole())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown role %v", req.GetRole())
+	}
+	if req.GetExpiresAt() == nil || req.GetExpiresAt().AsTime().Before(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "Invitation must expire in the future")
+	}
+	if req.GetRole() == service.Role_ROLE_UNSPECIFIED && len(req.GetGroups()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invitation must grant a role or groups")
+	}
+
+	code, err := generateInvitationCode()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	maxUses := req.GetMaxUses()
+	if maxUses <= 0 {
+		maxUses = 1
+	}
+	created := &invitation{
+		Code:      code,
+		Role:      req.GetRole(),
+		Groups:    addGroups(nil, req.GetGroups()...),
+		ExpiresAt: req.GetExpiresAt().AsTime(),
+		MaxUses:   maxUses,
+		CreatedBy: *actor,
+		CreatedAt: time.Now(),
+	}
+	if _, err := s.db.ModelContext(ctx, created).Insert(); err != nil {
+		log.Printf("Error in CreateInvitation: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("User %v created an invitation for role %v and groups %v", created.CreatedBy, created.Role, created.Groups)
+	return created.toProto(), nil
+}
+
+// ListInvitations lists invitations, newest first
+func (s *DatabaseTestServer) ListInvitations(req *service.ListInvitationsRequest, stream service.DatabaseTest_ListInvitationsServer) error {
+	var invitations []*invitation
+	if err := s.db.ModelContext(stream.Context(), &invitations).Order("created_at DESC").Select(); err != nil {
+		log.Printf("Error in ListInvitations: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, invitation := range invitations {
+		if err := stream.Send(invitation.toProto()); err != nil {
+			log.Printf("Error while sending invitation: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// RevokeInvitation makes an invitation unusable, not while impersonating
+func (s *DatabaseTestServer) RevokeInvitation(ctx context.Context, req *service.InvitationByCodeRequest) (*service.UpdateResponse, error) {
+	if err := checkNotImpersonated(ctx, "Invitations cannot be revoked while impersonating"); err != nil {
+		return nil, err
+	}
+	res, err := s.db.ModelContext(ctx, &invitation{Code: req.GetCode()}).Set("revoked = TRUE").WherePK().Update()
+	if err != nil {
+		log.Printf("Error in RevokeInvitation: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if res.RowsAffected() == 0 {
+		return nil, status.Errorf(codes.NotFound, "Invitation %v not found", req.GetCode())
+	}
+	log.Printf("Revoked invitation %v", req.GetCode())
+	return &service.UpdateResponse{}, nil
+}
+
+// RedeemInvitation grants role and groups of an invitation to a user, creating the user if needed.
+// Admin roles are requested on behalf of the creator of the invitation, see holdEscalation.
+// Deleted users cannot redeem invitations, FailedPrecondition is returned instead.
+func (s *DatabaseTestServer) RedeemInvitation(ctx context.Context, req *service.RedeemInvitationRequest) (*service.RedeemInvitationResponse, error) {
+	var updated *service.User
+	var request *roleChangeRequest
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		redeemed := &invitation{Code: req.GetCode()}
+		err := tx.ModelContext(ctx, redeemed).WherePK().For("UPDATE").Select()
+		if err == pg.ErrNoRows {
+			return status.Error(codes.NotFound, "Invitation not found")
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case redeemed.Revoked:
+			return status.Error(codes.FailedPrecondition, "Invitation was revoked")
+		case redeemed.ExpiresAt.Before(time.Now()):
+			return status.Error(codes.FailedPrecondition, "Invitation has expired")
+		case redeemed.Uses >= redeemed.MaxUses:
+			return status.Error(codes.ResourceExhausted, "Invitation was used up")
+		}
+
+		use := &redemption{Code: redeemed.Code, UserID: req.GetUserId(), RedeemedAt: time.Now()}
+		res, err := tx.ModelContext(ctx, use).OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return status.Error(codes.AlreadyExists, "Invitation was already redeemed by this user")
+		}
+		redeemed.Uses++
+		if _, err := tx.ModelContext(ctx, redeemed).Column("uses").WherePK().Update(); err != nil {
+			return err
+		}
+
+		user := &service.User{Id: req.GetUserId()}
+		err = tx.ModelContext(ctx, user).WherePK().For("UPDATE").Select()
+		exists := err == nil
+		if err == pg.ErrNoRows {
+			user = nil
+		} else if err != nil {
+			return err
+		}
+		if exists && user.GetDeleted() {
+			// Deleted and merged users keep their IDs, redeeming must not bring them back
+			return status.Errorf(codes.FailedPrecondition, "User with id %v is deleted", req.GetUserId())
+		}
+
+		if exists {
+			updated = proto.Clone(user).(*service.User)
+		} else {
+			updated = &service.User{Id: req.GetUserId(), Name: req.GetName(), Role: service.Role_ROLE_USER}
+		}
+		from := updated.GetRole()
+		if redeemed.Role > from {
+			updated.Role = redeemed.Role
+		}
+		if request, err = holdEscalation(ctx, tx, &redeemed.CreatedBy, from, updated); err != nil {
+			return err
+		}
+		updated.Groups = addGroups(updated.GetGroups(), redeemed.Groups...)
+
+		if exists {
+			_, err = tx.ModelContext(ctx, updated).Column("role", "groups").WherePK().Update()
+		} else {
+			_, err = tx.ModelContext(ctx, updated).Insert()
+		}
+		if err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, user, updated)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in RedeemInvitation: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("User %v redeemed invitation %v", req.GetUserId(), req.GetCode())
+	if request != nil {
+		return &service.RedeemInvitationResponse{User: updated, RoleChangeRequest: request.toProto()}, nil
+	}
+	return &service.RedeemInvitationResponse{User: updated}, nil
+}
+
+// ListRedemptions lists redemptions of an invitation, oldest first
+func (s *DatabaseTestServer) ListRedemptions(req *service.InvitationByCodeRequest, stream service.DatabaseTest_ListRedemptionsServer) error {
+	var redemptions []*redemption
+	err := s.db.ModelContext(stream.Context(), &redemptions).
+		Where("code = ?", req.GetCode()).
+		Order("redeemed_at ASC").
+		Select()
+	if err != nil {
+		log.Printf("Error in ListRedemptions: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, use := range redemptions {
+		err := stream.Send(&service.Redemption{
+			Code:       use.Code,
+			UserId:     use.UserID,
+			RedeemedAt: timestamppb.New(use.RedeemedAt),
+		})
+		if err != nil {
+			log.Printf("Error while sending redemption: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
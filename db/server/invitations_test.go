@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCreateInvitationIsMadeByActor(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "Reader", Role: service.Role_ROLE_READ_ONLY_ADMIN})
+	req := &service.Invitation{
+		Role:      service.Role_ROLE_READ_WRITE_ADMIN,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+		CreatedBy: 2,
+	}
+
+	created, err := s.CreateInvitation(asActor(1), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.GetCreatedBy() != 1 {
+		t.Errorf("creator is %v, want the actor", created.GetCreatedBy())
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"without actor":       context.Background(),
+		"read-only admin":     asActor(2),
+		"unknown user":        asActor(3),
+		"while impersonating": asImpersonated(1, 1),
+	} {
+		if _, err := s.CreateInvitation(ctx, req); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: got %v, want PermissionDenied", name, err)
+		}
+	}
+}
+
+func TestRedeemInvitationKeepsDeletedUsers(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "Merged", Role: service.Role_ROLE_USER, Deleted: true})
+	created, err := s.CreateInvitation(asActor(1), &service.Invitation{
+		Groups:    []string{"staff"},
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RedeemInvitation(context.Background(), &service.RedeemInvitationRequest{Code: created.GetCode(), UserId: 2, Name: "New"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("got %v, want FailedPrecondition", err)
+	}
+	stored := &service.User{Id: 2}
+	if err := s.db.Model(stored).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.GetDeleted() || stored.GetName() != "Merged" {
+		t.Errorf("deleted user was overwritten: %v", stored)
+	}
+}
+
+func TestRedeemInvitationRequestsAdminRolesFromCreator(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s, &service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+	created, err := s.CreateInvitation(asActor(1), &service.Invitation{
+		Role:      service.Role_ROLE_READ_WRITE_ADMIN,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.RedeemInvitation(context.Background(), &service.RedeemInvitationRequest{Code: created.GetCode(), UserId: 2, Name: "New"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetUser().GetRole() != service.Role_ROLE_USER {
+		t.Errorf("user got role %v without approval", res.GetUser().GetRole())
+	}
+	if request := res.GetRoleChangeRequest(); request.GetRequestedBy() != 1 || request.GetRequestedRole() != service.Role_ROLE_READ_WRITE_ADMIN {
+		t.Errorf("got role change request %v", request)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// mergeFields resolves fields of two users, target's ID is kept
+func mergeFields(source, target *service.User, strategy service.MergeStrategy) *service.User {
+	preferred, other := target, source
+	if strategy == service.MergeStrategy_MERGE_STRATEGY_PREFER_SOURCE {
+		preferred, other = source, target
+	}
+
+	merged := &service.User{
+		Id:             target.GetId(),
+		Name:           preferred.GetName(),
+		PhoneNumber:    preferred.PhoneNumber,
+		Role:           preferred.GetRole(),
+		TelegramChatId: preferred.TelegramChatId,
+	}
+	if merged.GetName() == "" {
+		merged.Name = other.GetName()
+	}
+	if merged.GetPhoneNumber() == "" {
+		merged.PhoneNumber = other.PhoneNumber
+	}
+	if merged.GetRole() == service.Role_ROLE_UNSPECIFIED {
+		merged.Role = other.GetRole()
+	}
+	if merged.TelegramChatId == nil {
+		merged.TelegramChatId = other.TelegramChatId
+	}
+	if len(source.GetAttributes())+len(target.GetAttributes()) > 0 {
+		merged.Attributes = make(map[string]string)
+		for name, value := range other.GetAttributes() {
+			merged.Attributes[name] = value
+		}
+		for name, value := range preferred.GetAttributes() {
+			if value != "" {
+				merged.Attributes[name] = value
+			}
+		}
+	}
+	merged.Groups = addGroups(preferred.GetGroups(), other.GetGroups()...)
+	return merged
+}
+
+// selectForUpdate locks both users in order of their IDs
+func selectForUpdate(ctx context.Context, tx *pg.Tx, users ...*service.User) error {
+	if len(users) == 2 && users[0].GetId() > users[1].GetId() {
+		users = []*service.User{users[1], users[0]}
+	}
+	for _, user := range users {
+		err := tx.ModelContext(ctx, user).WherePK().Where("deleted IS NOT TRUE").For("UPDATE").Select()
+		if err == pg.ErrNoRows {
+			return status.Errorf(codes.NotFound, "User with id %v not found", user.GetId())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeUsers moves telegram link, group memberships and history of source user to target user,
+// resolves conflicting fields with given strategy and soft-deletes source user
+func (s *DatabaseTestServer) MergeUsers(ctx context.Context, req *service.MergeUsersRequest) (*service.MergeUsersResponse, error) {
+	if req.GetSourceId() == req.GetTargetId() {
+		return nil, status.Error(codes.InvalidArgument, "Cannot merge a user into itself")
+	}
+	if _, ok := service.MergeStrategy_name[int32(req.GetFieldStrategy())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown merge strategy %v", req.GetFieldStrategy())
+	}
+
+	source := &service.User{Id: req.GetSourceId()}
+	target := &service.User{Id: req.GetTargetId()}
+	var merged *service.User
+	var request *roleChangeRequest
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := selectForUpdate(ctx, tx, source, target); err != nil {
+			return err
+		}
+
+		merged = mergeFields(source, target, req.GetFieldStrategy())
+		if req.GetDryRun() {
+			return nil
+		}
+		if actor := actorFromContext(ctx); actor != nil && *actor == target.GetId() && merged.GetRole() != target.GetRole() {
+			return status.Error(codes.PermissionDenied, "Admins cannot change their role")
+		}
+		var err error
+		if request, err = holdEscalation(ctx, tx, actorFromContext(ctx), target.GetRole(), merged); err != nil {
+			return err
+		}
+
+		_, err = tx.ModelContext(ctx, source).
+			Set("deleted = TRUE").
+			Set("telegram_chat_id = NULL").
+			WherePK().
+			Update()
+		if err != nil {
+			return err
+		}
+		deleted := proto.Clone(source).(*service.User)
+		deleted.Deleted = true
+		deleted.TelegramChatId = nil
+		if err := enqueueEvent(ctx, tx, newUserEvent(ctx, source, deleted)); err != nil {
+			return err
+		}
+		if _, err := tx.ModelContext(ctx, merged).WherePK().Update(); err != nil {
+			return err
+		}
+
+		offset, err := lastVersion(ctx, tx, target.GetId())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE user_versions SET user_id = ?0, version = version + ?2, merged_from = coalesce(merged_from, ?1)
+			WHERE user_id = ?1`,
+			target.GetId(), source.GetId(), offset)
+		if err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, target, merged)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in MergeUsers: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if !req.GetDryRun() {
+		log.Printf("Merged user %v into %v: %v", source.GetId(), target.GetId(), merged)
+	}
+	res := &service.MergeUsersResponse{User: merged}
+	if request != nil {
+		res.RoleChangeRequest = request.toProto()
+	}
+	return res, nil
+}
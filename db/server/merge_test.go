@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeFields(t *testing.T) {
+	source := &service.User{
+		Id:             1,
+		Name:           "Source",
+		PhoneNumber:    proto.String("+79990000001"),
+		Role:           service.Role_ROLE_READ_ONLY_ADMIN,
+		TelegramChatId: proto.Int64(100),
+		Attributes:     map[string]string{"team": "red", "city": "Omsk", "desk": "3"},
+		Groups:         []string{"staff", "ops"},
+	}
+	target := &service.User{
+		Id:         2,
+		Name:       "Target",
+		Role:       service.Role_ROLE_USER,
+		Attributes: map[string]string{"team": "blue", "desk": ""},
+		Groups:     []string{"dev", "staff"},
+	}
+
+	for _, test := range []struct {
+		strategy service.MergeStrategy
+		want     *service.User
+	}{
+		{service.MergeStrategy_MERGE_STRATEGY_UNSPECIFIED, &service.User{
+			Id:             2,
+			Name:           "Target",
+			PhoneNumber:    proto.String("+79990000001"),
+			Role:           service.Role_ROLE_USER,
+			TelegramChatId: proto.Int64(100),
+			Attributes:     map[string]string{"team": "blue", "city": "Omsk", "desk": "3"},
+			Groups:         []string{"dev", "staff", "ops"},
+		}},
+		{service.MergeStrategy_MERGE_STRATEGY_PREFER_SOURCE, &service.User{
+			Id:             2,
+			Name:           "Source",
+			PhoneNumber:    proto.String("+79990000001"),
+			Role:           service.Role_ROLE_READ_ONLY_ADMIN,
+			TelegramChatId: proto.Int64(100),
+			Attributes:     map[string]string{"team": "red", "city": "Omsk", "desk": "3"},
+			Groups:         []string{"staff", "ops", "dev"},
+		}},
+	} {
+		if got := mergeFields(source, target, test.strategy); !proto.Equal(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.strategy, got, test.want)
+		}
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s, &service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+	for _, user := range []*service.User{
+		{Id: 2, Name: "Source", Role: service.Role_ROLE_USER, TelegramChatId: proto.Int64(100)},
+		{Id: 3, Name: "Target", Role: service.Role_ROLE_USER},
+	} {
+		if _, err := s.AddOrUpdateUser(asActor(1), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := s.MergeUsers(asActor(1), &service.MergeUsersRequest{SourceId: 2, TargetId: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetUser().GetTelegramChatId() != 100 {
+		t.Errorf("telegram link was not moved: %v", res.GetUser())
+	}
+	source := &service.User{Id: 2}
+	if err := s.db.Model(source).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	if !source.GetDeleted() || source.TelegramChatId != nil {
+		t.Errorf("source was not deleted: %v", source)
+	}
+
+	stream := newSentStream[service.UserVersion](context.Background())
+	if err := s.ListUserVersions(&service.UserByIDRequest{Id: 3}, stream); err != nil {
+		t.Fatal(err)
+	}
+	merged := 0
+	for _, version := range stream.sent {
+		if version.GetMergedFrom() == 2 {
+			merged++
+		}
+	}
+	if merged != 1 || len(stream.sent) != 3 {
+		t.Errorf("got %v versions, %v of them from the source", len(stream.sent), merged)
+	}
+
+	if _, err := s.MergeUsers(asActor(1), &service.MergeUsersRequest{SourceId: 2, TargetId: 3}); status.Code(err) != codes.NotFound {
+		t.Errorf("merged a deleted user: got %v, want NotFound", err)
+	}
+	if _, err := s.AddOrUpdateUser(asActor(1), &service.User{Id: 2, Name: "Again", Role: service.Role_ROLE_USER}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("merged user was brought back: got %v, want FailedPrecondition", err)
+	}
+}
+
+func TestMergeUsersDryRun(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Source", Role: service.Role_ROLE_USER},
+		&service.User{Id: 2, Role: service.Role_ROLE_USER})
+
+	res, err := s.MergeUsers(context.Background(), &service.MergeUsersRequest{SourceId: 1, TargetId: 2, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetUser().GetName() != "Source" {
+		t.Errorf("got merged user %v", res.GetUser())
+	}
+	source := &service.User{Id: 1}
+	if err := s.db.Model(source).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	if source.GetDeleted() {
+		t.Error("dry run deleted the source")
+	}
+
+	if _, err := s.MergeUsers(context.Background(), &service.MergeUsersRequest{SourceId: 1, TargetId: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("merged a user into itself: got %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// roleChangeRequestTTL is how long a role change request waits for a decision by default
+const roleChangeRequestTTL = 24 * time.Hour
+
+// roleChangeRequest is a row of pending and decided role changes
+type roleChangeRequest struct {
+	tableName struct{} `pg:"role_change_requests"`
+
+	Id            int64
+	UserID        int64                    `pg:",notnull"`
+	FromRole      service.Role             `pg:",use_zero"`
+	RequestedRole service.Role             `pg:",use_zero"`
+	RequestedBy   int64                    `pg:",notnull"`
+	Status        service.RoleChangeStatus `pg:",use_zero"`
+	CreatedAt     time.Time                `pg:"default:now(),notnull"`
+	ExpiresAt     time.Time                `pg:",notnull"`
+	DecidedBy     *int64
+	DecidedAt     *time.Time
+}
+
+func (r *roleChangeRequest) toProto() *service.RoleChangeRequest {
+	req := &service.RoleChangeRequest{
+		Id:            r.Id,
+		UserId:        r.UserID,
+		FromRole:      r.FromRole,
+		RequestedRole: r.RequestedRole,
+		RequestedBy:   r.RequestedBy,
+		Status:        r.Status,
+		CreatedAt:     timestamppb.New(r.CreatedAt),
+		ExpiresAt:     timestamppb.New(r.ExpiresAt),
+		DecidedBy:     r.DecidedBy,
+	}
+	if r.DecidedAt != nil {
+		req.DecidedAt = timestamppb.New(*r.DecidedAt)
+	}
+	return req
+}
+
+// expireRoleChangeRequests marks pending requests past their expiration as expired
+func expireRoleChangeRequests(ctx context.Context, db orm.DB) error {
+	_, err := db.ModelContext(ctx, (*roleChangeRequest)(nil)).
+		Set("status = ?", service.RoleChangeStatus_ROLE_CHANGE_STATUS_EXPIRED).
+		Where("status = ?", service.RoleChangeStatus_ROLE_CHANGE_STATUS_PENDING).
+		Where("expires_at < now()").
+		Update()
+	return err
+}
+
+// isEscalation reports whether a role change grants admin privileges
+// and therefore needs approval of a second admin
+func isEscalation(from, to service.Role) bool {
+	return to > from && to >= service.Role_ROLE_READ_ONLY_ADMIN
+}
+
+// checkReadWriteAdmin fails with PermissionDenied unless the user is a read-write admin
+func checkReadWriteAdmin(ctx context.Context, db orm.DB, id int64, message string) error {
+	admin := &service.User{Id: id}
+	err := db.ModelContext(ctx, admin).WherePK().Where("deleted IS NOT TRUE").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return err
+	}
+	if err == pg.ErrNoRows || admin.GetRole() != service.Role_ROLE_READ_WRITE_ADMIN {
+		return status.Error(codes.PermissionDenied, message)
+	}
+	return nil
+}
+
+// fileRoleChangeRequest stores a pending request, the requester must be a read-write admin other than the user
+func fileRoleChangeRequest(ctx context.Context, tx *pg.Tx, request *roleChangeRequest) error {
+	if request.RequestedBy == request.UserID {
+		return status.Error(codes.PermissionDenied, "Admins cannot change their role")
+	}
+	if err := checkReadWriteAdmin(ctx, tx, request.RequestedBy, "Only read-write admins can request role changes"); err != nil {
+		return err
+	}
+	request.Status = service.RoleChangeStatus_ROLE_CHANGE_STATUS_PENDING
+	request.CreatedAt = time.Now()
+	if request.ExpiresAt.IsZero() {
+		request.ExpiresAt = request.CreatedAt.Add(roleChangeRequestTTL)
+	}
+	if _, err := tx.ModelContext(ctx, request).Insert(); err != nil {
+		return err
+	}
+	log.Printf("User %v requested role %v for user %v", request.RequestedBy, request.RequestedRole, request.UserID)
+	return nil
+}
+
+// holdEscalation keeps the user at role from if the change to its role grants admin privileges,
+// and requests the change on behalf of requester instead, usually the actor of the call.
+// While there are no read-write admins to approve it, the change is not held so the first admin can be appointed.
+func holdEscalation(ctx context.Context, tx *pg.Tx, requester *int64, from service.Role, user *service.User) (*roleChangeRequest, error) {
+	if !isEscalation(from, user.GetRole()) {
+		return nil, nil
+	}
+	approvable, err := tx.ModelContext(ctx, (*service.User)(nil)).
+		Where("role = ?", service.Role_ROLE_READ_WRITE_ADMIN).
+		Where("deleted IS NOT TRUE").
+		Exists()
+	if err != nil {
+		return nil, err
+	}
+	if !approvable {
+		log.Printf("There are no read-write admins, user %v gets role %v without approval", user.GetId(), user.GetRole())
+		return nil, nil
+	}
+	if requester == nil {
+		return nil, status.Error(codes.PermissionDenied, "Granting admin roles needs approval, the call must be made on behalf of an admin")
+	}
+	if err := checkNotImpersonated(ctx, "Admin roles cannot be granted while impersonating"); err != nil {
+		return nil, err
+	}
+
+	request := &roleChangeRequest{
+		UserID:        user.GetId(),
+		FromRole:      from,
+		RequestedRole: user.GetRole(),
+		RequestedBy:   *requester,
+	}
+	if err := fileRoleChangeRequest(ctx, tx, request); err != nil {
+		return nil, err
+	}
+	user.Role = from
+	return request, nil
+}
+
+// RequestRoleChange creates a pending role change which needs approval of another admin.
+// The requester is the actor of the call, who must be a read-write admin not impersonated by another admin.
+func (s *DatabaseTestServer) RequestRoleChange(ctx context.Context, req *service.RoleChangeRequest) (*service.RoleChangeRequest, error) {
+	if _, ok := service.Role_name[int32(req.GetRequestedRole())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown role %v", req.GetRequestedRole())
+	}
+	actor := actorFromContext(ctx)
+	if actor == nil {
+		return nil, status.Error(codes.PermissionDenied, "Role changes must be requested on behalf of an admin")
+	}
+	if err := checkNotImpersonated(ctx, "Role changes cannot be requested while impersonating"); err != nil {
+		return nil, err
+	}
+
+	request := &roleChangeRequest{
+		UserID:        req.GetUserId(),
+		RequestedRole: req.GetRequestedRole(),
+		RequestedBy:   *actor,
+	}
+	if req.GetExpiresAt() != nil {
+		request.ExpiresAt = req.GetExpiresAt().AsTime()
+	}
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		user := &service.User{Id: req.GetUserId()}
+		if err := selectForUpdate(ctx, tx, user); err != nil {
+			return err
+		}
+		if user.GetRole() == req.GetRequestedRole() {
+			return status.Errorf(codes.FailedPrecondition, "User %v already has role %v", user.GetId(), user.GetRole())
+		}
+		request.FromRole = user.GetRole()
+		return fileRoleChangeRequest(ctx, tx, request)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in RequestRoleChange: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return request.toProto(), nil
+}
+
+// ListRoleChangeRequests lists role change requests, newest first
+func (s *DatabaseTestServer) ListRoleChangeRequests(req *service.ListRoleChangeRequestsRequest, stream service.DatabaseTest_ListRoleChangeRequestsServer) error {
+	ctx := stream.Context()
+	if err := expireRoleChangeRequests(ctx, s.db); err != nil {
+		log.Printf("Error in ListRoleChangeRequests: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	var requests []*roleChangeRequest
+	query := s.db.ModelContext(ctx, &requests).Order("id DESC")
+	if req.GetStatus() != service.RoleChangeStatus_ROLE_CHANGE_STATUS_UNSPECIFIED {
+		query.Where("status = ?", req.GetStatus())
+	}
+	if err := query.Select(); err != nil {
+		log.Printf("Error in ListRoleChangeRequests: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, request := range requests {
+		if err := stream.Send(request.toProto()); err != nil {
+			log.Printf("Error while sending role change request: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// DecideRoleChangeRequest approves or rejects a pending role change. The decision is made by the actor of the call,
+// who must be a read-write admin other than the requester and the user, not impersonated by another admin.
+func (s *DatabaseTestServer) DecideRoleChangeRequest(ctx context.Context, req *service.RoleChangeDecision) (*service.RoleChangeRequest, error) {
+	actor := actorFromContext(ctx)
+	if actor == nil {
+		return nil, status.Error(codes.PermissionDenied, "Role changes must be decided on behalf of an admin")
+	}
+	if err := checkNotImpersonated(ctx, "Role changes cannot be decided while impersonating"); err != nil {
+		return nil, err
+	}
+	decidedBy := *actor
+	request := &roleChangeRequest{Id: req.GetId()}
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := expireRoleChangeRequests(ctx, tx); err != nil {
+			return err
+		}
+		err := tx.ModelContext(ctx, request).WherePK().For("UPDATE").Select()
+		if err == pg.ErrNoRows {
+			return status.Errorf(codes.NotFound, "Role change request %v not found", req.GetId())
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case request.Status != service.RoleChangeStatus_ROLE_CHANGE_STATUS_PENDING:
+			return status.Errorf(codes.FailedPrecondition, "Role change request %v is %v", request.Id, request.Status)
+		case decidedBy == request.RequestedBy:
+			return status.Error(codes.PermissionDenied, "Role change must be decided by another admin")
+		case decidedBy == request.UserID:
+			return status.Error(codes.PermissionDenied, "Admins cannot decide on their own role")
+		}
+		if err := checkReadWriteAdmin(ctx, tx, decidedBy, "Only read-write admins can decide on role changes"); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		request.Status = service.RoleChangeStatus_ROLE_CHANGE_STATUS_REJECTED
+		request.DecidedBy = &decidedBy
+		request.DecidedAt = &now
+		if req.GetApprove() {
+			request.Status = service.RoleChangeStatus_ROLE_CHANGE_STATUS_APPROVED
+			if err := applyRoleChange(ctx, tx, request); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ModelContext(ctx, request).Column("status", "decided_by", "decided_at").WherePK().Update()
+		return err
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in DecideRoleChangeRequest: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("Role change request %v is %v by %v", request.Id, request.Status, decidedBy)
+	return request.toProto(), nil
+}
+
+// applyRoleChange sets requested role if the user still has the role the request was made for
+func applyRoleChange(ctx context.Context, tx *pg.Tx, request *roleChangeRequest) error {
+	user := &service.User{Id: request.UserID}
+	if err := selectForUpdate(ctx, tx, user); err != nil {
+		return err
+	}
+	if user.GetRole() != request.FromRole {
+		return status.Errorf(codes.FailedPrecondition, "Role of user %v has changed since the request was made", user.GetId())
+	}
+
+	updated := proto.Clone(user).(*service.User)
+	updated.Role = request.RequestedRole
+	if _, err := tx.ModelContext(ctx, updated).Column("role").WherePK().Update(); err != nil {
+		return err
+	}
+	return recordChange(ctx, tx, user, updated)
+}
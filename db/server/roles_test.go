@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsEscalation(t *testing.T) {
+	for _, test := range []struct {
+		from, to service.Role
+		want     bool
+	}{
+		{service.Role_ROLE_USER, service.Role_ROLE_READ_ONLY_ADMIN, true},
+		{service.Role_ROLE_USER, service.Role_ROLE_READ_WRITE_ADMIN, true},
+		{service.Role_ROLE_READ_ONLY_ADMIN, service.Role_ROLE_READ_WRITE_ADMIN, true},
+		{service.Role_ROLE_UNSPECIFIED, service.Role_ROLE_USER, false},
+		{service.Role_ROLE_READ_WRITE_ADMIN, service.Role_ROLE_READ_ONLY_ADMIN, false},
+		{service.Role_ROLE_READ_WRITE_ADMIN, service.Role_ROLE_USER, false},
+		{service.Role_ROLE_READ_ONLY_ADMIN, service.Role_ROLE_READ_ONLY_ADMIN, false},
+	} {
+		if got := isEscalation(test.from, test.to); got != test.want {
+			t.Errorf("isEscalation(%v, %v) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func storedRole(t *testing.T, s *DatabaseTestServer, id int64) service.Role {
+	t.Helper()
+	user := &service.User{Id: id}
+	if err := s.db.Model(user).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	return user.GetRole()
+}
+
+func TestAddOrUpdateUserHoldsEscalation(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "User", Role: service.Role_ROLE_USER})
+
+	res, err := s.AddOrUpdateUser(asActor(1), &service.User{Id: 2, Name: "User", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := storedRole(t, s, 2); role != service.Role_ROLE_USER {
+		t.Errorf("user got role %v without approval", role)
+	}
+	request := res.GetRoleChangeRequest()
+	if request.GetRequestedBy() != 1 || request.GetStatus() != service.RoleChangeStatus_ROLE_CHANGE_STATUS_PENDING {
+		t.Errorf("got role change request %v", request)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"without actor":       context.Background(),
+		"by the user":         asActor(2),
+		"while impersonating": asImpersonated(1, 1),
+	} {
+		_, err := s.AddOrUpdateUser(ctx, &service.User{Id: 2, Name: "User", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: got %v, want PermissionDenied", name, err)
+		}
+	}
+}
+
+func TestAddOrUpdateUserAppointsFirstAdmin(t *testing.T) {
+	s := newTestServer(t)
+
+	res, err := s.AddOrUpdateUser(context.Background(), &service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetRoleChangeRequest() != nil {
+		t.Errorf("got role change request %v without admins to approve it", res.GetRoleChangeRequest())
+	}
+	if role := storedRole(t, s, 1); role != service.Role_ROLE_READ_WRITE_ADMIN {
+		t.Errorf("first admin got role %v", role)
+	}
+}
+
+func TestDecideRoleChangeRequest(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Requester", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "Approver", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 3, Name: "Reader", Role: service.Role_ROLE_READ_ONLY_ADMIN})
+
+	request, err := s.RequestRoleChange(asActor(1), &service.RoleChangeRequest{UserId: 3, RequestedRole: service.Role_ROLE_READ_WRITE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := storedRole(t, s, 3); role != service.Role_ROLE_READ_ONLY_ADMIN {
+		t.Fatalf("role %v was set before approval", role)
+	}
+
+	decision := &service.RoleChangeDecision{Id: request.GetId(), Approve: true}
+	for name, ctx := range map[string]context.Context{
+		"without actor":       context.Background(),
+		"by the requester":    asActor(1),
+		"by the user":         asActor(3),
+		"by an unknown user":  asActor(4),
+		"while impersonating": asImpersonated(2, 2),
+	} {
+		if _, err := s.DecideRoleChangeRequest(ctx, decision); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: got %v, want PermissionDenied", name, err)
+		}
+	}
+
+	decided, err := s.DecideRoleChangeRequest(asActor(2), decision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decided.GetStatus() != service.RoleChangeStatus_ROLE_CHANGE_STATUS_APPROVED || decided.GetDecidedBy() != 2 {
+		t.Errorf("got decided request %v", decided)
+	}
+	if role := storedRole(t, s, 3); role != service.Role_ROLE_READ_WRITE_ADMIN {
+		t.Errorf("approved role was not set, user has %v", role)
+	}
+	if _, err := s.DecideRoleChangeRequest(asActor(2), decision); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("decided twice: got %v, want FailedPrecondition", err)
+	}
+}
+
+func TestRequestRoleChangeRules(t *testing.T) {
+	s := newTestServer(t)
+	addUsers(t, s,
+		&service.User{Id: 1, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+		&service.User{Id: 2, Name: "Reader", Role: service.Role_ROLE_READ_ONLY_ADMIN},
+		&service.User{Id: 3, Name: "User", Role: service.Role_ROLE_USER})
+
+	for name, ctx := range map[string]context.Context{
+		"without actor":        context.Background(),
+		"by a read-only admin": asActor(2),
+		"by the user":          asActor(3),
+		"while impersonating":  asImpersonated(1, 1),
+	} {
+		_, err := s.RequestRoleChange(ctx, &service.RoleChangeRequest{UserId: 3, RequestedRole: service.Role_ROLE_READ_ONLY_ADMIN})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("%v: got %v, want PermissionDenied", name, err)
+		}
+	}
+	_, err := s.RequestRoleChange(asActor(1), &service.RoleChangeRequest{UserId: 3, RequestedRole: service.Role_ROLE_USER})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("requested the current role: got %v, want FailedPrecondition", err)
+	}
+}
@@ -3,7 +3,6 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"log"
 
 	"github.com/Iamnotagenius/test/db/service"
@@ -11,6 +10,7 @@ import (
 	"github.com/go-pg/pg/v10/orm"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // DatabaseTestServer is gRPC server implementation of database service
@@ -19,10 +19,40 @@ type DatabaseTestServer struct {
 	service.UnimplementedDatabaseTestServer
 }
 
+// migrations bring tables created by older versions up to date, executed in order
+var migrations = []string{
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS telegram_chat_id bigint",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted boolean",
+	"ALTER TABLE user_versions ADD COLUMN IF NOT EXISTS merged_from bigint",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes jsonb",
+	"ALTER TABLE user_versions ADD COLUMN IF NOT EXISTS attributes jsonb",
+	"ALTER TABLE user_versions ADD COLUMN IF NOT EXISTS changed_by bigint",
+	"ALTER TABLE user_events ADD COLUMN IF NOT EXISTS actor_id bigint",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS groups jsonb",
+	"ALTER TABLE user_versions ADD COLUMN IF NOT EXISTS groups jsonb",
+	"ALTER TABLE user_versions ADD COLUMN IF NOT EXISTS impersonated_by bigint",
+	"ALTER TABLE user_events ADD COLUMN IF NOT EXISTS impersonated_by bigint",
+	`ALTER TABLE user_events ADD COLUMN IF NOT EXISTS "user" jsonb`,
+	"ALTER TABLE broadcast_recipients ADD COLUMN IF NOT EXISTS leased_until timestamptz",
+	backfillHistoryQuery,
+}
+
 func initDb(connOpts *pg.Options) (*pg.DB, error) {
 	db := pg.Connect(connOpts)
+	db.AddQueryHook(queryTracer{})
 	models := []interface{}{
 		(*service.User)(nil),
+		(*userVersion)(nil),
+		(*attributeDefinition)(nil),
+		(*userEvent)(nil),
+		(*webhook)(nil),
+		(*webhookDelivery)(nil),
+		(*roleChangeRequest)(nil),
+		(*invitation)(nil),
+		(*redemption)(nil),
+		(*apiToken)(nil),
+		(*broadcastRow)(nil),
+		(*broadcastRecipient)(nil),
 	}
 
 	for _, model := range models {
@@ -34,6 +64,12 @@ func initDb(connOpts *pg.Options) (*pg.DB, error) {
 			return nil, err
 		}
 	}
+
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil {
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -46,27 +82,68 @@ func NewDatabaseServer(connOpts *pg.Options) *DatabaseTestServer {
 	return &DatabaseTestServer{db: db}
 }
 
-// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise
+// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise.
+// Every change is recorded as a new version in user history and emitted as an event.
+// Escalations are requested on behalf of the actor instead of being stored, see holdEscalation.
+// Deleted users are not updated, FailedPrecondition is returned instead.
 func (s *DatabaseTestServer) AddOrUpdateUser(ctx context.Context, user *service.User) (*service.UpdateResponse, error) {
-	query := s.db.ModelContext(ctx, user).WherePK()
-	if exists, _ := query.Exists(); !exists {
-		_, err := s.db.ModelContext(ctx, user).Insert()
-		log.Printf("Inserted a user: %v", user)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+	var request *roleChangeRequest
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		stored := &service.User{Id: user.GetId()}
+		err := tx.ModelContext(ctx, stored).WherePK().For("UPDATE").Select()
+		exists := err == nil
+		if err != nil && err != pg.ErrNoRows {
+			return err
+		}
+		if exists && stored.GetDeleted() {
+			// Deleted and merged users keep their IDs, an upsert must not bring them back
+			return status.Errorf(codes.FailedPrecondition, "User with id %v is deleted", user.GetId())
+		}
+		if err := validateAttributes(ctx, tx, stored.GetAttributes(), user.GetAttributes()); err != nil {
+			return err
+		}
+		from := stored.GetRole()
+		if !exists {
+			from = service.Role_ROLE_USER
+		}
+		if actor := actorFromContext(ctx); exists && actor != nil && *actor == user.GetId() && from != user.GetRole() {
+			return status.Error(codes.PermissionDenied, "Admins cannot change their role")
+		}
+		if request, err = holdEscalation(ctx, tx, actorFromContext(ctx), from, user); err != nil {
+			return err
 		}
-		return &service.UpdateResponse{}, nil
-	}
 
-	query.Update()
-	log.Printf("Modified a user: %v", user)
+		if !exists {
+			if _, err := tx.ModelContext(ctx, user).Insert(); err != nil {
+				return err
+			}
+			log.Printf("Inserted a user: %v", user)
+			return recordChange(ctx, tx, nil, user)
+		}
+
+		if _, err := tx.ModelContext(ctx, user).WherePK().Update(); err != nil {
+			return err
+		}
+		log.Printf("Modified a user: %v", user)
+		return recordChange(ctx, tx, stored, user)
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in AddOrUpdateUser: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if request != nil {
+		return &service.UpdateResponse{RoleChangeRequest: request.toProto()}, nil
+	}
 	return &service.UpdateResponse{}, nil
 }
 
 // GetUserByID retrieves user from database with given ID
 func (s *DatabaseTestServer) GetUserByID(ctx context.Context, req *service.UserByIDRequest) (*service.User, error) {
 	user := &service.User{Id: req.GetId()}
-	err := s.db.ModelContext(ctx, user).WherePK().Select()
+	err := s.db.ModelContext(ctx, user).WherePK().Where("deleted IS NOT TRUE").Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "User with id %v not found", req.GetId())
@@ -80,14 +157,47 @@ func (s *DatabaseTestServer) GetUserByID(ctx context.Context, req *service.UserB
 
 // SearchUsersByName searches users in database by part of a name
 func (s *DatabaseTestServer) SearchUsersByName(req *service.SearchByNameRequest, stream service.DatabaseTest_SearchUsersByNameServer) error {
-	var users []*service.User
-	s.db.Model(&users).Where(fmt.Sprintf("name LIKE '%%%v%%'", req.Query)).Select()
-	for _, user := range users {
-		err := stream.Send(user)
+	// Rows are sent as they are read, so exports of every user are not kept in memory
+	err := s.db.ModelContext(stream.Context(), (*service.User)(nil)).
+		Where("name LIKE ?", "%"+req.GetQuery()+"%").
+		Where("deleted IS NOT TRUE").
+		ForEach(func(user *service.User) error {
+			return stream.Send(user)
+		})
+	if err != nil {
+		log.Printf("Error in SearchUsersByName: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// DeleteUser soft-deletes a user and unlinks their telegram chat, history of the user is kept
+func (s *DatabaseTestServer) DeleteUser(ctx context.Context, req *service.UserByIDRequest) (*service.UpdateResponse, error) {
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		user := &service.User{Id: req.GetId()}
+		if err := selectForUpdate(ctx, tx, user); err != nil {
+			return err
+		}
+		_, err := tx.ModelContext(ctx, user).
+			Set("deleted = TRUE").
+			Set("telegram_chat_id = NULL").
+			WherePK().
+			Update()
 		if err != nil {
-			log.Printf("Error while executing query: %v", err)
-			return status.Error(codes.Internal, err.Error())
+			return err
+		}
+		deleted := proto.Clone(user).(*service.User)
+		deleted.Deleted = true
+		deleted.TelegramChatId = nil
+		return enqueueEvent(ctx, tx, newUserEvent(ctx, user, deleted))
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
 		}
+		log.Printf("Error in DeleteUser: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-	return nil
+	log.Printf("Deleted a user: %v", req.GetId())
+	return &service.UpdateResponse{}, nil
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// newTestServer connects to PostgreSQL at TEST_DB_ADDR, tests which need a database are skipped without it.
+// Tables of every test are created in a schema of their own which is dropped afterwards.
+func newTestServer(t *testing.T) *DatabaseTestServer {
+	t.Helper()
+	addr := os.Getenv("TEST_DB_ADDR")
+	if addr == "" {
+		t.Skip("TEST_DB_ADDR is not set")
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+	opts := &pg.Options{
+		Addr:     addr,
+		User:     os.Getenv("TEST_DB_USER"),
+		Password: os.Getenv("TEST_DB_PASSWORD"),
+	}
+	if opts.User == "" {
+		opts.User = "postgres"
+	}
+
+	admin := pg.Connect(opts)
+	t.Cleanup(func() { admin.Close() })
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	opts.OnConnect = func(ctx context.Context, conn *pg.Conn) error {
+		_, err := conn.ExecContext(ctx, "SET search_path TO "+schema)
+		return err
+	}
+	db, err := initDb(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &DatabaseTestServer{db: db}
+}
+
+// addUsers stores users as they are, without history or approval of roles
+func addUsers(t *testing.T, s *DatabaseTestServer, users ...*service.User) {
+	t.Helper()
+	for _, user := range users {
+		if _, err := s.db.Model(user).Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// asActor returns an incoming context of a call made on behalf of the user
+func asActor(id int64) context.Context {
+	return metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(service.ActorMetadataKey, strconv.FormatInt(id, 10)))
+}
+
+// asImpersonated returns an incoming context of a call made on behalf of the user by the impersonating admin
+func asImpersonated(id, impersonator int64) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		service.ActorMetadataKey, strconv.FormatInt(id, 10),
+		service.ImpersonatorMetadataKey, strconv.FormatInt(impersonator, 10)))
+}
+
+// sentStream collects messages sent by a server-streaming call
+type sentStream[T any] struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*T
+}
+
+func newSentStream[T any](ctx context.Context) *sentStream[T] {
+	return &sentStream[T]{ctx: ctx}
+}
+
+func (s *sentStream[T]) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sentStream[T]) Send(m *T) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// apiTokenPrefix makes leaked tokens easy to recognize by secret scanners
+const apiTokenPrefix = "pat_"
+
+// apiToken is a row of personal access tokens, only a hash of the secret is stored
+type apiToken struct {
+	tableName struct{} `pg:"api_tokens"`
+
+	Id         int64
+	UserID     int64                `pg:",notnull"`
+	Name       string               `pg:",notnull"`
+	Scopes     []service.TokenScope `pg:",notnull"`
+	SecretHash string               `pg:",notnull,unique"`
+	ExpiresAt  time.Time            `pg:",notnull"`
+	CreatedAt  time.Time            `pg:"default:now(),notnull"`
+	LastUsedAt *time.Time
+	Revoked    bool `pg:",use_zero"`
+}
+
+func (t *apiToken) toProto() *service.APIToken {
+	token := &service.APIToken{
+		Id:        t.Id,
+		UserId:    t.UserID,
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		ExpiresAt: timestamppb.New(t.ExpiresAt),
+		CreatedAt: timestamppb.New(t.CreatedAt),
+		Revoked:   t.Revoked,
+	}
+	if t.LastUsedAt != nil {
+		token.LastUsedAt = timestamppb.New(*t.LastUsedAt)
+	}
+	return token
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateTokenSecret() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// CreateAPIToken creates a personal access token of a user, the secret is returned only once
+func (s *DatabaseTestServer) CreateAPIToken(ctx context.Context, req *service.APIToken) (*service.APIToken, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Token must have a name")
+	}
+	if len(req.GetScopes()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Token must have at least one scope")
+	}
+	scopes := make([]service.TokenScope, 0, len(req.GetScopes()))
+	for _, scope := range req.GetScopes() {
+		if _, ok := service.TokenScope_name[int32(scope)]; !ok || scope == service.TokenScope_TOKEN_SCOPE_UNSPECIFIED {
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown scope %v", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if req.GetExpiresAt() == nil || req.GetExpiresAt().AsTime().Before(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "Token must expire in the future")
+	}
+	if _, err := s.GetUserByID(ctx, &service.UserByIDRequest{Id: req.GetUserId()}); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	created := &apiToken{
+		UserID:     req.GetUserId(),
+		Name:       req.GetName(),
+		Scopes:     scopes,
+		SecretHash: hashSecret(secret),
+		ExpiresAt:  req.GetExpiresAt().AsTime(),
+		CreatedAt:  time.Now(),
+	}
+	if _, err := s.db.ModelContext(ctx, created).Insert(); err != nil {
+		log.Printf("Error in CreateAPIToken: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("User %v created API token %v with scopes %v", created.UserID, created.Id, created.Scopes)
+	token := created.toProto()
+	token.Secret = secret
+	return token, nil
+}
+
+// ListAPITokens lists tokens of a user, newest first, secrets are never returned
+func (s *DatabaseTestServer) ListAPITokens(req *service.ListAPITokensRequest, stream service.DatabaseTest_ListAPITokensServer) error {
+	var tokens []*apiToken
+	err := s.db.ModelContext(stream.Context(), &tokens).
+		Where("user_id = ?", req.GetUserId()).
+		Order("created_at DESC").
+		Select()
+	if err != nil {
+		log.Printf("Error in ListAPITokens: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, token := range tokens {
+		if err := stream.Send(token.toProto()); err != nil {
+			log.Printf("Error while sending API token: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// RevokeAPIToken makes a token of a user unusable
+func (s *DatabaseTestServer) RevokeAPIToken(ctx context.Context, req *service.APITokenByIDRequest) (*service.UpdateResponse, error) {
+	res, err := s.db.ModelContext(ctx, (*apiToken)(nil)).
+		Set("revoked = TRUE").
+		Where("id = ?", req.GetId()).
+		Where("user_id = ?", req.GetUserId()).
+		Update()
+	if err != nil {
+		log.Printf("Error in RevokeAPIToken: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if res.RowsAffected() == 0 {
+		return nil, status.Errorf(codes.NotFound, "Token %v not found", req.GetId())
+	}
+	log.Printf("User %v revoked API token %v", req.GetUserId(), req.GetId())
+	return &service.UpdateResponse{}, nil
+}
+
+// AuthenticateAPIToken returns the token with given secret and records its use.
+// Unknown, revoked and expired tokens are rejected with UNAUTHENTICATED.
+func (s *DatabaseTestServer) AuthenticateAPIToken(ctx context.Context, req *service.AuthenticateAPITokenRequest) (*service.APIToken, error) {
+	token := &apiToken{}
+	err := s.db.ModelContext(ctx, token).Where("secret_hash = ?", hashSecret(req.GetSecret())).Select()
+	if err == pg.ErrNoRows {
+		return nil, status.Error(codes.Unauthenticated, "Token is invalid")
+	}
+	if err != nil {
+		log.Printf("Error in AuthenticateAPIToken: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	switch {
+	case token.Revoked:
+		return nil, status.Error(codes.Unauthenticated, "Token was revoked")
+	case !now.Before(token.ExpiresAt):
+		return nil, status.Error(codes.Unauthenticated, "Token has expired")
+	}
+
+	token.LastUsedAt = &now
+	if _, err := s.db.ModelContext(ctx, token).Column("last_used_at").WherePK().Update(); err != nil {
+		log.Printf("Error in AuthenticateAPIToken: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return token.toProto(), nil
+}
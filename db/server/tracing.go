@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/tracing"
+	"github.com/go-pg/pg/v10"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer is a go-pg hook recording every query as a span.
+// Queries are recorded without parameters to keep personal data out of traces.
+type queryTracer struct{}
+
+func (queryTracer) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	// A span is started even for unformattable queries because AfterQuery ends it
+	query, _ := event.UnformattedQuery()
+	statement := string(query)
+	operation, _, _ := strings.Cut(strings.TrimSpace(statement), " ")
+	ctx, _ = tracing.Tracer().Start(ctx, "pg "+strings.ToUpper(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBStatement(statement),
+			semconv.DBOperation(strings.ToUpper(operation)),
+		))
+	return ctx, nil
+}
+
+func (queryTracer) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	span := trace.SpanFromContext(ctx)
+	if event.Err != nil && event.Err != pg.ErrNoRows {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+	span.End()
+	return nil
+}
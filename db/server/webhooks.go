@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	webhookSecretLength = 32
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 10
+	// webhookLease is for how long a delivery is claimed by a dispatcher, deliveries of a batch are attempted
+	// one after another, so the lease outlasts the whole batch timing out with a minute to spare for the database
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+	// Delays between attempts grow from webhookBaseBackoff up to webhookMaxBackoff
+	webhookBaseBackoff = 5 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// Delivery becomes dead after this many failed attempts
+	webhookMaxAttempts = 10
+
+	// SignatureHeader holds "sha256=" followed by hex HMAC-SHA256 of the body
+	SignatureHeader = "X-Webhook-Signature"
+	// EventHeader holds type of the delivered event
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader holds ID of the delivery, it stays the same across retries
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// webhook is a row of webhook subscriptions
+type webhook struct {
+	tableName struct{} `pg:"webhooks"`
+
+	Id         int64
+	Url        string    `pg:",notnull"`
+	EventTypes []string  `pg:",array"`
+	Secret     string    `pg:",notnull"`
+	CreatedAt  time.Time `pg:"default:now(),notnull"`
+}
+
+// webhookDelivery is a row of the delivery outbox
+type webhookDelivery struct {
+	tableName struct{} `pg:"webhook_deliveries"`
+
+	Id            int64
+	WebhookID     int64                  `pg:",notnull"`
+	EventID       int64                  `pg:",notnull"`
+	Status        service.DeliveryStatus `pg:",use_zero"`
+	Attempts      int32                  `pg:",use_zero"`
+	NextAttemptAt time.Time              `pg:",notnull"`
+	LastError     string                 `pg:",use_zero"`
+	DeliveredAt   *time.Time
+}
+
+// webhookPayload is the body of a webhook request
+type webhookPayload struct {
+	ID        int64         `json:"id"`
+	Type      string        `json:"type"`
+	Tags      []string      `json:"tags"`
+	CreatedAt time.Time     `json:"created_at"`
+	User      *service.User `json:"user"`
+	Previous  *service.User `json:"previous,omitempty"`
+	ActorID   *int64        `json:"actor_id,omitempty"`
+	// ImpersonatedBy is set when an admin acted on behalf of the actor
+	ImpersonatedBy *int64 `json:"impersonated_by,omitempty"`
+}
+
+func (w *webhook) toProto() *service.Webhook {
+	return &service.Webhook{
+		Id:         w.Id,
+		Url:        w.Url,
+		EventTypes: w.EventTypes,
+		CreatedAt:  timestamppb.New(w.CreatedAt),
+	}
+}
+
+func (d *webhookDelivery) toProto(eventType string) *service.WebhookDelivery {
+	delivery := &service.WebhookDelivery{
+		Id:            d.Id,
+		WebhookId:     d.WebhookID,
+		EventId:       d.EventID,
+		EventType:     eventType,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		NextAttemptAt: timestamppb.New(d.NextAttemptAt),
+		LastError:     d.LastError,
+	}
+	if d.DeliveredAt != nil {
+		delivery.DeliveredAt = timestamppb.New(*d.DeliveredAt)
+	}
+	return delivery
+}
+
+// Sign computes value of SignatureHeader for a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateSecret() (string, error) {
+	buffer := make([]byte, webhookSecretLength)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+func backoff(attempts int32) time.Duration {
+	delay := webhookBaseBackoff
+	for i := int32(1); i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}
+
+// RegisterWebhook subscribes an URL to user change events
+func (s *DatabaseTestServer) RegisterWebhook(ctx context.Context, req *service.Webhook) (*service.Webhook, error) {
+	target, err := url.Parse(req.GetUrl())
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Webhook URL must be an absolute http(s) URL")
+	}
+	for _, t := range req.GetEventTypes() {
+		known := false
+		for _, eventType := range EventTypes {
+			known = known || t == eventType
+		}
+		if !known {
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown event type %v", t)
+		}
+	}
+
+	secret := req.GetSecret()
+	if secret == "" {
+		if secret, err = generateSecret(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	hook := &webhook{
+		Url:        req.GetUrl(),
+		EventTypes: req.GetEventTypes(),
+		Secret:     secret,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := s.db.ModelContext(ctx, hook).Insert(); err != nil {
+		log.Printf("Error in RegisterWebhook: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("Registered webhook %v for %v", hook.Id, hook.Url)
+
+	res := hook.toProto()
+	res.Secret = secret
+	return res, nil
+}
+
+// ListWebhooks lists registered webhooks without their secrets
+func (s *DatabaseTestServer) ListWebhooks(req *service.ListWebhooksRequest, stream service.DatabaseTest_ListWebhooksServer) error {
+	var webhooks []*webhook
+	if err := s.db.ModelContext(stream.Context(), &webhooks).Order("id ASC").Select(); err != nil {
+		log.Printf("Error in ListWebhooks: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, hook := range webhooks {
+		if err := stream.Send(hook.toProto()); err != nil {
+			log.Printf("Error while sending webhook: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook with its pending deliveries
+func (s *DatabaseTestServer) DeleteWebhook(ctx context.Context, req *service.WebhookByIDRequest) (*service.UpdateResponse, error) {
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		res, err := tx.ModelContext(ctx, &webhook{Id: req.GetId()}).WherePK().Delete()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return status.Errorf(codes.NotFound, "Webhook with id %v not found", req.GetId())
+		}
+		_, err = tx.ModelContext(ctx, (*webhookDelivery)(nil)).Where("webhook_id = ?", req.GetId()).Delete()
+		return err
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		log.Printf("Error in DeleteWebhook: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("Deleted webhook %v", req.GetId())
+	return &service.UpdateResponse{}, nil
+}
+
+// ListWebhookDeliveries lists deliveries of a webhook, newest first
+func (s *DatabaseTestServer) ListWebhookDeliveries(req *service.ListWebhookDeliveriesRequest, stream service.DatabaseTest_ListWebhookDeliveriesServer) error {
+	var deliveries []*webhookDelivery
+	query := s.db.ModelContext(stream.Context(), &deliveries).
+		Where("webhook_id = ?", req.GetWebhookId()).
+		Order("id DESC")
+	if req.GetStatus() != service.DeliveryStatus_DELIVERY_STATUS_UNSPECIFIED {
+		query.Where("status = ?", req.GetStatus())
+	}
+	if err := query.Select(); err != nil {
+		log.Printf("Error in ListWebhookDeliveries: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	eventIDs := make([]int64, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		eventIDs = append(eventIDs, delivery.EventID)
+	}
+	var events []*userEvent
+	err := s.db.ModelContext(stream.Context(), &events).Column("id", "type").Where("id IN (?)", pg.In(eventIDs)).Select()
+	if err != nil {
+		log.Printf("Error in ListWebhookDeliveries: %v", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	eventTypes := make(map[int64]string, len(events))
+	for _, event := range events {
+		eventTypes[event.Id] = event.Type
+	}
+
+	for _, delivery := range deliveries {
+		if err := stream.Send(delivery.toProto(eventTypes[delivery.EventID])); err != nil {
+			log.Printf("Error while sending delivery: %v", err)
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+// RedeliverWebhookDelivery schedules a delivery to be sent again, including dead ones
+func (s *DatabaseTestServer) RedeliverWebhookDelivery(ctx context.Context, req *service.DeliveryByIDRequest) (*service.WebhookDelivery, error) {
+	delivery := &webhookDelivery{Id: req.GetId()}
+	res, err := s.db.ModelContext(ctx, delivery).
+		Set("status = ?", service.DeliveryStatus_DELIVERY_STATUS_PENDING).
+		Set("attempts = 0").
+		Set("next_attempt_at = now()").
+		Set("last_error = ''").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		log.Printf("Error in RedeliverWebhookDelivery: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if res.RowsAffected() == 0 {
+		return nil, status.Errorf(codes.NotFound, "Delivery with id %v not found", req.GetId())
+	}
+	log.Printf("Scheduled redelivery of %v", req.GetId())
+	event := &userEvent{Id: delivery.EventID}
+	if err := s.db.ModelContext(ctx, event).Column("type").WherePK().Select(); err != nil {
+		log.Printf("Error in RedeliverWebhookDelivery: %v", err)
+	}
+	return delivery.toProto(event.Type), nil
+}
+
+// RunWebhookDispatcher sends pending webhook deliveries until context is done.
+// Several dispatchers may share one database, deliveries are leased with row locks.
+func (s *DatabaseTestServer) RunWebhookDispatcher(ctx context.Context, pollInterval time.Duration) {
+	client := &http.Client{Timeout: webhookTimeout}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.dispatchWebhooks(ctx, client)
+			if err != nil {
+				log.Printf("Error while dispatching webhooks: %v", err)
+			}
+			if err != nil || n < webhookBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchWebhooks leases a batch of due deliveries and attempts them
+func (s *DatabaseTestServer) dispatchWebhooks(ctx context.Context, client *http.Client) (int, error) {
+	var deliveries []*webhookDelivery
+	_, err := s.db.QueryContext(ctx, &deliveries, `
+		UPDATE webhook_deliveries SET next_attempt_at = now() + ?0 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ?1 AND next_attempt_at <= now()
+			ORDER BY id LIMIT ?2
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		int(webhookLease.Seconds()), service.DeliveryStatus_DELIVERY_STATUS_PENDING, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		s.attemptDelivery(ctx, client, delivery)
+	}
+	return len(deliveries), nil
+}
+
+func (s *DatabaseTestServer) attemptDelivery(ctx context.Context, client *http.Client, delivery *webhookDelivery) {
+	hook := &webhook{Id: delivery.WebhookID}
+	event := &userEvent{Id: delivery.EventID}
+	err := s.db.ModelContext(ctx, hook).WherePK().Select()
+	if err == nil {
+		err = s.db.ModelContext(ctx, event).WherePK().Select()
+	}
+	if err == nil {
+		err = deliver(ctx, client, hook, event, delivery.Id)
+	}
+
+	delivery.Attempts++
+	if err == nil {
+		now := time.Now()
+		delivery.Status = service.DeliveryStatus_DELIVERY_STATUS_DELIVERED
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts))
+		if delivery.Attempts >= webhookMaxAttempts {
+			delivery.Status = service.DeliveryStatus_DELIVERY_STATUS_DEAD
+			log.Printf("Delivery %v to webhook %v is dead: %v", delivery.Id, hook.Id, err)
+		}
+	}
+
+	_, err = s.db.ModelContext(ctx, delivery).
+		Column("status", "attempts", "next_attempt_at", "last_error", "delivered_at").
+		WherePK().
+		Update()
+	if err != nil {
+		log.Printf("Error while updating delivery %v: %v", delivery.Id, err)
+	}
+}
+
+func deliver(ctx context.Context, client *http.Client, hook *webhook, event *userEvent, deliveryID int64) error {
+	body, err := json.Marshal(webhookPayload{
+		ID:             event.Id,
+		Type:           event.Type,
+		Tags:           event.Tags,
+		CreatedAt:      event.CreatedAt,
+		User:           event.User,
+		Previous:       event.Previous,
+		ActorID:        event.ActorID,
+		ImpersonatedBy: event.ImpersonatedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(deliveryID, 10))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("receiver responded with %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package server
+
+import "testing"
+
+func TestWebhookLeaseOutlastsBatch(t *testing.T) {
+	if batch := webhookBatchSize * webhookTimeout; webhookLease <= batch {
+		t.Errorf("lease of %v is over before a batch timing out in %v, deliveries would be sent twice", webhookLease, batch)
+	}
+}
@@ -24,10 +24,90 @@ const _ = grpc.SupportPackageIsVersion7
 type DatabaseTestClient interface {
 	// GetUserByID retrieves user from database with given ID
 	GetUserByID(ctx context.Context, in *UserByIDRequest, opts ...grpc.CallOption) (*User, error)
-	// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise
+	// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise.
+	// A role granting admin privileges is not stored, it is requested on behalf of the actor of the call
+	// and waits for approval of another admin. Actors cannot change their own role.
+	// Deleted users cannot be updated, FailedPrecondition is returned.
 	AddOrUpdateUser(ctx context.Context, in *User, opts ...grpc.CallOption) (*UpdateResponse, error)
 	// SearchUsersByName searches users in database by part of a name
 	SearchUsersByName(ctx context.Context, in *SearchByNameRequest, opts ...grpc.CallOption) (DatabaseTest_SearchUsersByNameClient, error)
+	// GetUserAt retrieves the version of a user that was current at given time
+	GetUserAt(ctx context.Context, in *UserAtRequest, opts ...grpc.CallOption) (*User, error)
+	// ListUserVersions lists every recorded version of a user, oldest first
+	ListUserVersions(ctx context.Context, in *UserByIDRequest, opts ...grpc.CallOption) (DatabaseTest_ListUserVersionsClient, error)
+	// MergeUsers moves telegram link, group memberships and history of source user to target user,
+	// resolves conflicting fields with given strategy and soft-deletes source user.
+	// Taking a role granting admin privileges from source user needs approval like in AddOrUpdateUser.
+	MergeUsers(ctx context.Context, in *MergeUsersRequest, opts ...grpc.CallOption) (*MergeUsersResponse, error)
+	// DefineAttribute adds a custom user attribute or replaces its definition
+	DefineAttribute(ctx context.Context, in *AttributeDefinition, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// ListAttributeDefinitions lists definitions of custom user attributes
+	ListAttributeDefinitions(ctx context.Context, in *ListAttributeDefinitionsRequest, opts ...grpc.CallOption) (DatabaseTest_ListAttributeDefinitionsClient, error)
+	// DeleteAttributeDefinition removes a custom attribute and its values from every user
+	DeleteAttributeDefinition(ctx context.Context, in *AttributeByNameRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// SetUserAttribute sets one custom attribute of a user, other fields are left as they are stored
+	SetUserAttribute(ctx context.Context, in *SetUserAttributeRequest, opts ...grpc.CallOption) (*User, error)
+	// RegisterWebhook subscribes an URL to user change events
+	RegisterWebhook(ctx context.Context, in *Webhook, opts ...grpc.CallOption) (*Webhook, error)
+	// ListWebhooks lists registered webhooks without their secrets
+	ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (DatabaseTest_ListWebhooksClient, error)
+	// DeleteWebhook removes a webhook with its pending deliveries
+	DeleteWebhook(ctx context.Context, in *WebhookByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// ListWebhookDeliveries lists deliveries of a webhook, newest first
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (DatabaseTest_ListWebhookDeliveriesClient, error)
+	// RedeliverWebhookDelivery schedules a delivery to be sent again, including dead ones
+	RedeliverWebhookDelivery(ctx context.Context, in *DeliveryByIDRequest, opts ...grpc.CallOption) (*WebhookDelivery, error)
+	// RequestRoleChange creates a pending role change which needs approval of another admin.
+	// The requester is the actor of the call, who must be a read-write admin not impersonated by another admin.
+	RequestRoleChange(ctx context.Context, in *RoleChangeRequest, opts ...grpc.CallOption) (*RoleChangeRequest, error)
+	// ListRoleChangeRequests lists role change requests, newest first
+	ListRoleChangeRequests(ctx context.Context, in *ListRoleChangeRequestsRequest, opts ...grpc.CallOption) (DatabaseTest_ListRoleChangeRequestsClient, error)
+	// DecideRoleChangeRequest approves or rejects a pending role change. The decision is made by the actor of the call,
+	// who must be a read-write admin other than the requester and the user, not impersonated by another admin.
+	DecideRoleChangeRequest(ctx context.Context, in *RoleChangeDecision, opts ...grpc.CallOption) (*RoleChangeRequest, error)
+	// CreateInvitation creates an invitation code which grants a role and groups, not while impersonating.
+	// The creator is the actor of the call, who must be a read-write admin.
+	CreateInvitation(ctx context.Context, in *Invitation, opts ...grpc.CallOption) (*Invitation, error)
+	// ListInvitations lists invitations, newest first
+	ListInvitations(ctx context.Context, in *ListInvitationsRequest, opts ...grpc.CallOption) (DatabaseTest_ListInvitationsClient, error)
+	// RevokeInvitation makes an invitation unusable, not while impersonating
+	RevokeInvitation(ctx context.Context, in *InvitationByCodeRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// RedeemInvitation grants role and groups of an invitation to a user, creating the user if needed.
+	// A role granting admin privileges is requested on behalf of the creator of the invitation
+	// and waits for approval of another admin like in AddOrUpdateUser. Deleted users cannot redeem invitations.
+	RedeemInvitation(ctx context.Context, in *RedeemInvitationRequest, opts ...grpc.CallOption) (*RedeemInvitationResponse, error)
+	// ListRedemptions lists redemptions of an invitation, oldest first
+	ListRedemptions(ctx context.Context, in *InvitationByCodeRequest, opts ...grpc.CallOption) (DatabaseTest_ListRedemptionsClient, error)
+	// DeleteUser soft-deletes a user and unlinks their telegram chat, history of the user is kept
+	DeleteUser(ctx context.Context, in *UserByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// WatchUserEvents streams user change events recorded after given event,
+	// then keeps streaming new events until the call is cancelled
+	WatchUserEvents(ctx context.Context, in *WatchUserEventsRequest, opts ...grpc.CallOption) (DatabaseTest_WatchUserEventsClient, error)
+	// CreateAPIToken creates a personal access token of a user, the secret is returned only once
+	CreateAPIToken(ctx context.Context, in *APIToken, opts ...grpc.CallOption) (*APIToken, error)
+	// ListAPITokens lists tokens of a user, newest first, secrets are never returned
+	ListAPITokens(ctx context.Context, in *ListAPITokensRequest, opts ...grpc.CallOption) (DatabaseTest_ListAPITokensClient, error)
+	// RevokeAPIToken makes a token of a user unusable
+	RevokeAPIToken(ctx context.Context, in *APITokenByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// AuthenticateAPIToken returns the token with given secret and records its use.
+	// Unknown, revoked and expired tokens are rejected with UNAUTHENTICATED.
+	AuthenticateAPIToken(ctx context.Context, in *AuthenticateAPITokenRequest, opts ...grpc.CallOption) (*APIToken, error)
+	// CreateBroadcast schedules a message to every user with a linked chat who matches the filter, not while impersonating.
+	// The creator is the actor of the call, who must be a read-write admin.
+	CreateBroadcast(ctx context.Context, in *Broadcast, opts ...grpc.CallOption) (*Broadcast, error)
+	// GetBroadcast returns a broadcast with counts of its recipients by delivery status
+	GetBroadcast(ctx context.Context, in *BroadcastByIDRequest, opts ...grpc.CallOption) (*Broadcast, error)
+	// ListBroadcastRecipients lists recipients of a broadcast ordered by user ID
+	ListBroadcastRecipients(ctx context.Context, in *ListBroadcastRecipientsRequest, opts ...grpc.CallOption) (DatabaseTest_ListBroadcastRecipientsClient, error)
+	// StartDueBroadcasts resolves recipients of scheduled broadcasts whose time has come,
+	// then lists every broadcast which is being sent, oldest first
+	StartDueBroadcasts(ctx context.Context, in *StartDueBroadcastsRequest, opts ...grpc.CallOption) (DatabaseTest_StartDueBroadcastsClient, error)
+	// ClaimBroadcastRecipients leases pending recipients of a broadcast to the caller, ordered by user ID,
+	// so several bots sending at once do not message anyone twice. Recipients are claimed again once the lease is over.
+	ClaimBroadcastRecipients(ctx context.Context, in *ClaimBroadcastRecipientsRequest, opts ...grpc.CallOption) (DatabaseTest_ClaimBroadcastRecipientsClient, error)
+	// RecordBroadcastDelivery records an attempt to send a broadcast to a recipient.
+	// The broadcast is finished when none of its recipients are pending.
+	RecordBroadcastDelivery(ctx context.Context, in *BroadcastDelivery, opts ...grpc.CallOption) (*BroadcastRecipient, error)
 }
 
 type databaseTestClient struct {
@@ -88,102 +168,1454 @@ func (x *databaseTestSearchUsersByNameClient) Recv() (*User, error) {
 	return m, nil
 }
 
+func (c *databaseTestClient) GetUserAt(ctx context.Context, in *UserAtRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/GetUserAt", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListUserVersions(ctx context.Context, in *UserByIDRequest, opts ...grpc.CallOption) (DatabaseTest_ListUserVersionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[1], "/service.DatabaseTest/ListUserVersions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListUserVersionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListUserVersionsClient interface {
+	Recv() (*UserVersion, error)
+	grpc.ClientStream
+}
+
+type databaseTestListUserVersionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListUserVersionsClient) Recv() (*UserVersion, error) {
+	m := new(UserVersion)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) MergeUsers(ctx context.Context, in *MergeUsersRequest, opts ...grpc.CallOption) (*MergeUsersResponse, error) {
+	out := new(MergeUsersResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/MergeUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) DefineAttribute(ctx context.Context, in *AttributeDefinition, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/DefineAttribute", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListAttributeDefinitions(ctx context.Context, in *ListAttributeDefinitionsRequest, opts ...grpc.CallOption) (DatabaseTest_ListAttributeDefinitionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[2], "/service.DatabaseTest/ListAttributeDefinitions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListAttributeDefinitionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListAttributeDefinitionsClient interface {
+	Recv() (*AttributeDefinition, error)
+	grpc.ClientStream
+}
+
+type databaseTestListAttributeDefinitionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListAttributeDefinitionsClient) Recv() (*AttributeDefinition, error) {
+	m := new(AttributeDefinition)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) DeleteAttributeDefinition(ctx context.Context, in *AttributeByNameRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/DeleteAttributeDefinition", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) SetUserAttribute(ctx context.Context, in *SetUserAttributeRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/SetUserAttribute", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) RegisterWebhook(ctx context.Context, in *Webhook, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RegisterWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListWebhooks(ctx context.Context, in *ListWebhooksRequest, opts ...grpc.CallOption) (DatabaseTest_ListWebhooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[3], "/service.DatabaseTest/ListWebhooks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListWebhooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListWebhooksClient interface {
+	Recv() (*Webhook, error)
+	grpc.ClientStream
+}
+
+type databaseTestListWebhooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListWebhooksClient) Recv() (*Webhook, error) {
+	m := new(Webhook)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) DeleteWebhook(ctx context.Context, in *WebhookByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/DeleteWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (DatabaseTest_ListWebhookDeliveriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[4], "/service.DatabaseTest/ListWebhookDeliveries", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListWebhookDeliveriesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListWebhookDeliveriesClient interface {
+	Recv() (*WebhookDelivery, error)
+	grpc.ClientStream
+}
+
+type databaseTestListWebhookDeliveriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListWebhookDeliveriesClient) Recv() (*WebhookDelivery, error) {
+	m := new(WebhookDelivery)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) RedeliverWebhookDelivery(ctx context.Context, in *DeliveryByIDRequest, opts ...grpc.CallOption) (*WebhookDelivery, error) {
+	out := new(WebhookDelivery)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RedeliverWebhookDelivery", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) RequestRoleChange(ctx context.Context, in *RoleChangeRequest, opts ...grpc.CallOption) (*RoleChangeRequest, error) {
+	out := new(RoleChangeRequest)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RequestRoleChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListRoleChangeRequests(ctx context.Context, in *ListRoleChangeRequestsRequest, opts ...grpc.CallOption) (DatabaseTest_ListRoleChangeRequestsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[5], "/service.DatabaseTest/ListRoleChangeRequests", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListRoleChangeRequestsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListRoleChangeRequestsClient interface {
+	Recv() (*RoleChangeRequest, error)
+	grpc.ClientStream
+}
+
+type databaseTestListRoleChangeRequestsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListRoleChangeRequestsClient) Recv() (*RoleChangeRequest, error) {
+	m := new(RoleChangeRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) DecideRoleChangeRequest(ctx context.Context, in *RoleChangeDecision, opts ...grpc.CallOption) (*RoleChangeRequest, error) {
+	out := new(RoleChangeRequest)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/DecideRoleChangeRequest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) CreateInvitation(ctx context.Context, in *Invitation, opts ...grpc.CallOption) (*Invitation, error) {
+	out := new(Invitation)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/CreateInvitation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListInvitations(ctx context.Context, in *ListInvitationsRequest, opts ...grpc.CallOption) (DatabaseTest_ListInvitationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[6], "/service.DatabaseTest/ListInvitations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListInvitationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListInvitationsClient interface {
+	Recv() (*Invitation, error)
+	grpc.ClientStream
+}
+
+type databaseTestListInvitationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListInvitationsClient) Recv() (*Invitation, error) {
+	m := new(Invitation)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) RevokeInvitation(ctx context.Context, in *InvitationByCodeRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RevokeInvitation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) RedeemInvitation(ctx context.Context, in *RedeemInvitationRequest, opts ...grpc.CallOption) (*RedeemInvitationResponse, error) {
+	out := new(RedeemInvitationResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RedeemInvitation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListRedemptions(ctx context.Context, in *InvitationByCodeRequest, opts ...grpc.CallOption) (DatabaseTest_ListRedemptionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[7], "/service.DatabaseTest/ListRedemptions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListRedemptionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListRedemptionsClient interface {
+	Recv() (*Redemption, error)
+	grpc.ClientStream
+}
+
+type databaseTestListRedemptionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListRedemptionsClient) Recv() (*Redemption, error) {
+	m := new(Redemption)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) DeleteUser(ctx context.Context, in *UserByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) WatchUserEvents(ctx context.Context, in *WatchUserEventsRequest, opts ...grpc.CallOption) (DatabaseTest_WatchUserEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[8], "/service.DatabaseTest/WatchUserEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestWatchUserEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_WatchUserEventsClient interface {
+	Recv() (*UserEvent, error)
+	grpc.ClientStream
+}
+
+type databaseTestWatchUserEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestWatchUserEventsClient) Recv() (*UserEvent, error) {
+	m := new(UserEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) CreateAPIToken(ctx context.Context, in *APIToken, opts ...grpc.CallOption) (*APIToken, error) {
+	out := new(APIToken)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/CreateAPIToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListAPITokens(ctx context.Context, in *ListAPITokensRequest, opts ...grpc.CallOption) (DatabaseTest_ListAPITokensClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[9], "/service.DatabaseTest/ListAPITokens", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListAPITokensClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListAPITokensClient interface {
+	Recv() (*APIToken, error)
+	grpc.ClientStream
+}
+
+type databaseTestListAPITokensClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListAPITokensClient) Recv() (*APIToken, error) {
+	m := new(APIToken)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) RevokeAPIToken(ctx context.Context, in *APITokenByIDRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RevokeAPIToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) AuthenticateAPIToken(ctx context.Context, in *AuthenticateAPITokenRequest, opts ...grpc.CallOption) (*APIToken, error) {
+	out := new(APIToken)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/AuthenticateAPIToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) CreateBroadcast(ctx context.Context, in *Broadcast, opts ...grpc.CallOption) (*Broadcast, error) {
+	out := new(Broadcast)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/CreateBroadcast", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) GetBroadcast(ctx context.Context, in *BroadcastByIDRequest, opts ...grpc.CallOption) (*Broadcast, error) {
+	out := new(Broadcast)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/GetBroadcast", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseTestClient) ListBroadcastRecipients(ctx context.Context, in *ListBroadcastRecipientsRequest, opts ...grpc.CallOption) (DatabaseTest_ListBroadcastRecipientsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[10], "/service.DatabaseTest/ListBroadcastRecipients", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestListBroadcastRecipientsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ListBroadcastRecipientsClient interface {
+	Recv() (*BroadcastRecipient, error)
+	grpc.ClientStream
+}
+
+type databaseTestListBroadcastRecipientsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestListBroadcastRecipientsClient) Recv() (*BroadcastRecipient, error) {
+	m := new(BroadcastRecipient)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) StartDueBroadcasts(ctx context.Context, in *StartDueBroadcastsRequest, opts ...grpc.CallOption) (DatabaseTest_StartDueBroadcastsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[11], "/service.DatabaseTest/StartDueBroadcasts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestStartDueBroadcastsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_StartDueBroadcastsClient interface {
+	Recv() (*Broadcast, error)
+	grpc.ClientStream
+}
+
+type databaseTestStartDueBroadcastsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestStartDueBroadcastsClient) Recv() (*Broadcast, error) {
+	m := new(Broadcast)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) ClaimBroadcastRecipients(ctx context.Context, in *ClaimBroadcastRecipientsRequest, opts ...grpc.CallOption) (DatabaseTest_ClaimBroadcastRecipientsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DatabaseTest_ServiceDesc.Streams[12], "/service.DatabaseTest/ClaimBroadcastRecipients", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &databaseTestClaimBroadcastRecipientsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DatabaseTest_ClaimBroadcastRecipientsClient interface {
+	Recv() (*BroadcastRecipient, error)
+	grpc.ClientStream
+}
+
+type databaseTestClaimBroadcastRecipientsClient struct {
+	grpc.ClientStream
+}
+
+func (x *databaseTestClaimBroadcastRecipientsClient) Recv() (*BroadcastRecipient, error) {
+	m := new(BroadcastRecipient)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *databaseTestClient) RecordBroadcastDelivery(ctx context.Context, in *BroadcastDelivery, opts ...grpc.CallOption) (*BroadcastRecipient, error) {
+	out := new(BroadcastRecipient)
+	err := c.cc.Invoke(ctx, "/service.DatabaseTest/RecordBroadcastDelivery", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DatabaseTestServer is the server API for DatabaseTest service.
 // All implementations must embed UnimplementedDatabaseTestServer
 // for forward compatibility
 type DatabaseTestServer interface {
 	// GetUserByID retrieves user from database with given ID
 	GetUserByID(context.Context, *UserByIDRequest) (*User, error)
-	// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise
+	// AddOrUpdateUser adds user to database if user's ID didn't exist, updates fields otherwise.
+	// A role granting admin privileges is not stored, it is requested on behalf of the actor of the call
+	// and waits for approval of another admin. Actors cannot change their own role.
+	// Deleted users cannot be updated, FailedPrecondition is returned.
 	AddOrUpdateUser(context.Context, *User) (*UpdateResponse, error)
 	// SearchUsersByName searches users in database by part of a name
 	SearchUsersByName(*SearchByNameRequest, DatabaseTest_SearchUsersByNameServer) error
+	// GetUserAt retrieves the version of a user that was current at given time
+	GetUserAt(context.Context, *UserAtRequest) (*User, error)
+	// ListUserVersions lists every recorded version of a user, oldest first
+	ListUserVersions(*UserByIDRequest, DatabaseTest_ListUserVersionsServer) error
+	// MergeUsers moves telegram link, group memberships and history of source user to target user,
+	// resolves conflicting fields with given strategy and soft-deletes source user.
+	// Taking a role granting admin privileges from source user needs approval like in AddOrUpdateUser.
+	MergeUsers(context.Context, *MergeUsersRequest) (*MergeUsersResponse, error)
+	// DefineAttribute adds a custom user attribute or replaces its definition
+	DefineAttribute(context.Context, *AttributeDefinition) (*UpdateResponse, error)
+	// ListAttributeDefinitions lists definitions of custom user attributes
+	ListAttributeDefinitions(*ListAttributeDefinitionsRequest, DatabaseTest_ListAttributeDefinitionsServer) error
+	// DeleteAttributeDefinition removes a custom attribute and its values from every user
+	DeleteAttributeDefinition(context.Context, *AttributeByNameRequest) (*UpdateResponse, error)
+	// SetUserAttribute sets one custom attribute of a user, other fields are left as they are stored
+	SetUserAttribute(context.Context, *SetUserAttributeRequest) (*User, error)
+	// RegisterWebhook subscribes an URL to user change events
+	RegisterWebhook(context.Context, *Webhook) (*Webhook, error)
+	// ListWebhooks lists registered webhooks without their secrets
+	ListWebhooks(*ListWebhooksRequest, DatabaseTest_ListWebhooksServer) error
+	// DeleteWebhook removes a webhook with its pending deliveries
+	DeleteWebhook(context.Context, *WebhookByIDRequest) (*UpdateResponse, error)
+	// ListWebhookDeliveries lists deliveries of a webhook, newest first
+	ListWebhookDeliveries(*ListWebhookDeliveriesRequest, DatabaseTest_ListWebhookDeliveriesServer) error
+	// RedeliverWebhookDelivery schedules a delivery to be sent again, including dead ones
+	RedeliverWebhookDelivery(context.Context, *DeliveryByIDRequest) (*WebhookDelivery, error)
+	// RequestRoleChange creates a pending role change which needs approval of another admin.
+	// The requester is the actor of the call, who must be a read-write admin not impersonated by another admin.
+	RequestRoleChange(context.Context, *RoleChangeRequest) (*RoleChangeRequest, error)
+	// ListRoleChangeRequests lists role change requests, newest first
+	ListRoleChangeRequests(*ListRoleChangeRequestsRequest, DatabaseTest_ListRoleChangeRequestsServer) error
+	// DecideRoleChangeRequest approves or rejects a pending role change. The decision is made by the actor of the call,
+	// who must be a read-write admin other than the requester and the user, not impersonated by another admin.
+	DecideRoleChangeRequest(context.Context, *RoleChangeDecision) (*RoleChangeRequest, error)
+	// CreateInvitation creates an invitation code which grants a role and groups, not while impersonating.
+	// The creator is the actor of the call, who must be a read-write admin.
+	CreateInvitation(context.Context, *Invitation) (*Invitation, error)
+	// ListInvitations lists invitations, newest first
+	ListInvitations(*ListInvitationsRequest, DatabaseTest_ListInvitationsServer) error
+	// RevokeInvitation makes an invitation unusable, not while impersonating
+	RevokeInvitation(context.Context, *InvitationByCodeRequest) (*UpdateResponse, error)
+	// RedeemInvitation grants role and groups of an invitation to a user, creating the user if needed.
+	// A role granting admin privileges is requested on behalf of the creator of the invitation
+	// and waits for approval of another admin like in AddOrUpdateUser. Deleted users cannot redeem invitations.
+	RedeemInvitation(context.Context, *RedeemInvitationRequest) (*RedeemInvitationResponse, error)
+	// ListRedemptions lists redemptions of an invitation, oldest first
+	ListRedemptions(*InvitationByCodeRequest, DatabaseTest_ListRedemptionsServer) error
+	// DeleteUser soft-deletes a user and unlinks their telegram chat, history of the user is kept
+	DeleteUser(context.Context, *UserByIDRequest) (*UpdateResponse, error)
+	// WatchUserEvents streams user change events recorded after given event,
+	// then keeps streaming new events until the call is cancelled
+	WatchUserEvents(*WatchUserEventsRequest, DatabaseTest_WatchUserEventsServer) error
+	// CreateAPIToken creates a personal access token of a user, the secret is returned only once
+	CreateAPIToken(context.Context, *APIToken) (*APIToken, error)
+	// ListAPITokens lists tokens of a user, newest first, secrets are never returned
+	ListAPITokens(*ListAPITokensRequest, DatabaseTest_ListAPITokensServer) error
+	// RevokeAPIToken makes a token of a user unusable
+	RevokeAPIToken(context.Context, *APITokenByIDRequest) (*UpdateResponse, error)
+	// AuthenticateAPIToken returns the token with given secret and records its use.
+	// Unknown, revoked and expired tokens are rejected with UNAUTHENTICATED.
+	AuthenticateAPIToken(context.Context, *AuthenticateAPITokenRequest) (*APIToken, error)
+	// CreateBroadcast schedules a message to every user with a linked chat who matches the filter, not while impersonating.
+	// The creator is the actor of the call, who must be a read-write admin.
+	CreateBroadcast(context.Context, *Broadcast) (*Broadcast, error)
+	// GetBroadcast returns a broadcast with counts of its recipients by delivery status
+	GetBroadcast(context.Context, *BroadcastByIDRequest) (*Broadcast, error)
+	// ListBroadcastRecipients lists recipients of a broadcast ordered by user ID
+	ListBroadcastRecipients(*ListBroadcastRecipientsRequest, DatabaseTest_ListBroadcastRecipientsServer) error
+	// StartDueBroadcasts resolves recipients of scheduled broadcasts whose time has come,
+	// then lists every broadcast which is being sent, oldest first
+	StartDueBroadcasts(*StartDueBroadcastsRequest, DatabaseTest_StartDueBroadcastsServer) error
+	// ClaimBroadcastRecipients leases pending recipients of a broadcast to the caller, ordered by user ID,
+	// so several bots sending at once do not message anyone twice. Recipients are claimed again once the lease is over.
+	ClaimBroadcastRecipients(*ClaimBroadcastRecipientsRequest, DatabaseTest_ClaimBroadcastRecipientsServer) error
+	// RecordBroadcastDelivery records an attempt to send a broadcast to a recipient.
+	// The broadcast is finished when none of its recipients are pending.
+	RecordBroadcastDelivery(context.Context, *BroadcastDelivery) (*BroadcastRecipient, error)
+	mustEmbedUnimplementedDatabaseTestServer()
+}
+
+// UnimplementedDatabaseTestServer must be embedded to have forward compatible implementations.
+type UnimplementedDatabaseTestServer struct {
+}
+
+func (UnimplementedDatabaseTestServer) GetUserByID(context.Context, *UserByIDRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserByID not implemented")
+}
+func (UnimplementedDatabaseTestServer) AddOrUpdateUser(context.Context, *User) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddOrUpdateUser not implemented")
+}
+func (UnimplementedDatabaseTestServer) SearchUsersByName(*SearchByNameRequest, DatabaseTest_SearchUsersByNameServer) error {
+	return status.Errorf(codes.Unimplemented, "method SearchUsersByName not implemented")
+}
+func (UnimplementedDatabaseTestServer) GetUserAt(context.Context, *UserAtRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserAt not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListUserVersions(*UserByIDRequest, DatabaseTest_ListUserVersionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListUserVersions not implemented")
+}
+func (UnimplementedDatabaseTestServer) MergeUsers(context.Context, *MergeUsersRequest) (*MergeUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeUsers not implemented")
+}
+func (UnimplementedDatabaseTestServer) DefineAttribute(context.Context, *AttributeDefinition) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DefineAttribute not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListAttributeDefinitions(*ListAttributeDefinitionsRequest, DatabaseTest_ListAttributeDefinitionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListAttributeDefinitions not implemented")
+}
+func (UnimplementedDatabaseTestServer) DeleteAttributeDefinition(context.Context, *AttributeByNameRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAttributeDefinition not implemented")
+}
+func (UnimplementedDatabaseTestServer) SetUserAttribute(context.Context, *SetUserAttributeRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUserAttribute not implemented")
+}
+func (UnimplementedDatabaseTestServer) RegisterWebhook(context.Context, *Webhook) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterWebhook not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListWebhooks(*ListWebhooksRequest, DatabaseTest_ListWebhooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListWebhooks not implemented")
+}
+func (UnimplementedDatabaseTestServer) DeleteWebhook(context.Context, *WebhookByIDRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListWebhookDeliveries(*ListWebhookDeliveriesRequest, DatabaseTest_ListWebhookDeliveriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedDatabaseTestServer) RedeliverWebhookDelivery(context.Context, *DeliveryByIDRequest) (*WebhookDelivery, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedeliverWebhookDelivery not implemented")
+}
+func (UnimplementedDatabaseTestServer) RequestRoleChange(context.Context, *RoleChangeRequest) (*RoleChangeRequest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestRoleChange not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListRoleChangeRequests(*ListRoleChangeRequestsRequest, DatabaseTest_ListRoleChangeRequestsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListRoleChangeRequests not implemented")
+}
+func (UnimplementedDatabaseTestServer) DecideRoleChangeRequest(context.Context, *RoleChangeDecision) (*RoleChangeRequest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecideRoleChangeRequest not implemented")
+}
+func (UnimplementedDatabaseTestServer) CreateInvitation(context.Context, *Invitation) (*Invitation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateInvitation not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListInvitations(*ListInvitationsRequest, DatabaseTest_ListInvitationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListInvitations not implemented")
+}
+func (UnimplementedDatabaseTestServer) RevokeInvitation(context.Context, *InvitationByCodeRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeInvitation not implemented")
+}
+func (UnimplementedDatabaseTestServer) RedeemInvitation(context.Context, *RedeemInvitationRequest) (*RedeemInvitationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedeemInvitation not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListRedemptions(*InvitationByCodeRequest, DatabaseTest_ListRedemptionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListRedemptions not implemented")
+}
+func (UnimplementedDatabaseTestServer) DeleteUser(context.Context, *UserByIDRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedDatabaseTestServer) WatchUserEvents(*WatchUserEventsRequest, DatabaseTest_WatchUserEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchUserEvents not implemented")
+}
+func (UnimplementedDatabaseTestServer) CreateAPIToken(context.Context, *APIToken) (*APIToken, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAPIToken not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListAPITokens(*ListAPITokensRequest, DatabaseTest_ListAPITokensServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListAPITokens not implemented")
+}
+func (UnimplementedDatabaseTestServer) RevokeAPIToken(context.Context, *APITokenByIDRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeAPIToken not implemented")
+}
+func (UnimplementedDatabaseTestServer) AuthenticateAPIToken(context.Context, *AuthenticateAPITokenRequest) (*APIToken, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AuthenticateAPIToken not implemented")
+}
+func (UnimplementedDatabaseTestServer) CreateBroadcast(context.Context, *Broadcast) (*Broadcast, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateBroadcast not implemented")
+}
+func (UnimplementedDatabaseTestServer) GetBroadcast(context.Context, *BroadcastByIDRequest) (*Broadcast, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBroadcast not implemented")
+}
+func (UnimplementedDatabaseTestServer) ListBroadcastRecipients(*ListBroadcastRecipientsRequest, DatabaseTest_ListBroadcastRecipientsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListBroadcastRecipients not implemented")
+}
+func (UnimplementedDatabaseTestServer) StartDueBroadcasts(*StartDueBroadcastsRequest, DatabaseTest_StartDueBroadcastsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StartDueBroadcasts not implemented")
+}
+func (UnimplementedDatabaseTestServer) ClaimBroadcastRecipients(*ClaimBroadcastRecipientsRequest, DatabaseTest_ClaimBroadcastRecipientsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ClaimBroadcastRecipients not implemented")
+}
+func (UnimplementedDatabaseTestServer) RecordBroadcastDelivery(context.Context, *BroadcastDelivery) (*BroadcastRecipient, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordBroadcastDelivery not implemented")
+}
+func (UnimplementedDatabaseTestServer) mustEmbedUnimplementedDatabaseTestServer() {}
+
+// UnsafeDatabaseTestServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DatabaseTestServer will
+// result in compilation errors.
+type UnsafeDatabaseTestServer interface {
 	mustEmbedUnimplementedDatabaseTestServer()
 }
 
-// UnimplementedDatabaseTestServer must be embedded to have forward compatible implementations.
-type UnimplementedDatabaseTestServer struct {
+func RegisterDatabaseTestServer(s grpc.ServiceRegistrar, srv DatabaseTestServer) {
+	s.RegisterService(&DatabaseTest_ServiceDesc, srv)
+}
+
+func _DatabaseTest_GetUserByID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserByIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).GetUserByID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/GetUserByID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).GetUserByID(ctx, req.(*UserByIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_AddOrUpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(User)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).AddOrUpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/AddOrUpdateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).AddOrUpdateUser(ctx, req.(*User))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_SearchUsersByName_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchByNameRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).SearchUsersByName(m, &databaseTestSearchUsersByNameServer{stream})
+}
+
+type DatabaseTest_SearchUsersByNameServer interface {
+	Send(*User) error
+	grpc.ServerStream
+}
+
+type databaseTestSearchUsersByNameServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestSearchUsersByNameServer) Send(m *User) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_GetUserAt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserAtRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).GetUserAt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/GetUserAt",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).GetUserAt(ctx, req.(*UserAtRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListUserVersions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UserByIDRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListUserVersions(m, &databaseTestListUserVersionsServer{stream})
+}
+
+type DatabaseTest_ListUserVersionsServer interface {
+	Send(*UserVersion) error
+	grpc.ServerStream
+}
+
+type databaseTestListUserVersionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListUserVersionsServer) Send(m *UserVersion) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_MergeUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).MergeUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/MergeUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).MergeUsers(ctx, req.(*MergeUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_DefineAttribute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AttributeDefinition)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).DefineAttribute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/DefineAttribute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).DefineAttribute(ctx, req.(*AttributeDefinition))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListAttributeDefinitions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListAttributeDefinitionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListAttributeDefinitions(m, &databaseTestListAttributeDefinitionsServer{stream})
+}
+
+type DatabaseTest_ListAttributeDefinitionsServer interface {
+	Send(*AttributeDefinition) error
+	grpc.ServerStream
+}
+
+type databaseTestListAttributeDefinitionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListAttributeDefinitionsServer) Send(m *AttributeDefinition) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_DeleteAttributeDefinition_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AttributeByNameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).DeleteAttributeDefinition(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/DeleteAttributeDefinition",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).DeleteAttributeDefinition(ctx, req.(*AttributeByNameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_SetUserAttribute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUserAttributeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).SetUserAttribute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/SetUserAttribute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).SetUserAttribute(ctx, req.(*SetUserAttributeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_RegisterWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Webhook)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RegisterWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RegisterWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RegisterWebhook(ctx, req.(*Webhook))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListWebhooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListWebhooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListWebhooks(m, &databaseTestListWebhooksServer{stream})
+}
+
+type DatabaseTest_ListWebhooksServer interface {
+	Send(*Webhook) error
+	grpc.ServerStream
+}
+
+type databaseTestListWebhooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListWebhooksServer) Send(m *Webhook) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookByIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/DeleteWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).DeleteWebhook(ctx, req.(*WebhookByIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListWebhookDeliveries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListWebhookDeliveriesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListWebhookDeliveries(m, &databaseTestListWebhookDeliveriesServer{stream})
+}
+
+type DatabaseTest_ListWebhookDeliveriesServer interface {
+	Send(*WebhookDelivery) error
+	grpc.ServerStream
+}
+
+type databaseTestListWebhookDeliveriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListWebhookDeliveriesServer) Send(m *WebhookDelivery) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_RedeliverWebhookDelivery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeliveryByIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RedeliverWebhookDelivery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RedeliverWebhookDelivery",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RedeliverWebhookDelivery(ctx, req.(*DeliveryByIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_RequestRoleChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleChangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RequestRoleChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RequestRoleChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RequestRoleChange(ctx, req.(*RoleChangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListRoleChangeRequests_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRoleChangeRequestsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListRoleChangeRequests(m, &databaseTestListRoleChangeRequestsServer{stream})
+}
+
+type DatabaseTest_ListRoleChangeRequestsServer interface {
+	Send(*RoleChangeRequest) error
+	grpc.ServerStream
+}
+
+type databaseTestListRoleChangeRequestsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListRoleChangeRequestsServer) Send(m *RoleChangeRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_DecideRoleChangeRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleChangeDecision)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).DecideRoleChangeRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/DecideRoleChangeRequest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).DecideRoleChangeRequest(ctx, req.(*RoleChangeDecision))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_CreateInvitation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Invitation)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).CreateInvitation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/CreateInvitation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).CreateInvitation(ctx, req.(*Invitation))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListInvitations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListInvitationsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListInvitations(m, &databaseTestListInvitationsServer{stream})
+}
+
+type DatabaseTest_ListInvitationsServer interface {
+	Send(*Invitation) error
+	grpc.ServerStream
+}
+
+type databaseTestListInvitationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListInvitationsServer) Send(m *Invitation) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_RevokeInvitation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvitationByCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RevokeInvitation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RevokeInvitation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RevokeInvitation(ctx, req.(*InvitationByCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_RedeemInvitation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RedeemInvitationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RedeemInvitation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RedeemInvitation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RedeemInvitation(ctx, req.(*RedeemInvitationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (UnimplementedDatabaseTestServer) GetUserByID(context.Context, *UserByIDRequest) (*User, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetUserByID not implemented")
-}
-func (UnimplementedDatabaseTestServer) AddOrUpdateUser(context.Context, *User) (*UpdateResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method AddOrUpdateUser not implemented")
+func _DatabaseTest_ListRedemptions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InvitationByCodeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListRedemptions(m, &databaseTestListRedemptionsServer{stream})
 }
-func (UnimplementedDatabaseTestServer) SearchUsersByName(*SearchByNameRequest, DatabaseTest_SearchUsersByNameServer) error {
-	return status.Errorf(codes.Unimplemented, "method SearchUsersByName not implemented")
+
+type DatabaseTest_ListRedemptionsServer interface {
+	Send(*Redemption) error
+	grpc.ServerStream
 }
-func (UnimplementedDatabaseTestServer) mustEmbedUnimplementedDatabaseTestServer() {}
 
-// UnsafeDatabaseTestServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to DatabaseTestServer will
-// result in compilation errors.
-type UnsafeDatabaseTestServer interface {
-	mustEmbedUnimplementedDatabaseTestServer()
+type databaseTestListRedemptionsServer struct {
+	grpc.ServerStream
 }
 
-func RegisterDatabaseTestServer(s grpc.ServiceRegistrar, srv DatabaseTestServer) {
-	s.RegisterService(&DatabaseTest_ServiceDesc, srv)
+func (x *databaseTestListRedemptionsServer) Send(m *Redemption) error {
+	return x.ServerStream.SendMsg(m)
 }
 
-func _DatabaseTest_GetUserByID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+func _DatabaseTest_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UserByIDRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(DatabaseTestServer).GetUserByID(ctx, in)
+		return srv.(DatabaseTestServer).DeleteUser(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/service.DatabaseTest/GetUserByID",
+		FullMethod: "/service.DatabaseTest/DeleteUser",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(DatabaseTestServer).GetUserByID(ctx, req.(*UserByIDRequest))
+		return srv.(DatabaseTestServer).DeleteUser(ctx, req.(*UserByIDRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _DatabaseTest_AddOrUpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(User)
+func _DatabaseTest_WatchUserEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchUserEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).WatchUserEvents(m, &databaseTestWatchUserEventsServer{stream})
+}
+
+type DatabaseTest_WatchUserEventsServer interface {
+	Send(*UserEvent) error
+	grpc.ServerStream
+}
+
+type databaseTestWatchUserEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestWatchUserEventsServer) Send(m *UserEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_CreateAPIToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(APIToken)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(DatabaseTestServer).AddOrUpdateUser(ctx, in)
+		return srv.(DatabaseTestServer).CreateAPIToken(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/service.DatabaseTest/AddOrUpdateUser",
+		FullMethod: "/service.DatabaseTest/CreateAPIToken",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(DatabaseTestServer).AddOrUpdateUser(ctx, req.(*User))
+		return srv.(DatabaseTestServer).CreateAPIToken(ctx, req.(*APIToken))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _DatabaseTest_SearchUsersByName_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(SearchByNameRequest)
+func _DatabaseTest_ListAPITokens_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListAPITokensRequest)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
-	return srv.(DatabaseTestServer).SearchUsersByName(m, &databaseTestSearchUsersByNameServer{stream})
+	return srv.(DatabaseTestServer).ListAPITokens(m, &databaseTestListAPITokensServer{stream})
 }
 
-type DatabaseTest_SearchUsersByNameServer interface {
-	Send(*User) error
+type DatabaseTest_ListAPITokensServer interface {
+	Send(*APIToken) error
 	grpc.ServerStream
 }
 
-type databaseTestSearchUsersByNameServer struct {
+type databaseTestListAPITokensServer struct {
 	grpc.ServerStream
 }
 
-func (x *databaseTestSearchUsersByNameServer) Send(m *User) error {
+func (x *databaseTestListAPITokensServer) Send(m *APIToken) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_RevokeAPIToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(APITokenByIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RevokeAPIToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RevokeAPIToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RevokeAPIToken(ctx, req.(*APITokenByIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_AuthenticateAPIToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateAPITokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).AuthenticateAPIToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/AuthenticateAPIToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).AuthenticateAPIToken(ctx, req.(*AuthenticateAPITokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_CreateBroadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Broadcast)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).CreateBroadcast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/CreateBroadcast",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).CreateBroadcast(ctx, req.(*Broadcast))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_GetBroadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BroadcastByIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).GetBroadcast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/GetBroadcast",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).GetBroadcast(ctx, req.(*BroadcastByIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseTest_ListBroadcastRecipients_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListBroadcastRecipientsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ListBroadcastRecipients(m, &databaseTestListBroadcastRecipientsServer{stream})
+}
+
+type DatabaseTest_ListBroadcastRecipientsServer interface {
+	Send(*BroadcastRecipient) error
+	grpc.ServerStream
+}
+
+type databaseTestListBroadcastRecipientsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestListBroadcastRecipientsServer) Send(m *BroadcastRecipient) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_StartDueBroadcasts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StartDueBroadcastsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).StartDueBroadcasts(m, &databaseTestStartDueBroadcastsServer{stream})
+}
+
+type DatabaseTest_StartDueBroadcastsServer interface {
+	Send(*Broadcast) error
+	grpc.ServerStream
+}
+
+type databaseTestStartDueBroadcastsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestStartDueBroadcastsServer) Send(m *Broadcast) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DatabaseTest_ClaimBroadcastRecipients_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ClaimBroadcastRecipientsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DatabaseTestServer).ClaimBroadcastRecipients(m, &databaseTestClaimBroadcastRecipientsServer{stream})
+}
+
+type DatabaseTest_ClaimBroadcastRecipientsServer interface {
+	Send(*BroadcastRecipient) error
+	grpc.ServerStream
+}
+
+type databaseTestClaimBroadcastRecipientsServer struct {
+	grpc.ServerStream
+}
+
+func (x *databaseTestClaimBroadcastRecipientsServer) Send(m *BroadcastRecipient) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _DatabaseTest_RecordBroadcastDelivery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BroadcastDelivery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseTestServer).RecordBroadcastDelivery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/service.DatabaseTest/RecordBroadcastDelivery",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseTestServer).RecordBroadcastDelivery(ctx, req.(*BroadcastDelivery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DatabaseTest_ServiceDesc is the grpc.ServiceDesc for DatabaseTest service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -199,6 +1631,86 @@ var DatabaseTest_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AddOrUpdateUser",
 			Handler:    _DatabaseTest_AddOrUpdateUser_Handler,
 		},
+		{
+			MethodName: "GetUserAt",
+			Handler:    _DatabaseTest_GetUserAt_Handler,
+		},
+		{
+			MethodName: "MergeUsers",
+			Handler:    _DatabaseTest_MergeUsers_Handler,
+		},
+		{
+			MethodName: "DefineAttribute",
+			Handler:    _DatabaseTest_DefineAttribute_Handler,
+		},
+		{
+			MethodName: "DeleteAttributeDefinition",
+			Handler:    _DatabaseTest_DeleteAttributeDefinition_Handler,
+		},
+		{
+			MethodName: "SetUserAttribute",
+			Handler:    _DatabaseTest_SetUserAttribute_Handler,
+		},
+		{
+			MethodName: "RegisterWebhook",
+			Handler:    _DatabaseTest_RegisterWebhook_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _DatabaseTest_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "RedeliverWebhookDelivery",
+			Handler:    _DatabaseTest_RedeliverWebhookDelivery_Handler,
+		},
+		{
+			MethodName: "RequestRoleChange",
+			Handler:    _DatabaseTest_RequestRoleChange_Handler,
+		},
+		{
+			MethodName: "DecideRoleChangeRequest",
+			Handler:    _DatabaseTest_DecideRoleChangeRequest_Handler,
+		},
+		{
+			MethodName: "CreateInvitation",
+			Handler:    _DatabaseTest_CreateInvitation_Handler,
+		},
+		{
+			MethodName: "RevokeInvitation",
+			Handler:    _DatabaseTest_RevokeInvitation_Handler,
+		},
+		{
+			MethodName: "RedeemInvitation",
+			Handler:    _DatabaseTest_RedeemInvitation_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _DatabaseTest_DeleteUser_Handler,
+		},
+		{
+			MethodName: "CreateAPIToken",
+			Handler:    _DatabaseTest_CreateAPIToken_Handler,
+		},
+		{
+			MethodName: "RevokeAPIToken",
+			Handler:    _DatabaseTest_RevokeAPIToken_Handler,
+		},
+		{
+			MethodName: "AuthenticateAPIToken",
+			Handler:    _DatabaseTest_AuthenticateAPIToken_Handler,
+		},
+		{
+			MethodName: "CreateBroadcast",
+			Handler:    _DatabaseTest_CreateBroadcast_Handler,
+		},
+		{
+			MethodName: "GetBroadcast",
+			Handler:    _DatabaseTest_GetBroadcast_Handler,
+		},
+		{
+			MethodName: "RecordBroadcastDelivery",
+			Handler:    _DatabaseTest_RecordBroadcastDelivery_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -206,6 +1718,66 @@ var DatabaseTest_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _DatabaseTest_SearchUsersByName_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ListUserVersions",
+			Handler:       _DatabaseTest_ListUserVersions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListAttributeDefinitions",
+			Handler:       _DatabaseTest_ListAttributeDefinitions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListWebhooks",
+			Handler:       _DatabaseTest_ListWebhooks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListWebhookDeliveries",
+			Handler:       _DatabaseTest_ListWebhookDeliveries_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListRoleChangeRequests",
+			Handler:       _DatabaseTest_ListRoleChangeRequests_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListInvitations",
+			Handler:       _DatabaseTest_ListInvitations_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListRedemptions",
+			Handler:       _DatabaseTest_ListRedemptions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchUserEvents",
+			Handler:       _DatabaseTest_WatchUserEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListAPITokens",
+			Handler:       _DatabaseTest_ListAPITokens_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListBroadcastRecipients",
+			Handler:       _DatabaseTest_ListBroadcastRecipients_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StartDueBroadcasts",
+			Handler:       _DatabaseTest_StartDueBroadcasts_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ClaimBroadcastRecipients",
+			Handler:       _DatabaseTest_ClaimBroadcastRecipients_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "db.proto",
 }
@@ -0,0 +1,13 @@
+package service
+
+// ActorMetadataKey is a key of gRPC metadata holding ID of the user on whose behalf a call is made.
+// It is recorded in user history.
+const ActorMetadataKey = "actor-id"
+
+// ImpersonatorMetadataKey is a key of gRPC metadata holding ID of the admin who impersonates the actor.
+// It is recorded next to the actor, so changes made while impersonating are attributed to both.
+const ImpersonatorMetadataKey = "impersonator-id"
+
+// ServiceTokenMetadataKey is a key of gRPC metadata holding the token shared by the database service
+// and its trusted clients. Actors and impersonators in metadata are only trusted because the caller knows it.
+const ServiceTokenMetadataKey = "service-token"
@@ -0,0 +1,89 @@
+// Package tracing configures OpenTelemetry tracing shared by the database service,
+// the REST API and the telegram bot
+package tracing
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names tracers of this project
+const InstrumentationName = "github.com/Iamnotagenius/test"
+
+// Config selects where spans are exported, tracing is disabled if neither is set
+type Config struct {
+	// Name of the service in exported spans
+	ServiceName string
+	// Address of OTLP gRPC collector, e.g. localhost:4317
+	OTLPEndpoint string
+	// Connect to collector without TLS
+	OTLPInsecure bool
+	// Print spans to stdout for local debugging
+	Stdout bool
+}
+
+// Flags registers command line flags of tracing config, values are set after flag.Parse
+func Flags(serviceName string) *Config {
+	config := &Config{ServiceName: serviceName}
+	flag.StringVar(&config.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"Address of OTLP gRPC collector to export spans to, e.g. localhost:4317")
+	flag.BoolVar(&config.OTLPInsecure, "otlp-insecure", true, "Connect to OTLP collector without TLS")
+	flag.BoolVar(&config.Stdout, "trace-stdout", false, "Print spans to stdout")
+	return config
+}
+
+// Setup installs global tracer provider and W3C trace context propagation.
+// Returned function flushes remaining spans and must be called before exit.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.OTLPEndpoint == "" && !config.Stdout {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if config.OTLPEndpoint != "" {
+		exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.OTLPEndpoint)}
+		if config.OTLPInsecure {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if config.Stdout {
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns tracer of this project from global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
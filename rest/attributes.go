@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+// attributeDefinition is JSON representation of service.AttributeDefinition
+// with type spelled as a name, e.g. "integer"
+type attributeDefinition struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	ValidationRegex string `json:"validation_regex"`
+	Required        bool   `json:"required"`
+	UserVisible     bool   `json:"user_visible"`
+	Description     string `json:"description"`
+}
+
+func attributeDefinitionFromProto(definition *service.AttributeDefinition) attributeDefinition {
+	return attributeDefinition{
+		Name:            definition.GetName(),
+		Type:            strings.ToLower(strings.TrimPrefix(definition.GetType().String(), "ATTRIBUTE_TYPE_")),
+		ValidationRegex: definition.GetValidationRegex(),
+		Required:        definition.GetRequired(),
+		UserVisible:     definition.GetUserVisible(),
+		Description:     definition.GetDescription(),
+	}
+}
+
+func (handler *handler) getAttributes(ctx *gin.Context) {
+	stream, err := handler.ListAttributeDefinitions(ctx, &service.ListAttributeDefinitionsRequest{})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	definitions := make([]attributeDefinition, 0)
+	for {
+		definition, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		definitions = append(definitions, attributeDefinitionFromProto(definition))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, definitions)
+}
+
+// defineAttribute adds a custom attribute or replaces its definition
+func (handler *handler) defineAttribute(ctx *gin.Context) {
+	var definition attributeDefinition
+	if err := ctx.BindJSON(&definition); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+	definition.Name = ctx.Param("name")
+	if definition.Type == "" {
+		definition.Type = "string"
+	}
+	attributeType, ok := service.AttributeType_value["ATTRIBUTE_TYPE_"+strings.ToUpper(definition.Type)]
+	if !ok {
+		respondWithError(ctx, http.StatusBadRequest, "Unknown attribute type: %v", definition.Type)
+		return
+	}
+
+	_, err := handler.DefineAttribute(ctx, &service.AttributeDefinition{
+		Name:            definition.Name,
+		Type:            service.AttributeType(attributeType),
+		ValidationRegex: definition.ValidationRegex,
+		Required:        definition.Required,
+		UserVisible:     definition.UserVisible,
+		Description:     definition.Description,
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, definition)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthorizeChecksRoles(t *testing.T) {
+	router, handler := newTestRouter(t)
+
+	for _, test := range []struct {
+		name   string
+		userID int64
+		method string
+		target string
+		code   int
+	}{
+		{"read-write admin reads", testAdminID, http.MethodGet, apiPrefix + "/users/2", http.StatusOK},
+		{"read-only admin reads", testOtherID, http.MethodGet, apiPrefix + "/users/2", http.StatusOK},
+		{"read-only admin writes", testOtherID, http.MethodDelete, apiPrefix + "/users/2", http.StatusForbidden},
+		{"user reads", testUserID, http.MethodGet, apiPrefix + "/users/2", http.StatusForbidden},
+		{"user without role reads", testNoRoleID, http.MethodGet, apiPrefix + "/users/2", http.StatusForbidden},
+		{"user without role writes", testNoRoleID, http.MethodDelete, apiPrefix + "/users/2", http.StatusForbidden},
+	} {
+		res := serve(router, login(t, handler, test.userID), test.method, test.target)
+		if res.Code != test.code {
+			t.Errorf("%v: got status %v, want %v", test.name, res.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type broadcastFilter struct {
+	Role     string `json:"role,omitempty"`
+	Group    string `json:"group,omitempty"`
+	HasPhone *bool  `json:"has_phone,omitempty"`
+}
+
+type broadcast struct {
+	ID          int64           `json:"id"`
+	Template    string          `json:"template"`
+	Filter      broadcastFilter `json:"filter"`
+	ScheduledAt time.Time       `json:"scheduled_at"`
+	CreatedBy   int64           `json:"created_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Status      string          `json:"status"`
+	Pending     int32           `json:"pending"`
+	Sent        int32           `json:"sent"`
+	Failed      int32           `json:"failed"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// broadcastDeliveries is a broadcast with delivery status of its recipients
+type broadcastDeliveries struct {
+	broadcast
+	Recipients []broadcastRecipient `json:"recipients"`
+}
+
+type broadcastRecipient struct {
+	UserID    int64      `json:"user_id"`
+	Status    string     `json:"status"`
+	Attempts  int32      `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+func broadcastFromProto(b *service.Broadcast) broadcast {
+	res := broadcast{
+		ID:       b.GetId(),
+		Template: b.GetTemplate(),
+		Filter: broadcastFilter{
+			Group:    b.GetFilter().GetGroup(),
+			HasPhone: b.GetFilter().HasPhone,
+		},
+		ScheduledAt: b.GetScheduledAt().AsTime(),
+		CreatedBy:   b.GetCreatedBy(),
+		CreatedAt:   b.GetCreatedAt().AsTime(),
+		Status:      strings.ToLower(strings.TrimPrefix(b.GetStatus().String(), "BROADCAST_STATUS_")),
+		Pending:     b.GetPending(),
+		Sent:        b.GetSent(),
+		Failed:      b.GetFailed(),
+	}
+	if filtered := b.GetFilter().GetRole(); filtered != service.Role_ROLE_UNSPECIFIED {
+		res.Filter.Role = filtered.String()
+	}
+	if b.FinishedAt != nil {
+		finishedAt := b.GetFinishedAt().AsTime()
+		res.FinishedAt = &finishedAt
+	}
+	return res
+}
+
+func broadcastRecipientFromProto(recipient *service.BroadcastRecipient) broadcastRecipient {
+	res := broadcastRecipient{
+		UserID:    recipient.GetUserId(),
+		Status:    strings.ToLower(strings.TrimPrefix(recipient.GetStatus().String(), "RECIPIENT_STATUS_")),
+		Attempts:  recipient.GetAttempts(),
+		LastError: recipient.GetLastError(),
+	}
+	if recipient.SentAt != nil {
+		sentAt := recipient.GetSentAt().AsTime()
+		res.SentAt = &sentAt
+	}
+	return res
+}
+
+// createBroadcast schedules a message to users with a linked chat. Body fields are template,
+// filter with role, group and has_phone, and scheduled_at, the bot sends it as soon as possible by default.
+func (handler *handler) createBroadcast(ctx *gin.Context) {
+	var req struct {
+		Template    string          `json:"template"`
+		Filter      broadcastFilter `json:"filter"`
+		ScheduledAt *time.Time      `json:"scheduled_at"`
+	}
+	if err := ctx.BindJSON(&req); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	filtered := service.Role_ROLE_UNSPECIFIED
+	if req.Filter.Role != "" {
+		value, ok := parseRole(req.Filter.Role)
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown role: %v", req.Filter.Role)
+			return
+		}
+		filtered = value
+	}
+	created := &service.Broadcast{
+		Template: req.Template,
+		Filter: &service.BroadcastFilter{
+			Role:     filtered,
+			Group:    req.Filter.Group,
+			HasPhone: req.Filter.HasPhone,
+		},
+	}
+	if req.ScheduledAt != nil {
+		created.ScheduledAt = timestamppb.New(*req.ScheduledAt)
+	}
+	res, err := handler.CreateBroadcast(ctx, created)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.Header("Location", apiPrefix+"/broadcasts/"+strconv.FormatInt(res.GetId(), 10))
+	ctx.IndentedJSON(http.StatusCreated, broadcastFromProto(res))
+}
+
+// getBroadcast returns a broadcast with delivery status of every recipient,
+// recipients are optionally filtered by "status" query parameter
+func (handler *handler) getBroadcast(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	req := &service.ListBroadcastRecipientsRequest{BroadcastId: id}
+	if name, ok := ctx.GetQuery("status"); ok {
+		recipientStatus, ok := service.RecipientStatus_value["RECIPIENT_STATUS_"+strings.ToUpper(name)]
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown recipient status: %v", name)
+			return
+		}
+		req.Status = service.RecipientStatus(recipientStatus)
+	}
+
+	b, err := handler.GetBroadcast(ctx, &service.BroadcastByIDRequest{Id: id})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	stream, err := handler.ListBroadcastRecipients(ctx, req)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	res := broadcastDeliveries{broadcast: broadcastFromProto(b), Recipients: make([]broadcastRecipient, 0)}
+	for {
+		recipient, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		res.Recipients = append(res.Recipients, broadcastRecipientFromProto(recipient))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, res)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// consolePageSize is how many users are shown on a page of the console
+const consolePageSize = 25
+
+// flashCookie holds a message shown once on the next page of the console
+const flashCookie = "flash"
+
+//go:embed console/*.html
+var consoleFiles embed.FS
+
+// consoleRoles can be chosen in the role dropdown
+var consoleRoles = []service.Role{
+	service.Role_ROLE_USER,
+	service.Role_ROLE_READ_ONLY_ADMIN,
+	service.Role_ROLE_READ_WRITE_ADMIN,
+}
+
+// roleName is a role as it is shown to admins, e.g. read_only_admin
+func roleName(role service.Role) string {
+	return strings.ToLower(strings.TrimPrefix(role.String(), "ROLE_"))
+}
+
+var consoleFuncs = template.FuncMap{"roleName": roleName, "join": strings.Join}
+
+// consolePages are parsed together with the layout, every page defines "content"
+var consolePages = map[string]*template.Template{
+	"users": template.Must(template.New("layout.html").Funcs(consoleFuncs).
+		ParseFS(consoleFiles, "console/layout.html", "console/users.html")),
+	"user": template.Must(template.New("layout.html").Funcs(consoleFuncs).
+		ParseFS(consoleFiles, "console/layout.html", "console/user.html")),
+	"error": template.Must(template.New("layout.html").Funcs(consoleFuncs).
+		ParseFS(consoleFiles, "console/layout.html", "console/error.html")),
+}
+
+// consoleRoutes are pages for browsers, they are not part of the API document
+var consoleRoutes = []string{"/console", "/console/users", "/console/users/:id"}
+
+// setFlash keeps a message for the page shown after redirect
+func setFlash(ctx *gin.Context, format string, args ...interface{}) {
+	message := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(format, args...)))
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(flashCookie, message, 60, "/console", "", *secureCookies, true)
+}
+
+// takeFlash returns the message set by the previous request and removes it
+func takeFlash(ctx *gin.Context) string {
+	cookie, err := ctx.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	ctx.SetCookie(flashCookie, "", -1, "/console", "", *secureCookies, true)
+	message, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil {
+		return ""
+	}
+	return string(message)
+}
+
+// renderConsole renders a page with data common to every page: the admin, CSRF token and flash message.
+// Forms are disabled for read-only admins and while impersonating, authorize would reject them anyway.
+func (handler *handler) renderConsole(ctx *gin.Context, code int, page string, data gin.H) {
+	current, _ := handler.currentSession(ctx)
+	userID, _ := ctx.Get("user_id")
+	role, _ := ctx.Get("role")
+	data["AdminID"] = userID
+	data["ImpersonatorID"], _ = ctx.Get("impersonator_id")
+	data["ReadOnly"] = role != service.Role_ROLE_READ_WRITE_ADMIN || data["ImpersonatorID"] != nil
+	data["Flash"] = takeFlash(ctx)
+	if current != nil {
+		data["CSRFToken"] = current.CSRFToken
+	}
+
+	ctx.Status(code)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := consolePages[page].Execute(ctx.Writer, data); err != nil {
+		log.Printf("Error when rendering %v page: %v", page, err)
+	}
+	ctx.Abort()
+}
+
+// consoleError shows an error page instead of JSON
+func (handler *handler) consoleError(ctx *gin.Context, code int, format string, args ...interface{}) {
+	handler.renderConsole(ctx, code, "error", gin.H{"Error": fmt.Sprintf(format, args...)})
+}
+
+// checkCSRF rejects forms without the token of the session, the console is available only with sessions
+func (handler *handler) checkCSRF(ctx *gin.Context) {
+	if ctx.Request.Method == "GET" {
+		ctx.Next()
+		return
+	}
+	current, ok := handler.currentSession(ctx)
+	token := ctx.PostForm("csrf_token")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(current.CSRFToken)) != 1 {
+		log.Printf("Invalid CSRF token from IP %v", ctx.ClientIP())
+		handler.consoleError(ctx, http.StatusForbidden, "Form has expired, please reload the page and try again")
+		return
+	}
+	ctx.Next()
+}
+
+// consoleUsers shows a page of users whose names contain "query"
+func (handler *handler) consoleUsers(ctx *gin.Context) {
+	query := ctx.Query("query")
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// Users after the page are not needed, the stream is cancelled once the page is read
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := handler.SearchUsersByName(streamCtx, &service.SearchByNameRequest{Query: query})
+	if err != nil {
+		handler.consoleError(ctx, httpStatus(err), "%v", status.Convert(err).Message())
+		return
+	}
+	users := make([]*service.User, 0, consolePageSize)
+	hasNext := false
+	for skipped := 0; ; {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			handler.consoleError(ctx, httpStatus(err), "%v", status.Convert(err).Message())
+			return
+		}
+		if skipped < (page-1)*consolePageSize {
+			skipped++
+			continue
+		}
+		if len(users) == consolePageSize {
+			hasNext = true
+			break
+		}
+		users = append(users, user)
+	}
+
+	// Zero pages are not linked
+	nextPage := 0
+	if hasNext {
+		nextPage = page + 1
+	}
+	handler.renderConsole(ctx, http.StatusOK, "users", gin.H{
+		"Query":    query,
+		"Users":    users,
+		"Page":     page,
+		"PrevPage": page - 1,
+		"NextPage": nextPage,
+	})
+}
+
+// consoleUserFromParam is getUserFromParam showing error pages
+func (handler *handler) consoleUserFromParam(ctx *gin.Context) (*service.User, bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		handler.consoleError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return nil, false
+	}
+	user, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		handler.consoleError(ctx, http.StatusNotFound, "User with id %v not found", id)
+		return nil, false
+	}
+	if err != nil {
+		handler.consoleError(ctx, httpStatus(err), "%v", status.Convert(err).Message())
+		return nil, false
+	}
+	return user, true
+}
+
+// renderUserForm shows details of a user with an edit form, fields has problems of the submitted form
+func (handler *handler) renderUserForm(ctx *gin.Context, code int, user *service.User, fields map[string]string, formError string) {
+	handler.renderConsole(ctx, code, "user", gin.H{
+		"User":   user,
+		"Roles":  consoleRoles,
+		"Fields": fields,
+		"Error":  formError,
+	})
+}
+
+func (handler *handler) consoleUser(ctx *gin.Context) {
+	user, ok := handler.consoleUserFromParam(ctx)
+	if !ok {
+		return
+	}
+	handler.renderUserForm(ctx, http.StatusOK, user, nil, "")
+}
+
+// consoleSaveUser applies the edit form like PATCH /users/:id and redirects back to the user
+func (handler *handler) consoleSaveUser(ctx *gin.Context) {
+	user, ok := handler.consoleUserFromParam(ctx)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(ctx.PostForm("name"))
+	// Empty phone number clears it
+	phone := strings.TrimSpace(ctx.PostForm("phone_number"))
+	req := patchUserRequest{Name: &name, PhoneNumber: &phone, Groups: make([]string, 0)}
+	fields := make(map[string]string)
+	if value, ok := parseRole(ctx.PostForm("role")); ok {
+		req.Role = (*role)(&value)
+	} else {
+		fields["role"] = "must be chosen"
+	}
+	for _, group := range strings.Split(ctx.PostForm("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			req.Groups = append(req.Groups, group)
+		}
+	}
+	invalid, err := validationFieldErrors(&req)
+	if err != nil {
+		handler.consoleError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+	for field, problem := range invalid {
+		// The form has a single field for groups
+		if strings.HasPrefix(field, "groups[") {
+			field = "groups"
+		}
+		fields[field] = problem
+	}
+	req.apply(user)
+	if len(fields) > 0 {
+		handler.renderUserForm(ctx, http.StatusBadRequest, user, fields, "Form has invalid fields")
+		return
+	}
+
+	request, err := handler.storeUser(ctx, user)
+	if code := status.Code(err); code == codes.InvalidArgument || code == codes.PermissionDenied {
+		handler.renderUserForm(ctx, http.StatusBadRequest, user, nil, status.Convert(err).Message())
+		return
+	}
+	if err != nil {
+		handler.consoleError(ctx, httpStatus(err), "%v", status.Convert(err).Message())
+		return
+	}
+	if request != nil {
+		setFlash(ctx, "User is saved, role change to %v is waiting for approval of another admin",
+			roleName(request.GetRequestedRole()))
+	} else {
+		setFlash(ctx, "User is saved")
+	}
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/console/users/%v", user.GetId()))
+}
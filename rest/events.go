@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// eventsKeepAlive is how often a comment is sent to idle streams, so proxies do not close them
+const eventsKeepAlive = 15 * time.Second
+
+// eventsRetry is the reconnection delay suggested to clients
+const eventsRetry = 3 * time.Second
+
+type userEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Tags      []string        `json:"tags"`
+	UserID    int64           `json:"user_id"`
+	User      json.RawMessage `json:"user,omitempty"`
+	Previous  json.RawMessage `json:"previous,omitempty"`
+	ActorID   *int64          `json:"actor_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	// ImpersonatedBy is the admin who acted on behalf of ActorID
+	ImpersonatedBy *int64 `json:"impersonated_by,omitempty"`
+}
+
+// userEventFromProto encodes users of the event the same way as other responses of the route
+func userEventFromProto(ctx *gin.Context, event *service.UserEvent) (userEvent, error) {
+	tags := event.GetTags()
+	if tags == nil {
+		tags = make([]string, 0)
+	}
+	res := userEvent{
+		ID:             event.GetId(),
+		Type:           event.GetType(),
+		Tags:           tags,
+		UserID:         event.GetUserId(),
+		ActorID:        event.ActorId,
+		CreatedAt:      event.GetCreatedAt().AsTime(),
+		ImpersonatedBy: event.ImpersonatedBy,
+	}
+	var err error
+	if event.User != nil {
+		if res.User, err = userJSON(ctx, event.GetUser()); err != nil {
+			return res, err
+		}
+	}
+	if event.Previous != nil {
+		if res.Previous, err = userJSON(ctx, event.GetPrevious()); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// lastEventID is where a reconnected client stopped, browsers send it in Last-Event-ID header
+// and other clients may use "last_event_id" query parameter. Zero means only new events.
+func lastEventID(ctx *gin.Context) (int64, error) {
+	raw := ctx.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = ctx.Query("last_event_id")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// streamEvents sends user changes as Server-Sent Events until the client disconnects
+// or its credentials are no longer valid, they are checked again with every keep-alive.
+// Events can be filtered by repeated "type" parameter, e.g. type=user.created&type=user.role_changed.
+func (handler *handler) streamEvents(ctx *gin.Context) {
+	afterID, err := lastEventID(ctx)
+	if err != nil || afterID < 0 {
+		respondWithError(ctx, http.StatusBadRequest, "Last event ID has wrong format")
+		return
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := handler.WatchUserEvents(watchCtx, &service.WatchUserEventsRequest{
+		AfterId:    afterID,
+		EventTypes: ctx.QueryArray("type"),
+		OnlyNew:    afterID == 0,
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	// Recv blocks, so events are received separately from keep-alives and disconnects
+	events := make(chan *service.UserEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-watchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	fmt.Fprintf(ctx.Writer, "retry: %d\n\n", eventsRetry.Milliseconds())
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			data, err := userEventFromProto(ctx, event)
+			if err != nil {
+				log.Printf("Failed to encode event %v: %v", event.GetId(), err)
+				continue
+			}
+			ctx.Render(-1, sse.Event{
+				Id:    strconv.FormatInt(event.GetId(), 10),
+				Event: event.GetType(),
+				Data:  data,
+			})
+		case <-keepAlive.C:
+			if !handler.stillAuthorized(ctx) {
+				log.Printf("Closing event stream of user %v, credentials are no longer valid", ctx.GetInt64("user_id"))
+				return
+			}
+			if _, err := io.WriteString(ctx.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case err := <-errs:
+			// The client reconnects after the last received event
+			if err != io.EOF && watchCtx.Err() == nil {
+				log.Printf("Error when streaming events: %v", err)
+			}
+			return
+		case <-watchCtx.Done():
+			return
+		}
+		ctx.Writer.Flush()
+	}
+}
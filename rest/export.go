@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushInterval is how many rows are written before the response is flushed to the client
+const exportFlushInterval = 100
+
+// exportColumn is a column of exported user list
+type exportColumn struct {
+	name  string
+	value func(user *service.User) interface{}
+	// sensitive columns are exported only to read-write admins
+	sensitive bool
+}
+
+var exportColumns = map[string]exportColumn{
+	"id":   {name: "id", value: func(user *service.User) interface{} { return user.GetId() }},
+	"name": {name: "name", value: func(user *service.User) interface{} { return user.GetName() }},
+	"phone_number": {name: "phone_number", sensitive: true, value: func(user *service.User) interface{} {
+		return user.GetPhoneNumber()
+	}},
+	"role": {name: "role", value: func(user *service.User) interface{} {
+		return strings.ToLower(strings.TrimPrefix(user.GetRole().String(), "ROLE_"))
+	}},
+	"groups": {name: "groups", value: func(user *service.User) interface{} {
+		return strings.Join(user.GetGroups(), ", ")
+	}},
+	"telegram_chat_id": {name: "telegram_chat_id", sensitive: true, value: func(user *service.User) interface{} {
+		if user.TelegramChatId == nil {
+			return ""
+		}
+		return user.GetTelegramChatId()
+	}},
+}
+
+// defaultExportColumns are exported when "columns" query parameter is not given,
+// sensitive ones are skipped for read-only admins
+var defaultExportColumns = []string{"id", "name", "phone_number", "role", "groups"}
+
+// parseExportColumns resolves comma separated column names, custom attributes are named attributes.<name>
+func parseExportColumns(ctx *gin.Context) ([]exportColumn, bool) {
+	role, _ := ctx.Get("role")
+	mayExportSensitive := role != service.Role_ROLE_READ_ONLY_ADMIN
+
+	names := defaultExportColumns
+	explicit := false
+	if raw := ctx.Query("columns"); raw != "" {
+		names = strings.Split(raw, ",")
+		explicit = true
+	}
+
+	columns := make([]exportColumn, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		column, ok := exportColumns[name]
+		if attribute, isAttribute := strings.CutPrefix(name, "attributes."); isAttribute && attribute != "" {
+			column = exportColumn{name: name, value: func(user *service.User) interface{} {
+				return user.GetAttributes()[attribute]
+			}}
+			ok = true
+		}
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown column: %v", name)
+			return nil, false
+		}
+		if column.sensitive && !mayExportSensitive {
+			if explicit {
+				respondWithError(ctx, http.StatusForbidden, "Read-only admins cannot export column %v", name)
+				return nil, false
+			}
+			continue
+		}
+		columns = append(columns, column)
+	}
+	return columns, true
+}
+
+// columnNames is the header row of exported columns
+func columnNames(columns []exportColumn) []interface{} {
+	names := make([]interface{}, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+// columnValues is the row of a user
+func columnValues(columns []exportColumn, user *service.User) []interface{} {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column.value(user)
+	}
+	return values
+}
+
+// rowWriter writes exported rows, int64 values are written as numbers
+type rowWriter interface {
+	WriteRow(values []interface{}) error
+	Flush() error
+	Close() error
+}
+
+type csvRowWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvRowWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = fmt.Sprint(value)
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvRowWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvRowWriter) Close() error {
+	return w.Flush()
+}
+
+// xlsxParts are the parts of a workbook with a single sheet, except the sheet itself
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Users" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="1"><fill><patternFill patternType="none"/></fill></fills>
+<borders count="1"><border/></borders>
+<cellStyleXfs count="1"><xf/></cellStyleXfs>
+<cellXfs count="1"><xf/></cellXfs>
+</styleSheet>`},
+}
+
+// xlsxRowWriter writes the sheet row by row into a zip stream, so the workbook is never kept in memory
+type xlsxRowWriter struct {
+	archive *zip.Writer
+	sheet   io.Writer
+	row     int
+}
+
+func newXLSXRowWriter(w io.Writer) (*xlsxRowWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxRowWriter{archive: archive, sheet: sheet}, nil
+}
+
+// columnName converts zero based index to spreadsheet column, e.g. 27 to AB
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+func (w *xlsxRowWriter) WriteRow(values []interface{}) error {
+	w.row++
+	var row strings.Builder
+	fmt.Fprintf(&row, `<row r="%d">`, w.row)
+	for i, value := range values {
+		ref := columnName(i) + strconv.Itoa(w.row)
+		if number, ok := value.(int64); ok {
+			fmt.Fprintf(&row, `<c r="%s"><v>%d</v></c>`, ref, number)
+			continue
+		}
+		fmt.Fprintf(&row, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		xml.EscapeText(&row, []byte(fmt.Sprint(value)))
+		row.WriteString(`</t></is></c>`)
+	}
+	row.WriteString(`</row>`)
+	_, err := io.WriteString(w.sheet, row.String())
+	return err
+}
+
+func (w *xlsxRowWriter) Flush() error {
+	return w.archive.Flush()
+}
+
+func (w *xlsxRowWriter) Close() error {
+	if _, err := io.WriteString(w.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+// exportUsers streams users matching "query" as CSV or XLSX depending on "format" parameter.
+// Columns are chosen with comma separated "columns" parameter.
+func (handler *handler) exportUsers(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		respondWithError(ctx, http.StatusBadRequest, "Unknown format: %v", format)
+		return
+	}
+	columns, ok := parseExportColumns(ctx)
+	if !ok {
+		return
+	}
+	// Exports of many users take longer than the default deadline of calls
+	streamCtx, cancel := context.WithTimeout(ctx, *exportTimeout)
+	defer cancel()
+	stream, err := handler.SearchUsersByName(streamCtx, &service.SearchByNameRequest{Query: ctx.Query("query")})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	// Errors of a stream are only known after the first receive, they can still be reported with a status
+	first, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	var writer rowWriter
+	if format == "csv" {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		writer = &csvRowWriter{writer: csv.NewWriter(ctx.Writer)}
+	} else {
+		ctx.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		writer, err = newXLSXRowWriter(ctx.Writer)
+		if err != nil {
+			log.Printf("Error when starting export: %v", err)
+			return
+		}
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%v"`, format))
+	ctx.Status(http.StatusOK)
+
+	if err := writer.WriteRow(columnNames(columns)); err != nil {
+		log.Printf("Error when exporting users: %v", err)
+		return
+	}
+	for rows, user := 0, first; err != io.EOF; rows++ {
+		if err := writer.WriteRow(columnValues(columns, user)); err != nil {
+			log.Printf("Error when exporting users: %v", err)
+			return
+		}
+		if rows%exportFlushInterval == exportFlushInterval-1 {
+			if err := writer.Flush(); err != nil {
+				log.Printf("Error when exporting users: %v", err)
+				return
+			}
+			ctx.Writer.Flush()
+		}
+
+		user, err = stream.Recv()
+		if err != nil && err != io.EOF {
+			// The status is already sent, a truncated file is the best that can be done
+			log.Printf("Error when exporting users: %v", err)
+			return
+		}
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("Error when exporting users: %v", err)
+	}
+}
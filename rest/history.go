@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type versionInfo struct {
+	Version   int64     `json:"version"`
+	ValidFrom time.Time `json:"valid_from"`
+	ChangedBy *int64    `json:"changed_by,omitempty"`
+	// ImpersonatedBy is the admin who made the change on behalf of ChangedBy
+	ImpersonatedBy *int64 `json:"impersonated_by,omitempty"`
+}
+
+type userDiff struct {
+	UserID  int64         `json:"user_id"`
+	From    versionInfo   `json:"from"`
+	To      versionInfo   `json:"to"`
+	Changes []fieldChange `json:"changes"`
+}
+
+func versionInfoFromProto(version *service.UserVersion) versionInfo {
+	return versionInfo{
+		Version:        version.GetVersion(),
+		ValidFrom:      version.GetValidFrom().AsTime(),
+		ChangedBy:      version.ChangedBy,
+		ImpersonatedBy: version.ImpersonatedBy,
+	}
+}
+
+func phoneOrNil(user *service.User) interface{} {
+	if user.PhoneNumber == nil {
+		return nil
+	}
+	return user.GetPhoneNumber()
+}
+
+// diffUsers lists fields which differ between two versions of a user
+func diffUsers(from, to *service.User) []fieldChange {
+	changes := make([]fieldChange, 0)
+	if from.GetName() != to.GetName() {
+		changes = append(changes, fieldChange{Field: "name", From: from.GetName(), To: to.GetName()})
+	}
+	if (from.PhoneNumber == nil) != (to.PhoneNumber == nil) || from.GetPhoneNumber() != to.GetPhoneNumber() {
+		changes = append(changes, fieldChange{Field: "phone_number", From: phoneOrNil(from), To: phoneOrNil(to)})
+	}
+	if from.GetRole() != to.GetRole() {
+		changes = append(changes, fieldChange{Field: "role", From: from.GetRole().String(), To: to.GetRole().String()})
+	}
+
+	if strings.Join(from.GetGroups(), ",") != strings.Join(to.GetGroups(), ",") {
+		changes = append(changes, fieldChange{Field: "groups", From: from.GetGroups(), To: to.GetGroups()})
+	}
+
+	names := make([]string, 0)
+	for name := range from.GetAttributes() {
+		names = append(names, name)
+	}
+	for name := range to.GetAttributes() {
+		if _, ok := from.GetAttributes()[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		before, hadBefore := from.GetAttributes()[name]
+		after, hasAfter := to.GetAttributes()[name]
+		if hadBefore == hasAfter && before == after {
+			continue
+		}
+		change := fieldChange{Field: "attributes." + name}
+		if hadBefore {
+			change.From = before
+		}
+		if hasAfter {
+			change.To = after
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// getUserDiff compares two versions of a user.
+// Query parameters "from" and "to" are version numbers,
+// by default the latest version is compared with the previous one.
+func (handler *handler) getUserDiff(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+
+	stream, err := handler.ListUserVersions(ctx, &service.UserByIDRequest{Id: id})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	versions := make(map[int64]*service.UserVersion)
+	var latest int64
+	for {
+		version, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if status.Code(err) == codes.NotFound {
+			respondWithError(ctx, http.StatusNotFound, "User with id %v has no history", id)
+			return
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		versions[version.GetVersion()] = version
+		latest = version.GetVersion()
+	}
+
+	to, err := versionParam(ctx, "to", latest)
+	if err != nil {
+		return
+	}
+	from, err := versionParam(ctx, "from", to-1)
+	if err != nil {
+		return
+	}
+	if from < 1 {
+		from = 1
+	}
+
+	fromVersion, ok := versions[from]
+	if !ok {
+		respondWithError(ctx, http.StatusNotFound, "Version %v of user %v not found", from, id)
+		return
+	}
+	toVersion, ok := versions[to]
+	if !ok {
+		respondWithError(ctx, http.StatusNotFound, "Version %v of user %v not found", to, id)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, userDiff{
+		UserID:  id,
+		From:    versionInfoFromProto(fromVersion),
+		To:      versionInfoFromProto(toVersion),
+		Changes: diffUsers(fromVersion.GetUser(), toVersion.GetUser()),
+	})
+}
+
+func versionParam(ctx *gin.Context, name string, def int64) (int64, error) {
+	raw, ok := ctx.GetQuery(name)
+	if !ok {
+		return def, nil
+	}
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Version '%v' has wrong format", name)
+		return 0, err
+	}
+	return version, nil
+}
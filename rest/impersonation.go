@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+// impersonationHeader is the banner of responses to impersonated requests
+const impersonationHeader = "X-Impersonation"
+
+type impersonation struct {
+	UserID         int64     `json:"user_id"`
+	ImpersonatorID int64     `json:"impersonator_id"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// impersonatorRoutes are authorized as the admin of the session even while impersonating,
+// so impersonation can always be switched or ended
+var impersonatorRoutes = map[string]bool{
+	"POST /impersonate/:id": true,
+	"DELETE /impersonate":   true,
+	"POST /logout":          true,
+}
+
+// impersonateRequest makes the request act as the user impersonated by the session.
+// The admin is kept as impersonator_id, so both are logged and recorded in history,
+// and the role of the admin still decides what the request is allowed to do.
+func impersonateRequest(ctx *gin.Context, current *session) int64 {
+	ctx.Set("impersonator_id", current.UserID)
+	ctx.Set("impersonated_id", current.ImpersonatedID)
+	ctx.Header(impersonationHeader, fmt.Sprintf("user=%v; impersonator=%v; expires=%v",
+		current.ImpersonatedID, current.UserID, current.ImpersonationEnds.UTC().Format(time.RFC3339)))
+	return current.ImpersonatedID
+}
+
+// checkImpersonated keeps impersonated requests read-only and away from admins,
+// the user may have become an admin after impersonation started
+func checkImpersonated(ctx *gin.Context, target *service.User) bool {
+	if target.GetRole() >= service.Role_ROLE_READ_ONLY_ADMIN {
+		respondWithError(ctx, http.StatusForbidden, "Admins cannot be impersonated")
+		return false
+	}
+	if ctx.Request.Method != http.MethodGet {
+		respondWithError(ctx, http.StatusForbidden, "Impersonated sessions are read-only")
+		return false
+	}
+	return true
+}
+
+// startImpersonation makes further GET requests of the session act as the user until impersonation-ttl passes.
+// Only read-write admins can impersonate users and admins cannot be impersonated.
+func (handler *handler) startImpersonation(ctx *gin.Context) {
+	if role, _ := ctx.Get("role"); role != service.Role_ROLE_READ_WRITE_ADMIN {
+		respondWithError(ctx, http.StatusForbidden, "Only read-write admins can impersonate users")
+		return
+	}
+	target, err := handler.getUserFromParam(ctx)
+	if err != nil {
+		return
+	}
+	userID, _ := ctx.Get("user_id")
+	adminID := userID.(int64)
+	if target.GetId() == adminID {
+		respondWithError(ctx, http.StatusBadRequest, "Admins cannot impersonate themselves")
+		return
+	}
+	if target.GetDeleted() {
+		respondWithError(ctx, http.StatusConflict, "User with id %v is deleted", target.GetId())
+		return
+	}
+	if target.GetRole() >= service.Role_ROLE_READ_ONLY_ADMIN {
+		respondWithError(ctx, http.StatusForbidden, "Admins cannot be impersonated")
+		return
+	}
+
+	cookie, _ := ctx.Cookie(sessionCookie)
+	expiresAt := time.Now().Add(*impersonationTTL)
+	if !handler.sessions.impersonate(cookie, target.GetId(), expiresAt) {
+		respondWithError(ctx, http.StatusUnauthorized, "Session has ended")
+		return
+	}
+	log.Printf("Admin %v started impersonating user %v until %v", adminID, target.GetId(), expiresAt.Format(time.RFC3339))
+	ctx.IndentedJSON(http.StatusOK, impersonation{
+		UserID:         target.GetId(),
+		ImpersonatorID: adminID,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+// stopImpersonation returns the session to the identity of its admin
+func (handler *handler) stopImpersonation(ctx *gin.Context) {
+	cookie, _ := ctx.Cookie(sessionCookie)
+	targetID := handler.sessions.stopImpersonating(cookie)
+	if targetID == 0 {
+		respondWithError(ctx, http.StatusNotFound, "Session is not impersonating anyone")
+		return
+	}
+	userID, _ := ctx.Get("user_id")
+	log.Printf("Admin %v stopped impersonating user %v", userID, targetID)
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDatabase serves users from memory, calls it does not implement panic
+type fakeDatabase struct {
+	service.DatabaseTestClient
+	users map[int64]*service.User
+}
+
+func (db *fakeDatabase) GetUserByID(ctx context.Context, req *service.UserByIDRequest, opts ...grpc.CallOption) (*service.User, error) {
+	user, ok := db.users[req.GetId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "User with id %v not found", req.GetId())
+	}
+	return user, nil
+}
+
+const (
+	testAdminID  = 1
+	testUserID   = 2
+	testOtherID  = 3
+	testNoRoleID = 4
+)
+
+// newTestRouter returns the API backed by users of fakeDatabase
+func newTestRouter(t *testing.T) (*gin.Engine, *handler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	sessions, err := newSessionStore("test", time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &handler{
+		DatabaseTestClient: &fakeDatabase{users: map[int64]*service.User{
+			testAdminID:  {Id: testAdminID, Name: "Admin", Role: service.Role_ROLE_READ_WRITE_ADMIN},
+			testUserID:   {Id: testUserID, Name: "User", Role: service.Role_ROLE_USER},
+			testOtherID:  {Id: testOtherID, Name: "Other admin", Role: service.Role_ROLE_READ_ONLY_ADMIN},
+			testNoRoleID: {Id: testNoRoleID, Name: "Imported"},
+		}},
+		sessions: sessions,
+	}
+	router := gin.New()
+	handler.registerAPI(router.Group(apiPrefix, handler.authorize))
+	return router, handler
+}
+
+// login starts a session of the user
+func login(t *testing.T, handler *handler, userID int64) *http.Cookie {
+	t.Helper()
+	cookie, err := handler.sessions.create(userID, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: sessionCookie, Value: cookie}
+}
+
+// newImpersonationRouter returns the API with a session of a read-write admin impersonating target
+func newImpersonationRouter(t *testing.T, target int64) (*gin.Engine, *http.Cookie) {
+	t.Helper()
+	router, handler := newTestRouter(t)
+	cookie := login(t, handler, testAdminID)
+	if !handler.sessions.impersonate(cookie.Value, target, time.Now().Add(time.Minute)) {
+		t.Fatal("session was not found")
+	}
+	return router, cookie
+}
+
+func serve(router *gin.Engine, cookie *http.Cookie, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.AddCookie(cookie)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestImpersonatedGetActsAsUser(t *testing.T) {
+	router, cookie := newImpersonationRouter(t, testUserID)
+
+	res := serve(router, cookie, http.MethodGet, apiPrefix+"/users/3")
+	if res.Code != http.StatusOK {
+		t.Fatalf("got status %v: %v", res.Code, res.Body)
+	}
+	if res.Header().Get(impersonationHeader) == "" {
+		t.Errorf("response has no %v header", impersonationHeader)
+	}
+	var user struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &user); err != nil || user.ID != "3" {
+		t.Errorf("got user %v, error %v", res.Body, err)
+	}
+}
+
+func TestImpersonatedWritesAreForbidden(t *testing.T) {
+	router, cookie := newImpersonationRouter(t, testUserID)
+
+	for _, route := range []struct{ method, target string }{
+		{http.MethodPost, apiPrefix + "/users"},
+		{http.MethodPut, apiPrefix + "/users/3"},
+		{http.MethodPatch, apiPrefix + "/users/3"},
+		{http.MethodDelete, apiPrefix + "/users/3"},
+		{http.MethodPost, apiPrefix + "/role-requests/1/approve"},
+	} {
+		res := serve(router, cookie, route.method, route.target)
+		if res.Code != http.StatusForbidden {
+			t.Errorf("%v %v: got status %v, want %v", route.method, route.target, res.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestImpersonatedAdminIsForbidden(t *testing.T) {
+	// The target was impersonated before becoming an admin
+	router, cookie := newImpersonationRouter(t, testOtherID)
+
+	res := serve(router, cookie, http.MethodGet, apiPrefix+"/users/2")
+	if res.Code != http.StatusForbidden {
+		t.Errorf("got status %v, want %v", res.Code, http.StatusForbidden)
+	}
+}
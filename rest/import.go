@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// importUploadLimit limits size of an uploaded file in bytes
+const importUploadLimit = 10 << 20
+
+// importColumns can be used in CSV header besides attributes.<name>
+var importColumns = map[string]bool{"id": true, "name": true, "phone_number": true, "role": true, "groups": true}
+
+// importRow is a user from an uploaded file. Existing users get only the fields present in the row,
+// so exported files can be imported back after editing some columns.
+type importRow struct {
+	ID          int64              `json:"id" binding:"required,gt=0"`
+	Name        *string            `json:"name" binding:"omitempty,min=1,max=200"`
+	PhoneNumber *string            `json:"phone_number" binding:"omitempty,phone|len=0"`
+	Role        *role              `json:"role" binding:"omitempty,role"`
+	Attributes  map[string]*string `json:"attributes" binding:"omitempty,dive,keys,min=1,max=64,endkeys,omitempty,max=1000"`
+	Groups      []string           `json:"groups" binding:"omitempty,dive,min=1,max=64"`
+}
+
+// parsedRow is an importRow with its position in the file
+type parsedRow struct {
+	number int
+	row    importRow
+	// fields has problems found while parsing, such rows are reported as failed
+	fields map[string]string
+}
+
+func (r *importRow) patch() *patchUserRequest {
+	return &patchUserRequest{
+		Name:        r.Name,
+		PhoneNumber: r.PhoneNumber,
+		Role:        r.Role,
+		Attributes:  r.Attributes,
+		Groups:      r.Groups,
+	}
+}
+
+// parseCSV reads users from CSV with a header, empty cells are left unchanged.
+// Groups are separated by commas like in export.
+func parseCSV(r io.Reader) ([]parsedRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	hasID := false
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		hasID = hasID || header[i] == "id"
+		if !importColumns[header[i]] && !strings.HasPrefix(header[i], "attributes.") {
+			return nil, errors.New("column " + header[i] + " cannot be imported")
+		}
+	}
+	if !hasID {
+		return nil, errors.New("id column is required")
+	}
+
+	rows := make([]parsedRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		parsed := parsedRow{number: line}
+		fail := func(field, problem string) {
+			if parsed.fields == nil {
+				parsed.fields = make(map[string]string)
+			}
+			parsed.fields[field] = problem
+		}
+		if len(record) != len(header) {
+			fail("row", "has "+strconv.Itoa(len(record))+" cells instead of "+strconv.Itoa(len(header)))
+			rows = append(rows, parsed)
+			continue
+		}
+
+		for i, column := range header {
+			cell := strings.TrimSpace(record[i])
+			if cell == "" {
+				continue
+			}
+			switch column {
+			case "id":
+				id, err := strconv.ParseInt(cell, 10, 64)
+				if err != nil {
+					fail(column, "must be an integer")
+				}
+				parsed.row.ID = id
+			case "name":
+				parsed.row.Name = &cell
+			case "phone_number":
+				parsed.row.PhoneNumber = &cell
+			case "role":
+				value, ok := parseRole(cell)
+				if !ok {
+					fail(column, "unknown role "+cell)
+				}
+				parsed.row.Role = (*role)(&value)
+			case "groups":
+				parsed.row.Groups = make([]string, 0)
+				for _, group := range strings.Split(cell, ",") {
+					if group = strings.TrimSpace(group); group != "" {
+						parsed.row.Groups = append(parsed.row.Groups, group)
+					}
+				}
+			default:
+				if parsed.row.Attributes == nil {
+					parsed.row.Attributes = make(map[string]*string)
+				}
+				parsed.row.Attributes[strings.TrimPrefix(column, "attributes.")] = &cell
+			}
+		}
+		rows = append(rows, parsed)
+	}
+	return rows, nil
+}
+
+// parseJSON reads users from a JSON array, every item is decoded separately so bad items fail alone
+func parseJSON(r io.Reader) ([]parsedRow, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+	rows := make([]parsedRow, 0, len(items))
+	for i, item := range items {
+		parsed := parsedRow{number: i + 1}
+		decoder := json.NewDecoder(strings.NewReader(string(item)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&parsed.row); err != nil {
+			parsed.fields = decodeFieldErrors(err)
+			if parsed.fields == nil {
+				parsed.fields = map[string]string{"row": err.Error()}
+			}
+		}
+		rows = append(rows, parsed)
+	}
+	return rows, nil
+}
+
+// parseUpload reads rows from "file" of a multipart form or from the body itself,
+// format is chosen by file extension or content type
+func parseUpload(ctx *gin.Context) ([]parsedRow, error) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, importUploadLimit)
+	var body io.Reader = ctx.Request.Body
+	contentType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if contentType == "multipart/form-data" {
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+		contentType = mime.TypeByExtension(path.Ext(header.Filename))
+		contentType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	switch contentType {
+	case "text/csv":
+		return parseCSV(body)
+	case "application/json":
+		return parseJSON(body)
+	}
+	return nil, errors.New("upload must be CSV or JSON")
+}
+
+// importUser validates a row and creates or updates the user, nothing is written in dry run.
+// Escalations are saved as role change requests like in PATCH /users/:id.
+func (handler *handler) importUser(ctx context.Context, dryRun bool, actor int64, parsed parsedRow) rowResult {
+	result := rowResult{Row: parsed.number, UserID: parsed.row.ID, Result: "failed"}
+	if parsed.fields != nil {
+		result.Fields = parsed.fields
+		return result
+	}
+	fields, err := validationFieldErrors(&parsed.row)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if fields != nil {
+		result.Fields = fields
+		return result
+	}
+
+	user, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: parsed.row.ID})
+	switch status.Code(err) {
+	case codes.OK:
+		result.Result = "updated"
+	case codes.NotFound:
+		if parsed.row.Name == nil {
+			result.Fields = map[string]string{"name": "is required for new users"}
+			return result
+		}
+		user = &service.User{Id: parsed.row.ID, Role: service.Role_ROLE_USER}
+		result.Result = "created"
+	default:
+		result.Error = err.Error()
+		return result
+	}
+
+	oldRole := user.GetRole()
+	parsed.row.patch().apply(user)
+	if oldRole != user.GetRole() && user.GetId() == actor {
+		result.Result = "failed"
+		result.Fields = map[string]string{"role": "admins cannot change their role"}
+		return result
+	}
+	if dryRun {
+		return result
+	}
+
+	request, err := handler.storeUser(ctx, user)
+	if err != nil {
+		result.Result = "failed"
+		result.Error = status.Convert(err).Message()
+		return result
+	}
+	if request != nil {
+		id := request.GetId()
+		result.RoleChangeRequestID = &id
+	}
+	return result
+}
+
+// runImport processes rows one by one, the job outlives the request which started it
+func (handler *handler) runImport(ctx context.Context, job *importJob, actor int64, rows []parsedRow) {
+	ctx, span := tracing.Tracer().Start(ctx, "user import",
+		trace.WithAttributes(
+			attribute.String("import.job_id", job.ID),
+			attribute.Int("import.rows", len(rows)),
+			attribute.Bool("import.dry_run", job.DryRun)))
+	defer span.End()
+
+	for _, row := range rows {
+		handler.jobs.record(job, handler.importUser(ctx, job.DryRun, actor, row))
+	}
+	handler.jobs.finish(job)
+	log.Printf("Import job %v of user %v finished", job.ID, actor)
+}
+
+// importUsers starts a background import of users from CSV or JSON upload.
+// With dry_run=true rows are only validated and the report tells what would be done.
+func (handler *handler) importUsers(ctx *gin.Context) {
+	dryRun, _ := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	rows, err := parseUpload(ctx)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Upload cannot be read: %v", err)
+		return
+	}
+	if len(rows) == 0 {
+		respondWithError(ctx, http.StatusBadRequest, "Upload has no rows")
+		return
+	}
+
+	userID, _ := ctx.Get("user_id")
+	actor := userID.(int64)
+	job, err := handler.jobs.start(actor, len(rows), dryRun)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to start job: %v", err)
+		return
+	}
+	// Request context is cancelled when the response is sent, the job only keeps its trace and actor
+	jobCtx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	jobCtx = metadata.AppendToOutgoingContext(jobCtx, actorMetadata(ctx)...)
+	go handler.runImport(jobCtx, job, actor, rows)
+
+	snapshot, _ := handler.jobs.get(job.ID)
+	ctx.Header("Location", apiPrefix+"/jobs/"+job.ID)
+	ctx.IndentedJSON(http.StatusAccepted, snapshot)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func rolePtr(r service.Role) *role {
+	value := role(r)
+	return &value
+}
+
+func TestParseCSV(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		input  string
+		rows   []parsedRow
+		failed bool
+	}{
+		{
+			name:  "every column",
+			input: "id,name,phone_number,role,groups,attributes.team\n7, Ann ,+79990000000,read_only_admin,\"staff, ops\",blue\n",
+			rows: []parsedRow{{number: 2, row: importRow{
+				ID:          7,
+				Name:        stringPtr("Ann"),
+				PhoneNumber: stringPtr("+79990000000"),
+				Role:        rolePtr(service.Role_ROLE_READ_ONLY_ADMIN),
+				Groups:      []string{"staff", "ops"},
+				Attributes:  map[string]*string{"team": stringPtr("blue")},
+			}}},
+		},
+		{
+			name:  "empty cells are left unchanged",
+			input: "id,name,groups\n7,,\n",
+			rows:  []parsedRow{{number: 2, row: importRow{ID: 7}}},
+		},
+		{
+			name:  "bad cells fail their row",
+			input: "id,role\nseven,chief\n8\n9,user\n",
+			rows: []parsedRow{
+				{number: 2, row: importRow{Role: rolePtr(0)}, fields: map[string]string{"id": "must be an integer", "role": "unknown role chief"}},
+				{number: 3, fields: map[string]string{"row": "has 1 cells instead of 2"}},
+				{number: 4, row: importRow{ID: 9, Role: rolePtr(service.Role_ROLE_USER)}},
+			},
+		},
+		{name: "unknown column", input: "id,deleted\n7,true\n", failed: true},
+		{name: "no id column", input: "name\nAnn\n", failed: true},
+		{name: "no header", input: "", failed: true},
+	} {
+		rows, err := parseCSV(strings.NewReader(test.input))
+		if (err != nil) != test.failed {
+			t.Errorf("%v: got error %v", test.name, err)
+			continue
+		}
+		if !test.failed && !reflect.DeepEqual(rows, test.rows) {
+			t.Errorf("%v: got rows %+v, want %+v", test.name, rows, test.rows)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	rows, err := parseJSON(strings.NewReader(`[
+		{"id": 7, "name": "Ann", "role": "user", "groups": ["staff"]},
+		{"id": "seven"},
+		{"id": 9, "deleted": true}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []parsedRow{
+		{number: 1, row: importRow{ID: 7, Name: stringPtr("Ann"), Role: rolePtr(service.Role_ROLE_USER), Groups: []string{"staff"}}},
+		{number: 2, fields: map[string]string{"id": "must be int64"}},
+		{number: 3, row: importRow{ID: 9}, fields: map[string]string{"deleted": "cannot be set"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %+v, want %+v", rows, want)
+	}
+
+	if _, err := parseJSON(strings.NewReader(`{"id": 7}`)); err == nil {
+		t.Error("object was parsed as an array")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultInvitationLifetime is used when expiration is not given
+const defaultInvitationLifetime = 7 * 24 * time.Hour
+
+type invitation struct {
+	Code      string    `json:"code"`
+	Role      string    `json:"role"`
+	Groups    []string  `json:"groups"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxUses   int32     `json:"max_uses"`
+	Uses      int32     `json:"uses"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+type redemption struct {
+	UserID     int64     `json:"user_id"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+func invitationFromProto(inv *service.Invitation) invitation {
+	groups := inv.GetGroups()
+	if groups == nil {
+		groups = make([]string, 0)
+	}
+	return invitation{
+		Code:      inv.GetCode(),
+		Role:      inv.GetRole().String(),
+		Groups:    groups,
+		ExpiresAt: inv.GetExpiresAt().AsTime(),
+		MaxUses:   inv.GetMaxUses(),
+		Uses:      inv.GetUses(),
+		CreatedBy: inv.GetCreatedBy(),
+		CreatedAt: inv.GetCreatedAt().AsTime(),
+		Revoked:   inv.GetRevoked(),
+	}
+}
+
+func (handler *handler) getInvitations(ctx *gin.Context) {
+	stream, err := handler.ListInvitations(ctx, &service.ListInvitationsRequest{})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	invitations := make([]invitation, 0)
+	for {
+		inv, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		invitations = append(invitations, invitationFromProto(inv))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, invitations)
+}
+
+// createInvitation creates an invitation code. Body fields are role, groups, max_uses
+// and either expires_at or expires_in as Go duration, e.g. "72h".
+func (handler *handler) createInvitation(ctx *gin.Context) {
+	var req struct {
+		Role      string     `json:"role"`
+		Groups    []string   `json:"groups"`
+		MaxUses   int32      `json:"max_uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		ExpiresIn string     `json:"expires_in"`
+	}
+	if err := ctx.BindJSON(&req); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	granted := service.Role_ROLE_UNSPECIFIED
+	if req.Role != "" {
+		value, ok := parseRole(req.Role)
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown role: %v", req.Role)
+			return
+		}
+		granted = value
+	}
+	expiresAt := time.Now().Add(defaultInvitationLifetime)
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.ExpiresIn != "":
+		lifetime, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Wrong expires_in: %v", err)
+			return
+		}
+		expiresAt = time.Now().Add(lifetime)
+	}
+
+	inv, err := handler.CreateInvitation(ctx, &service.Invitation{
+		Role:      granted,
+		Groups:    req.Groups,
+		ExpiresAt: timestamppb.New(expiresAt),
+		MaxUses:   req.MaxUses,
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusCreated, invitationFromProto(inv))
+}
+
+func (handler *handler) revokeInvitation(ctx *gin.Context) {
+	_, err := handler.RevokeInvitation(ctx, &service.InvitationByCodeRequest{Code: ctx.Param("code")})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (handler *handler) getRedemptions(ctx *gin.Context) {
+	stream, err := handler.ListRedemptions(ctx, &service.InvitationByCodeRequest{Code: ctx.Param("code")})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	redemptions := make([]redemption, 0)
+	for {
+		use, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		redemptions = append(redemptions, redemption{UserID: use.GetUserId(), RedeemedAt: use.GetRedeemedAt().AsTime()})
+	}
+
+	ctx.IndentedJSON(http.StatusOK, redemptions)
+}
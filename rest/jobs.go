@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobRetention is how long finished jobs can be looked up
+const jobRetention = 24 * time.Hour
+
+// rowResult is the outcome of one imported row
+type rowResult struct {
+	// Row is the line of a CSV file or index of a JSON array item, starting from 1
+	Row    int               `json:"row"`
+	UserID int64             `json:"user_id,omitempty"`
+	Result string            `json:"result"`
+	Error  string            `json:"error,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+	// RoleChangeRequestID is set when the row escalated a role, which waits for approval
+	RoleChangeRequestID *int64 `json:"role_change_request_id,omitempty"`
+}
+
+// importJob is a user import running in background
+type importJob struct {
+	ID         string      `json:"id"`
+	Status     string      `json:"status"`
+	DryRun     bool        `json:"dry_run"`
+	CreatedBy  int64       `json:"created_by"`
+	Total      int         `json:"total"`
+	Processed  int         `json:"processed"`
+	Created    int         `json:"created"`
+	Updated    int         `json:"updated"`
+	Failed     int         `json:"failed"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Rows       []rowResult `json:"rows"`
+}
+
+// jobStore keeps jobs in memory, so they are lost on restart
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*importJob
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*importJob)}
+}
+
+// start registers a running job of total rows
+func (store *jobStore) start(createdBy int64, total int, dryRun bool) (*importJob, error) {
+	id, err := randomToken(9)
+	if err != nil {
+		return nil, err
+	}
+	job := &importJob{
+		ID:        id,
+		Status:    "running",
+		DryRun:    dryRun,
+		CreatedBy: createdBy,
+		Total:     total,
+		StartedAt: time.Now(),
+		Rows:      make([]rowResult, 0, total),
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for id, finished := range store.jobs {
+		if finished.FinishedAt != nil && time.Since(*finished.FinishedAt) > jobRetention {
+			delete(store.jobs, id)
+		}
+	}
+	store.jobs[job.ID] = job
+	return job, nil
+}
+
+// record adds a processed row to the report of a job
+func (store *jobStore) record(job *importJob, result rowResult) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job.Processed++
+	switch result.Result {
+	case "created":
+		job.Created++
+	case "updated":
+		job.Updated++
+	case "failed":
+		job.Failed++
+	}
+	job.Rows = append(job.Rows, result)
+}
+
+func (store *jobStore) finish(job *importJob) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	job.Status = "finished"
+	job.FinishedAt = &now
+}
+
+// get returns a copy of a job which is safe to read while the job is running
+func (store *jobStore) get(id string) (importJob, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, ok := store.jobs[id]
+	if !ok {
+		return importJob{}, false
+	}
+	copied := *job
+	copied.Rows = append([]rowResult{}, job.Rows...)
+	return copied, true
+}
+
+// getJob shows progress of a job and its report once it is finished, only to the admin who started it
+func (handler *handler) getJob(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	job, ok := handler.jobs.get(ctx.Param("id"))
+	if !ok || job.CreatedBy != userID.(int64) {
+		respondWithError(ctx, http.StatusNotFound, "Job %v not found", ctx.Param("id"))
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, job)
+}
@@ -3,23 +3,30 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Iamnotagenius/test/db/client"
+	"github.com/Iamnotagenius/test/db/oidcflow"
 	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
 	"github.com/coreos/go-oidc"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -27,104 +34,287 @@ type handler struct {
 	service.DatabaseTestClient
 	authChan chan int64
 	provider *oidc.Provider
-	sessions map[string]int64
-	state    string
+	sessions *sessionStore
+	logins   *oidcflow.Store
+	jobs     *jobStore
 }
 
+// apiPrefix is where the current version of the API is mounted, routes without it are deprecated aliases
+const apiPrefix = "/api/v1"
+
+// loginAttemptTTL limits how long the login page of identity provider can stay open
+const loginAttemptTTL = 10 * time.Minute
+
 var (
 	grpcDbServiceAddress = flag.String("db-service-addr", "localhost:50051", "Address of grpc DB service")
+	dbCallTimeout        = flag.Duration("db-call-timeout", 5*time.Second, "Default deadline of calls to DB service")
+	exportTimeout        = flag.Duration("export-timeout", 10*time.Minute, "Deadline of reading users for an export")
+	tracingConfig        = tracing.Flags("rest-api")
 
-	oauth2Config = oauth2.Config{
-		ClientID:     os.Getenv("ITMOID_CLIENT_ID"),
-		ClientSecret: os.Getenv("ITMOID_CLIENT_SECRET"),
-		Scopes:       []string{oidc.ScopeOpenID},
-		RedirectURL:  "http://localhost:8080/",
-	}
+	sessionIdleTimeout     = flag.Duration("session-idle-timeout", 30*time.Minute, "Sessions expire after this much time without requests")
+	impersonationTTL       = flag.Duration("impersonation-ttl", 15*time.Minute, "Impersonation of a user ends after this much time")
+	sessionAbsoluteTimeout = flag.Duration("session-absolute-timeout", 12*time.Hour, "Sessions expire after this much time since login")
+	secureCookies          = flag.Bool("secure-cookies", false, "Send session cookie only over HTTPS")
+
+	apiRateLimit    = flag.Float64("rate-limit", 10, "Requests per second allowed to a session or token, 0 disables limiting")
+	apiRateBurst    = flag.Int("rate-burst", 30, "Requests a client can make at once before rate-limit applies")
+	ipRateLimit     = flag.Float64("ip-rate-limit", 30, "Requests per second allowed to an IP before its credentials are checked")
+	ipRateBurst     = flag.Int("ip-rate-burst", 90, "Requests an IP can make at once before ip-rate-limit applies")
+	searchRateLimit = flag.Float64("search-rate-limit", 1, "Searches and exports per second allowed to a session or token, 0 disables the stricter limit")
+	searchRateBurst = flag.Int("search-rate-burst", 5, "Searches and exports a client can make at once")
+	authRateLimit   = flag.Float64("auth-rate-limit", 0.1, "Login callbacks per second allowed to an IP, 0 disables the stricter limit")
+	authRateBurst   = flag.Int("auth-rate-burst", 10, "Login callbacks an IP can make at once")
+	rateLimitRedis  = flag.String("rate-limit-redis", "", "URL of Redis compatible server keeping rate limits shared by replicas, e.g. redis://localhost:6379/0. Limits are kept in memory if it is empty")
+
+	oidcConfig = oidcflow.Flags("http://localhost:8080/")
+
+	oauth2Config oauth2.Config
 )
 
 func (handler *handler) authenticate(ctx *gin.Context) {
 	code, ok := ctx.GetQuery("code")
 	if !ok {
+		if reason, failed := ctx.GetQuery("error"); failed {
+			respondWithError(ctx, http.StatusBadRequest, "Login failed: %v %v", reason, ctx.Query("error_description"))
+			return
+		}
+		respondWithError(ctx, http.StatusBadRequest, "Authorization code is missing")
 		return
 	}
 
-	token, err := oauth2Config.Exchange(ctx, code)
+	if !handler.checkLoginState(ctx, ctx.Query("state")) {
+		log.Println("Login state does not match the cookie. CSRF attack?")
+		respondWithError(ctx, http.StatusBadRequest, "Login was not started in this browser, please try again")
+		return
+	}
+	attempt, ok := handler.logins.Take(ctx.Query("state"))
+	if !ok {
+		log.Println("Unknown or expired login state. CSRF attack?")
+		respondWithError(ctx, http.StatusBadRequest, "Login attempt is unknown or has expired, please try again")
+		return
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, attempt.ExchangeOptions()...)
 	if err != nil {
 		log.Printf("Exchange failed: %v", err)
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			respondWithError(ctx, http.StatusBadRequest, "Authorization code was not accepted, please try again")
+			return
+		}
+		respondWithError(ctx, http.StatusBadGateway, "Identity provider is unavailable")
 		return
 	}
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
-		log.Panicln("Missing token")
-		return
-	}
-	state, _ := ctx.GetQuery("state")
-	if handler.state != state {
-		log.Println("States did not match. CSRF attack?")
+		log.Println("Missing token")
+		respondWithError(ctx, http.StatusBadGateway, "Identity provider did not return ID token")
 		return
 	}
 
 	idToken, err := handler.provider.Verifier(
-		&oidc.Config{ClientID: os.Getenv("ITMOID_CLIENT_ID")}).Verify(ctx, rawIDToken)
+		&oidc.Config{ClientID: oidcConfig.ClientID}).Verify(ctx, rawIDToken)
 	if err != nil {
 		log.Printf("Token parse failed: %v", err)
+		respondWithError(ctx, http.StatusUnauthorized, "ID token is invalid")
 		return
 	}
-
-	var claims struct {
-		Sub string `json:"sub"`
-		Isu int64  `json:"isu"`
+	if err := attempt.VerifyNonce(idToken.Nonce); err != nil {
+		log.Printf("Token verification failed: %v", err)
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
 	}
+
+	var claims map[string]json.RawMessage
 	if err := idToken.Claims(&claims); err != nil {
 		log.Printf("Token unmarshal failed: %v", err)
+		respondWithError(ctx, http.StatusBadGateway, "Claims of ID token cannot be read")
 		return
 	}
+	isu, err := oidcConfig.ISU(claims)
+	if err != nil {
+		log.Printf("Token unmarshal failed: %v", err)
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var name string
+	json.Unmarshal(claims["name"], &name)
 
-	handler.sessions[ctx.RemoteIP()] = claims.Isu
-	log.Printf("Added session for IP %v with ISU %v", ctx.RemoteIP(), claims.Isu)
-	ctx.IndentedJSON(http.StatusOK, gin.H{"status": fmt.Sprintf("Successfully authenticated: %v", claims.Isu)})
-}
+	// A rejected invitation fails the login, so the browser is not left with a session
+	text := fmt.Sprintf("Successfully authenticated: %v", isu)
+	if invite := attempt.Data; invite != "" {
+		res, err := handler.RedeemInvitation(ctx, &service.RedeemInvitationRequest{
+			Code:   invite,
+			UserId: isu,
+			Name:   name,
+		})
+		if err != nil {
+			respondWithError(ctx, httpStatus(err), "Invitation was not accepted: %v", status.Convert(err).Message())
+			return
+		}
+		text += fmt.Sprintf(", invitation accepted, role: %v", res.GetUser().GetRole())
+		if request := res.GetRoleChangeRequest(); request != nil {
+			text += fmt.Sprintf(", role %v waits for approval", request.GetRequestedRole())
+		}
+	}
 
-func generateState() string {
-	buffer := make([]byte, 25)
-	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
-		return ""
+	if err := handler.startSession(ctx, isu); err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to start session: %v", err)
+		return
 	}
-	return base64.RawURLEncoding.EncodeToString(buffer)
+	log.Printf("Added session for IP %v with ISU %v", ctx.ClientIP(), isu)
+	ctx.IndentedJSON(http.StatusOK, gin.H{"status": text})
 }
 
-func (handler *handler) authorize(ctx *gin.Context) {
-	isu, ok := handler.sessions[ctx.RemoteIP()]
-	if !ok {
-		log.Println("Redirect")
-		ctx.Redirect(http.StatusFound, oauth2Config.AuthCodeURL(handler.state))
+// redirectToLogin starts a login attempt bound to this browser by a cookie, invitation code
+// from "invite" query parameter is kept with the attempt until the callback
+func (handler *handler) redirectToLogin(ctx *gin.Context) {
+	attempt, err := handler.logins.Begin(ctx.Query("invite"))
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to start login: %v", err)
 		return
 	}
+	handler.setLoginStateCookie(ctx, attempt.State)
+	ctx.Redirect(http.StatusFound, attempt.AuthCodeURL(&oauth2Config, attempt.State))
+	ctx.Abort()
+}
+
+// apiRoute names the route of the request as "METHOD /fullpath", aliases are named the same as the routes of /api/v1
+func apiRoute(ctx *gin.Context) string {
+	return ctx.Request.Method + " " + strings.TrimPrefix(ctx.FullPath(), apiPrefix)
+}
+
+// deprecateAlias marks requests to routes outside of /api/v1, they respond the way they did before versioning
+func deprecateAlias(ctx *gin.Context) {
+	ctx.Set("legacy", true)
+	ctx.Header("Deprecation", "true")
+	ctx.Header("Link", fmt.Sprintf(`<%v%v>; rel="successor-version"`, apiPrefix, ctx.Request.URL.Path))
+	ctx.Next()
+}
+
+// selfServiceRoutes are allowed to every admin because they only affect the admin's own sessions and tokens
+var selfServiceRoutes = map[string]bool{
+	"POST /logout":         true,
+	"DELETE /sessions/:id": true,
+	"POST /tokens":         true,
+	"DELETE /tokens/:id":   true,
+	"DELETE /impersonate":  true,
+}
 
-	user, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: isu})
+// sessionOnlyRoutes cannot be used with API tokens, so a leaked token cannot be used to create more tokens
+var sessionOnlyRoutes = map[string]bool{
+	"POST /logout":         true,
+	"GET /sessions":        true,
+	"DELETE /sessions/:id": true,
+	"GET /tokens":          true,
+	"POST /tokens":         true,
+	"DELETE /tokens/:id":   true,
+	// Impersonation is a state of the session
+	"POST /impersonate/:id": true,
+	"DELETE /impersonate":   true,
+	// Forms of the console are protected from CSRF with a token of the session
+	"GET /console":            true,
+	"GET /console/users":      true,
+	"GET /console/users/:id":  true,
+	"POST /console/users/:id": true,
+}
+
+// authenticateToken authorizes the request with a personal access token from Authorization header
+func (handler *handler) authenticateToken(ctx *gin.Context, secret string) (int64, bool) {
+	token, err := handler.AuthenticateAPIToken(ctx, &service.AuthenticateAPITokenRequest{Secret: secret})
+	if status.Code(err) == codes.Unauthenticated {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return 0, false
+	}
+	if sessionOnlyRoutes[apiRoute(ctx)] {
+		respondWithError(ctx, http.StatusForbidden, "API tokens cannot be used for %v", ctx.FullPath())
+		return 0, false
+	}
+	ctx.Set("token_id", token.GetId())
+	ctx.Set("token_scopes", token.GetScopes())
+	return token.GetUserId(), true
+}
+
+// authorizedUser looks up the user whose credentials authorize the request
+func (handler *handler) authorizedUser(ctx *gin.Context, id int64) (*service.User, bool) {
+	user, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: id})
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			respondWithError(ctx, http.StatusForbidden, "User not found in database")
+			return nil, false
+		}
+		respondWithStatus(ctx, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (handler *handler) authorize(ctx *gin.Context) {
+	var isu int64
+	if secret, ok := bearerToken(ctx); ok {
+		isu, ok = handler.authenticateToken(ctx, secret)
+		if !ok {
+			return
+		}
+	} else {
+		current, ok := handler.currentSession(ctx)
+		if !ok {
+			log.Println("Redirect")
+			handler.redirectToLogin(ctx)
+			return
+		}
+		isu = current.UserID
+		ctx.Set("session_id", current.ID)
+		if current.ImpersonatedID != 0 && !impersonatorRoutes[apiRoute(ctx)] {
+			isu = impersonateRequest(ctx, current)
+		}
+	}
+
+	user, ok := handler.authorizedUser(ctx, isu)
+	if !ok {
+		return
+	}
+	if impersonatorID, impersonating := ctx.Get("impersonator_id"); impersonating {
+		if !checkImpersonated(ctx, user) {
+			return
+		}
+		// Permissions are those of the admin, the impersonated user only scopes the data
+		if user, ok = handler.authorizedUser(ctx, impersonatorID.(int64)); !ok {
 			return
 		}
 	}
 
 	switch user.GetRole() {
-	case service.Role_ROLE_UNSPECIFIED:
-	case service.Role_ROLE_USER:
+	case service.Role_ROLE_UNSPECIFIED, service.Role_ROLE_USER:
 		respondWithError(ctx, http.StatusForbidden, "User does not have permssion to use this API")
 		return
 	}
 
 	ctx.Set("user_id", isu)
+	ctx.Set("role", user.GetRole())
 
+	if selfServiceRoutes[apiRoute(ctx)] {
+		ctx.Next()
+		return
+	}
 	switch ctx.Request.Method {
 	case "GET":
+		if !hasScope(ctx, service.TokenScope_TOKEN_SCOPE_READ_USERS) {
+			respondWithError(ctx, http.StatusForbidden, "Token does not have read_users scope")
+			return
+		}
 		ctx.Next()
 		return
-	case "POST":
+	case "POST", "PUT", "PATCH", "DELETE":
 		if user.GetRole() == service.Role_ROLE_READ_ONLY_ADMIN {
-			respondWithError(ctx, http.StatusForbidden, "Read-only admins cannot do POST requests")
+			respondWithError(ctx, http.StatusForbidden, "Read-only admins cannot do %v requests", ctx.Request.Method)
+			return
+		}
+		if !hasScope(ctx, service.TokenScope_TOKEN_SCOPE_WRITE_USERS) {
+			respondWithError(ctx, http.StatusForbidden, "Token does not have write_users scope")
 			return
 		}
 		ctx.Next()
@@ -134,11 +324,56 @@ func (handler *handler) authorize(ctx *gin.Context) {
 	respondWithError(ctx, http.StatusForbidden, "Unsupported method: %v", ctx.Request.Method)
 }
 
+// stillAuthorized checks credentials of a long-running request again without responding, so a revoked token,
+// an ended session or impersonation and a lost admin role stop it. Checks do not extend idle sessions.
+func (handler *handler) stillAuthorized(ctx *gin.Context) bool {
+	// isu is the user the request acts as, admin is the one whose role authorizes it
+	var isu, admin int64
+	if secret, ok := bearerToken(ctx); ok {
+		token, err := handler.AuthenticateAPIToken(ctx, &service.AuthenticateAPITokenRequest{Secret: secret})
+		if err != nil {
+			return false
+		}
+		isu = token.GetUserId()
+		admin = isu
+	} else {
+		cookie, err := ctx.Cookie(sessionCookie)
+		if err != nil {
+			return false
+		}
+		current, ok := handler.sessions.peek(cookie)
+		if !ok {
+			return false
+		}
+		isu = current.UserID
+		admin = isu
+		if current.ImpersonatedID != 0 && time.Now().Before(current.ImpersonationEnds) {
+			isu = current.ImpersonatedID
+		}
+	}
+	if userID, _ := ctx.Get("user_id"); userID != isu {
+		return false
+	}
+
+	if isu != admin {
+		target, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: isu})
+		if err != nil || target.GetRole() >= service.Role_ROLE_READ_ONLY_ADMIN {
+			return false
+		}
+	}
+	user, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: admin})
+	if err != nil {
+		return false
+	}
+	role, _ := ctx.Get("role")
+	return user.GetRole() == role
+}
+
 func (handler *handler) getUsers(ctx *gin.Context) {
 	q, _ := ctx.GetQuery("query")
 	stream, err := handler.SearchUsersByName(ctx, &service.SearchByNameRequest{Query: q})
 	if err != nil {
-		respondWithError(ctx, http.StatusInternalServerError, err.Error())
+		respondWithStatus(ctx, err)
 		return
 	}
 	users := make([]*service.User, 0)
@@ -148,14 +383,13 @@ func (handler *handler) getUsers(ctx *gin.Context) {
 			break
 		}
 		if err != nil {
-			respondWithError(ctx, http.StatusInternalServerError, err.Error())
+			respondWithStatus(ctx, err)
 			return
 		}
 		users = append(users, user)
 	}
 
-	ctx.Header("Content-Type", "application/json")
-	ctx.IndentedJSON(http.StatusOK, &users)
+	renderUsers(ctx, http.StatusOK, users)
 }
 
 func (handler *handler) getUser(ctx *gin.Context) {
@@ -163,32 +397,152 @@ func (handler *handler) getUser(ctx *gin.Context) {
 	if err != nil {
 		return
 	}
-	ctx.Header("Content-Type", "application/json")
-	ctx.IndentedJSON(http.StatusOK, user)
+	renderUser(ctx, http.StatusOK, user)
 }
 
-func (handler *handler) changeUserFields(ctx *gin.Context) {
+// createUser adds a user which does not exist yet, an admin role is only requested for approval
+func (handler *handler) createUser(ctx *gin.Context) {
+	var req createUserRequest
+	if !bindUserRequest(ctx, &req) {
+		return
+	}
+	_, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: req.ID})
+	if err == nil {
+		respondWithError(ctx, http.StatusConflict, "User with id %v already exists", req.ID)
+		return
+	}
+	if status.Code(err) != codes.NotFound {
+		respondWithStatus(ctx, err)
+		return
+	}
+	handler.saveUser(ctx, req.toProto(), http.StatusCreated)
+}
+
+// replaceUser replaces every field of a user with the body, telegram chat stays linked
+func (handler *handler) replaceUser(ctx *gin.Context) {
+	var req replaceUserRequest
+	if !bindUserRequest(ctx, &req) {
+		return
+	}
 	user, err := handler.getUserFromParam(ctx)
 	if err != nil {
 		return
 	}
-	oldRole := user.GetRole()
-	err = ctx.BindJSON(&user)
+	req.apply(user)
+	handler.saveUser(ctx, user, http.StatusOK)
+}
+
+// patchUser changes only the fields present in the body
+func (handler *handler) patchUser(ctx *gin.Context) {
+	var req patchUserRequest
+	if !bindUserRequest(ctx, &req) {
+		return
+	}
+	user, err := handler.getUserFromParam(ctx)
 	if err != nil {
-		respondWithError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
+	req.apply(user)
+	handler.saveUser(ctx, user, http.StatusOK)
+}
 
-	if oldRole != user.GetRole() {
-		userID, _ := ctx.Get("user_id")
-		id, _ := strconv.ParseInt(ctx.Param("id"), 10, 64)
-		if id == userID.(int64) {
-			respondWithError(ctx, http.StatusBadRequest, "Admins cannot change their role")
-			return
-		}
+// storeUser stores a created or changed user. The database service applies escalations only after
+// another admin approves them, so the user is saved with its old role and the returned role change is requested.
+// It is shared by requests and import jobs, which outlive their requests.
+func (handler *handler) storeUser(ctx context.Context, user *service.User) (*service.RoleChangeRequest, error) {
+	res, err := handler.AddOrUpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	request := res.GetRoleChangeRequest()
+	if request != nil {
+		user.Role = request.GetFromRole()
+	}
+	return request, nil
+}
+
+// saveUser stores the user and responds with it, or with the role change request on escalation
+func (handler *handler) saveUser(ctx *gin.Context, user *service.User, code int) {
+	request, err := handler.storeUser(ctx, user)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	respondWithSaved(ctx, code, user, request)
+}
+
+// respondWithSaved responds with a saved user, or with 202 and the role change request if there is one.
+// CSV and protobuf have no place for the request, so only X-Role-Change-Request-ID header refers to it.
+func respondWithSaved(ctx *gin.Context, code int, user *service.User, request *service.RoleChangeRequest) {
+	if request == nil {
+		renderUser(ctx, code, user)
+		return
+	}
+	ctx.Header("X-Role-Change-Request-ID", strconv.FormatInt(request.GetId(), 10))
+	switch ctx.GetString("format") {
+	case csvContentType, protobufContentType:
+		renderUser(ctx, http.StatusAccepted, user)
+		return
+	}
+	encoded, ok := usersJSON(ctx, user)
+	if !ok {
+		return
 	}
+	renderDocument(ctx, http.StatusAccepted, gin.H{
+		"user":                encoded[0],
+		"role_change_request": roleChangeRequestFromProto(request),
+	})
+}
 
-	handler.AddOrUpdateUser(ctx, user)
+// deleteUser soft-deletes a user, admins cannot delete themselves
+func (handler *handler) deleteUser(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	if userID, _ := ctx.Get("user_id"); id == userID.(int64) {
+		respondWithError(ctx, http.StatusBadRequest, "Admins cannot delete themselves")
+		return
+	}
+	_, err = handler.DeleteUser(ctx, &service.UserByIDRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		respondWithError(ctx, http.StatusNotFound, "User with id %v not found", id)
+		return
+	}
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// actorMetadata lists metadata pairs with ID of the authorized admin and of the admin impersonating them, if any
+func actorMetadata(ctx context.Context) []string {
+	pairs := make([]string, 0, 4)
+	if userID, ok := ctx.Value("user_id").(int64); ok {
+		pairs = append(pairs, service.ActorMetadataKey, strconv.FormatInt(userID, 10))
+	}
+	if impersonatorID, ok := ctx.Value("impersonator_id").(int64); ok {
+		pairs = append(pairs, service.ImpersonatorMetadataKey, strconv.FormatInt(impersonatorID, 10))
+	}
+	return pairs
+}
+
+// withActor passes the actor to the database service, so it is recorded in history
+func withActor(ctx context.Context) context.Context {
+	if pairs := actorMetadata(ctx); len(pairs) > 0 {
+		return metadata.AppendToOutgoingContext(ctx, pairs...)
+	}
+	return ctx
+}
+
+func actorUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(withActor(ctx), method, req, reply, cc, opts...)
+}
+
+func actorStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(withActor(ctx), desc, cc, method, opts...)
 }
 
 func (handler *handler) getUserFromParam(ctx *gin.Context) (*service.User, error) {
@@ -204,41 +558,137 @@ func (handler *handler) getUserFromParam(ctx *gin.Context) (*service.User, error
 		return nil, err
 	}
 	if err != nil {
-		respondWithError(ctx, http.StatusInternalServerError, err.Error())
+		respondWithStatus(ctx, err)
 		return nil, err
 	}
 
 	return user, nil
 }
 
-func respondWithError(c *gin.Context, code int, format string, args ...interface{}) {
-	c.AbortWithStatusJSON(code, gin.H{"error": fmt.Sprintf(format, args...)})
+// registerAPI adds routes of the API to the group, they are registered both under /api/v1 and as deprecated aliases
+func (handler *handler) registerAPI(api *gin.RouterGroup) {
+	api.GET("/users", negotiate(userFormats...), handler.getUsers)
+	api.POST("/users", negotiate(userFormats...), handler.createUser)
+	api.GET("/users/export", handler.exportUsers)
+	api.POST("/users/import", handler.importUsers)
+	api.GET("/users/:id", negotiate(userFormats...), handler.getUser)
+	api.PUT("/users/:id", negotiate(userFormats...), handler.replaceUser)
+	api.PATCH("/users/:id", negotiate(userFormats...), handler.patchUser)
+	api.DELETE("/users/:id", handler.deleteUser)
+	api.GET("/users/:id/diff", handler.getUserDiff)
+	api.GET("/users/:id/merge/:source", negotiate(documentFormats...), handler.previewMerge)
+	api.POST("/users/:id/merge/:source", negotiate(userFormats...), handler.commitMerge)
+	api.GET("/jobs/:id", handler.getJob)
+	api.GET("/attributes", handler.getAttributes)
+	api.POST("/attributes/:name", handler.defineAttribute)
+	api.GET("/events", handler.streamEvents)
+	api.GET("/webhooks", handler.getWebhooks)
+	api.POST("/webhooks", handler.registerWebhook)
+	api.DELETE("/webhooks/:id", handler.deleteWebhook)
+	api.GET("/webhooks/:id/deliveries", handler.getWebhookDeliveries)
+	api.POST("/webhook-deliveries/:id/redeliver", handler.redeliverWebhook)
+	api.GET("/role-requests", handler.getRoleChangeRequests)
+	api.POST("/role-requests/:id/approve", handler.approveRoleChange)
+	api.POST("/role-requests/:id/reject", handler.rejectRoleChange)
+	api.GET("/invitations", handler.getInvitations)
+	api.POST("/invitations", handler.createInvitation)
+	api.POST("/invitations/:code/revoke", handler.revokeInvitation)
+	api.GET("/invitations/:code/redemptions", handler.getRedemptions)
+	api.POST("/broadcasts", handler.createBroadcast)
+	api.GET("/broadcasts/:id", handler.getBroadcast)
+	api.POST("/logout", handler.logout)
+	api.GET("/sessions", handler.getSessions)
+	api.DELETE("/sessions/:id", handler.deleteSession)
+	api.GET("/tokens", handler.getTokens)
+	api.POST("/tokens", handler.createToken)
+	api.DELETE("/tokens/:id", handler.deleteToken)
+	api.POST("/impersonate/:id", handler.startImpersonation)
+	api.DELETE("/impersonate", handler.stopImpersonation)
 }
 
 func main() {
-	router := gin.Default()
-	grpcConn, err := grpc.Dial(*grpcDbServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	flag.Parse()
+	shutdownTracing, err := tracing.Setup(context.Background(), *tracingConfig)
+	if err != nil {
+		log.Panicf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(logRequest), gin.CustomRecovery(recoverWithProblem), assignRequestID)
+	router.NoRoute(notFound)
+	// Calls to database service made with gin context join the request span
+	router.ContextWithFallback = true
+	router.Use(traceRequests)
+	dbClient, err := client.Dial(*grpcDbServiceAddress,
+		client.WithTimeout(*dbCallTimeout),
+		client.WithServiceToken(os.Getenv("DB_SERVICE_TOKEN")),
+		client.WithDialOptions(
+			grpc.WithChainUnaryInterceptor(actorUnaryInterceptor),
+			grpc.WithChainStreamInterceptor(actorStreamInterceptor)))
 	if err != nil {
 		log.Panicf("Failed to establish connection with database service: %v", err)
 	}
-	defer grpcConn.Close()
-	provider, err := oidc.NewProvider(context.Background(), "https://id.itmo.ru/auth/realms/itmo")
+	defer dbClient.Close()
+	provider, err := oidc.NewProvider(context.Background(), oidcConfig.Issuer)
 	if err != nil {
 		log.Panicf("Invalid provider: %v", err)
 	}
-	oauth2Config.Endpoint = provider.Endpoint()
+	oauth2Config = oidcConfig.OAuth2(provider.Endpoint())
+	sessions, err := newSessionStore(os.Getenv("REST_SESSION_KEY"), *sessionIdleTimeout, *sessionAbsoluteTimeout)
+	if err != nil {
+		log.Panicf("Failed to create session store: %v", err)
+	}
 	handler := handler{
-		DatabaseTestClient: nil,
+		DatabaseTestClient: dbClient,
 		authChan:           make(chan int64),
 		provider:           provider,
-		sessions:           map[string]int64{},
-		state:              generateState(),
+		sessions:           sessions,
+		logins:             oidcflow.NewStore(loginAttemptTTL),
+		jobs:               newJobStore(),
 	}
 
+	spec, err := loadSpec()
+	if err != nil {
+		log.Panicf("Failed to load OpenAPI document: %v", err)
+	}
+	limiter, err := newRateLimiter()
+	if err != nil {
+		log.Panicf("Failed to set up rate limiting: %v", err)
+	}
+	// Clients are limited by IP first, buckets of sessions and tokens are only made once authorize verified them
+	limitClients := func(*gin.Context) {}
+	if limiter != nil {
+		router.Use(limiter.limitRequests)
+		limitClients = limiter.limitClients
+	}
+	spec.registerDocs(router)
 	router.GET("/", handler.authenticate)
-	router.Use(handler.authorize)
-	router.GET("/users", handler.getUsers)
-	router.GET("/users/:id", handler.getUser)
-	router.POST("/users/:id", handler.changeUserFields)
-	router.Run(":8080")
+	handler.registerAPI(router.Group(apiPrefix, handler.authorize, limitClients, spec.validateRequests))
+	handler.registerAPI(router.Group("", deprecateAlias, handler.authorize, limitClients, spec.validateRequests))
+	console := router.Group("/console", handler.authorize, limitClients, handler.checkCSRF)
+	console.GET("", func(ctx *gin.Context) { ctx.Redirect(http.StatusFound, "/console/users") })
+	console.GET("/users", handler.consoleUsers)
+	console.GET("/users/:id", handler.consoleUser)
+	console.POST("/users/:id", handler.consoleSaveUser)
+	if err := spec.checkCoverage(router.Routes(), append(docsRoutes, consoleRoutes...)...); err != nil {
+		log.Panic(err)
+	}
+
+	server := &http.Server{Addr: ":8080", Handler: router}
+	// ListenAndServe returns as soon as shutdown starts, main waits until requests in flight are finished
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-signals.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("Error when shutting down: %v", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Panicf("Server failed: %v", err)
+	}
+	<-shutdown
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+type mergePreview struct {
+	Source  json.RawMessage `json:"source"`
+	Target  json.RawMessage `json:"target"`
+	Result  json.RawMessage `json:"result"`
+	Changes []fieldChange   `json:"changes"`
+}
+
+// parseMergeStrategy accepts strategy names without prefix, e.g. "prefer_source"
+func parseMergeStrategy(ctx *gin.Context) (service.MergeStrategy, bool) {
+	name := ctx.DefaultQuery("strategy", "prefer_target")
+	strategy, ok := service.MergeStrategy_value["MERGE_STRATEGY_"+strings.ToUpper(name)]
+	if !ok {
+		respondWithError(ctx, http.StatusBadRequest, "Unknown merge strategy: %v", name)
+		return 0, false
+	}
+	return service.MergeStrategy(strategy), true
+}
+
+func parseMergeRequest(ctx *gin.Context, dryRun bool) (*service.MergeUsersRequest, bool) {
+	targetID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return nil, false
+	}
+	sourceID, err := strconv.ParseInt(ctx.Param("source"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Source id has wrong format")
+		return nil, false
+	}
+	strategy, ok := parseMergeStrategy(ctx)
+	if !ok {
+		return nil, false
+	}
+	return &service.MergeUsersRequest{
+		SourceId:      sourceID,
+		TargetId:      targetID,
+		FieldStrategy: strategy,
+		DryRun:        dryRun,
+	}, true
+}
+
+func (handler *handler) mergeUsers(ctx *gin.Context, req *service.MergeUsersRequest) (*service.MergeUsersResponse, bool) {
+	res, err := handler.MergeUsers(ctx, req)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return nil, false
+	}
+	return res, true
+}
+
+// previewMerge shows what target user would look like after merging source into it
+func (handler *handler) previewMerge(ctx *gin.Context) {
+	req, ok := parseMergeRequest(ctx, true)
+	if !ok {
+		return
+	}
+	res, ok := handler.mergeUsers(ctx, req)
+	if !ok {
+		return
+	}
+	result := res.GetUser()
+	source, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: req.GetSourceId()})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	target, err := handler.GetUserByID(ctx, &service.UserByIDRequest{Id: req.GetTargetId()})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	encoded, ok := usersJSON(ctx, source, target, result)
+	if !ok {
+		return
+	}
+	renderDocument(ctx, http.StatusOK, mergePreview{
+		Source:  encoded[0],
+		Target:  encoded[1],
+		Result:  encoded[2],
+		Changes: diffUsers(target, result),
+	})
+}
+
+// commitMerge merges source user into target user
+func (handler *handler) commitMerge(ctx *gin.Context) {
+	req, ok := parseMergeRequest(ctx, false)
+	if !ok {
+		return
+	}
+	res, ok := handler.mergeUsers(ctx, req)
+	if !ok {
+		return
+	}
+	respondWithSaved(ctx, http.StatusOK, res.GetUser(), res.GetRoleChangeRequest())
+}
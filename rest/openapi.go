@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:embed openapi.yaml
+var openapiYAML []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// apiSpec is the OpenAPI document describing routes of the API
+type apiSpec struct {
+	doc  *openapi3.T
+	json []byte
+}
+
+func loadSpec() (*apiSpec, error) {
+	// Validation errors are returned to clients, dumps of schemas would only clutter them
+	openapi3.SchemaErrorDetailsDisabled = true
+	// Imported CSV files are parsed by the handler
+	openapi3filter.RegisterBodyDecoder("text/csv", openapi3filter.FileBodyDecoder)
+	doc, err := openapi3.NewLoader().LoadFromData(openapiYAML)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("OpenAPI document is invalid: %w", err)
+	}
+	raw, err := doc.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &apiSpec{doc: doc, json: raw}, nil
+}
+
+// specPath converts gin route to OpenAPI path, e.g. /api/v1/users/:id to /users/{id}.
+// Paths of the document are relative to /api/v1, deprecated aliases are described by the same paths.
+func specPath(route string) string {
+	segments := strings.Split(strings.TrimPrefix(route, apiPrefix), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func (spec *apiSpec) operation(method, route string) (string, *openapi3.PathItem, *openapi3.Operation) {
+	path := specPath(route)
+	item := spec.doc.Paths.Find(path)
+	if item == nil {
+		return path, nil, nil
+	}
+	return path, item, item.GetOperation(method)
+}
+
+// checkCoverage makes sure that every route of the router is described, so the document does not fall behind
+func (spec *apiSpec) checkCoverage(routes gin.RoutesInfo, undocumented ...string) error {
+	skipped := make(map[string]bool)
+	for _, route := range undocumented {
+		skipped[route] = true
+	}
+	for _, route := range routes {
+		if skipped[route.Path] {
+			continue
+		}
+		if _, _, operation := spec.operation(route.Method, route.Path); operation == nil {
+			return fmt.Errorf("route %v %v is missing in OpenAPI document", route.Method, route.Path)
+		}
+	}
+	return nil
+}
+
+// validateRequests rejects requests whose parameters or body do not match the document
+func (spec *apiSpec) validateRequests(ctx *gin.Context) {
+	path, item, operation := spec.operation(ctx.Request.Method, ctx.FullPath())
+	if operation == nil {
+		ctx.Next()
+		return
+	}
+	params := make(map[string]string)
+	for _, param := range ctx.Params {
+		params[param.Key] = param.Value
+	}
+	err := openapi3filter.ValidateRequest(ctx, &openapi3filter.RequestValidationInput{
+		Request:    ctx.Request,
+		PathParams: params,
+		Route: &routers.Route{
+			Spec:      spec.doc,
+			Path:      path,
+			PathItem:  item,
+			Method:    ctx.Request.Method,
+			Operation: operation,
+		},
+		// Credentials are checked by authorize
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			MultiError:         true,
+		},
+	})
+	if err != nil {
+		fields := make(map[string]string)
+		collectFieldErrors(err, fields)
+		if len(fields) == 0 {
+			respondWithError(ctx, http.StatusBadRequest, "%v", err)
+			return
+		}
+		respondWithFieldErrors(ctx, fields)
+		return
+	}
+	ctx.Next()
+}
+
+// unsupportedPropertyRegex matches reason of a property forbidden by additionalProperties
+var unsupportedPropertyRegex = regexp.MustCompile(`^property "(.+)" is unsupported$`)
+
+// collectFieldErrors names invalid parameters and body fields the same way as bindUserRequest, e.g. groups[0]
+func collectFieldErrors(err error, fields map[string]string) {
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		for _, inner := range err {
+			collectFieldErrors(inner, fields)
+		}
+	case *openapi3filter.RequestError:
+		if err.Parameter == nil {
+			collectFieldErrors(err.Err, fields)
+			return
+		}
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err.Err, &schemaErr) {
+			fields[err.Parameter.Name] = schemaErr.Reason
+		} else {
+			fields[err.Parameter.Name] = err.Error()
+		}
+	case *openapi3.SchemaError:
+		pointer := err.JSONPointer()
+		reason := err.Reason
+		if match := unsupportedPropertyRegex.FindStringSubmatch(err.Reason); match != nil {
+			pointer = append(pointer, match[1])
+			reason = "cannot be set"
+		}
+		var field strings.Builder
+		for _, segment := range pointer {
+			if _, convErr := strconv.Atoi(segment); convErr == nil {
+				field.WriteString("[" + segment + "]")
+				continue
+			}
+			if field.Len() > 0 {
+				field.WriteString(".")
+			}
+			field.WriteString(segment)
+		}
+		fields[field.String()] = reason
+	}
+}
+
+func (spec *apiSpec) serveJSON(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json", spec.json)
+}
+
+// docsRoutes are not described in the document
+var docsRoutes = []string{"/openapi.json", "/docs", "/docs/static/*filepath"}
+
+// registerDocs serves the document and explorer UI, they are available without login
+func (spec *apiSpec) registerDocs(router *gin.Engine) {
+	router.GET("/openapi.json", spec.serveJSON)
+	router.GET("/docs", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	})
+	router.StaticFS("/docs/static", http.FS(swaggerFiles.FS))
+}
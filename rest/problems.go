@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// problemContentType is the media type of error responses, see RFC 7807
+const problemContentType = "application/problem+json"
+
+// requestIDHeader carries ID of a request, it is taken from the client or proxy if it has one
+const requestIDHeader = "X-Request-ID"
+
+// Types of problems which have extension members, other problems are "about:blank"
+// and are described by their status
+const (
+	problemInvalidFields = "/problems/invalid-fields"
+)
+
+// problem is an error response. Fields are set for problemInvalidFields only.
+type problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance"`
+	RequestID string            `json:"request_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// grpcStatuses maps codes of the database service to HTTP statuses, other codes are 500
+var grpcStatuses = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+// httpStatus converts an error of the database service to HTTP status
+func httpStatus(err error) int {
+	if code, ok := grpcStatuses[status.Code(err)]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// requestIDRegex limits IDs taken from clients, so they are safe to log and echo
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// assignRequestID sets request_id of the context and response header, it is reported in problems
+func assignRequestID(ctx *gin.Context) {
+	id := ctx.GetHeader(requestIDHeader)
+	if !requestIDRegex.MatchString(id) {
+		var err error
+		if id, err = randomToken(12); err != nil {
+			log.Printf("Failed to generate request ID: %v", err)
+		}
+	}
+	ctx.Set("request_id", id)
+	ctx.Header(requestIDHeader, id)
+	ctx.Next()
+}
+
+// respondWithProblem aborts the request with an error response
+func respondWithProblem(ctx *gin.Context, res problem) {
+	if res.Type == "" {
+		res.Type = "about:blank"
+	}
+	if res.Title == "" {
+		res.Title = http.StatusText(res.Status)
+	}
+	res.Instance = ctx.Request.URL.RequestURI()
+	res.RequestID = ctx.GetString("request_id")
+	ctx.Abort()
+	ctx.Render(res.Status, problemRender{res})
+}
+
+// problemRender writes a problem with its media type, gin renders JSON as application/json only
+type problemRender struct {
+	problem problem
+}
+
+func (r problemRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(r.problem)
+}
+
+func (r problemRender) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", problemContentType)
+}
+
+func respondWithError(ctx *gin.Context, code int, format string, args ...interface{}) {
+	respondWithProblem(ctx, problem{Status: code, Detail: fmt.Sprintf(format, args...)})
+}
+
+// respondWithStatus responds with an error of the database service, its code is mapped with grpcStatuses
+func respondWithStatus(ctx *gin.Context, err error) {
+	respondWithError(ctx, httpStatus(err), "%v", status.Convert(err).Message())
+}
+
+// respondWithFieldErrors lists every invalid parameter and field of the body
+func respondWithFieldErrors(ctx *gin.Context, fields map[string]string) {
+	respondWithProblem(ctx, problem{
+		Type:   problemInvalidFields,
+		Title:  "Request has invalid fields",
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	})
+}
+
+// recoverWithProblem reports panics of handlers like other errors
+func recoverWithProblem(ctx *gin.Context, err interface{}) {
+	log.Printf("Panic in request %v: %v", ctx.GetString("request_id"), err)
+	respondWithError(ctx, http.StatusInternalServerError, "Unexpected error, request ID can be used to find it in logs")
+}
+
+// notFound responds to requests which match no route
+func notFound(ctx *gin.Context) {
+	respondWithError(ctx, http.StatusNotFound, "No route for %v %v", ctx.Request.Method, ctx.Request.URL.Path)
+}
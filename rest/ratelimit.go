@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimit is a token bucket which is refilled with Rate tokens per second up to Burst tokens
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// rateLimitStore keeps buckets by key, Take removes a token from the bucket
+// or reports how long to wait until one is available
+type rateLimitStore interface {
+	Take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error)
+}
+
+// bucket is the state of a token bucket
+type bucket struct {
+	limit   rateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds tokens for the time passed since the last update
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	b.updated = now
+}
+
+// memoryRateLimitStore keeps buckets of a single replica
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// rateLimitSweepInterval is how often full buckets are removed from memory
+const rateLimitSweepInterval = time.Minute
+
+func (store *memoryRateLimitStore) Take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Full buckets are the same as missing ones, so clients which went away do not take memory
+	if now.Sub(store.lastSweep) > rateLimitSweepInterval {
+		for key, b := range store.buckets {
+			if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+				delete(store.buckets, key)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	b, ok := store.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		store.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// takeTokenScript is the token bucket of memoryRateLimitStore run atomically by Redis.
+// It returns whether a token was taken and milliseconds to wait otherwise.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, wait}
+`)
+
+// redisRateLimitStore keeps buckets in Redis, so every replica sees the same limits.
+// Buckets expire once they are full again.
+type redisRateLimitStore struct {
+	client *redis.Client
+}
+
+func newRedisRateLimitStore(url string) (*redisRateLimitStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimitStore{client: redis.NewClient(options)}, nil
+}
+
+func (store *redisRateLimitStore) Take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	result, err := takeTokenScript.Run(ctx, store.client, []string{"rate-limit:" + key},
+		limit.Rate, limit.Burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// rateLimiter applies limits of routes to clients
+type rateLimiter struct {
+	store rateLimitStore
+	// ip applies to every request, api only to verified sessions and tokens
+	ip  rateLimit
+	api rateLimit
+	// routes have stricter limits in addition to api, by "METHOD /fullpath"
+	routes map[string]rateLimit
+}
+
+// newRateLimiter configures limits from flags, nil is returned if limiting is disabled
+func newRateLimiter() (*rateLimiter, error) {
+	if *apiRateLimit <= 0 {
+		return nil, nil
+	}
+	var store rateLimitStore = newMemoryRateLimitStore()
+	if *rateLimitRedis != "" {
+		redisStore, err := newRedisRateLimitStore(*rateLimitRedis)
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	}
+	limiter := &rateLimiter{
+		store:  store,
+		ip:     rateLimit{Rate: *ipRateLimit, Burst: *ipRateBurst},
+		api:    rateLimit{Rate: *apiRateLimit, Burst: *apiRateBurst},
+		routes: make(map[string]rateLimit),
+	}
+	if *searchRateLimit > 0 {
+		// Searches scan the whole table
+		search := rateLimit{Rate: *searchRateLimit, Burst: *searchRateBurst}
+		limiter.routes["GET /users"] = search
+		limiter.routes["GET /users/export"] = search
+		limiter.routes["GET /console/users"] = search
+	}
+	if *authRateLimit > 0 {
+		// Callbacks are limited by IP only, so failed logins cannot be retried endlessly
+		limiter.routes["GET /"] = rateLimit{Rate: *authRateLimit, Burst: *authRateBurst}
+	}
+	return limiter, nil
+}
+
+// rateLimitClient identifies a client verified by authorize by its session or token
+func rateLimitClient(ctx *gin.Context) string {
+	if tokenID, ok := ctx.Get("token_id"); ok {
+		return fmt.Sprintf("token:%v", tokenID)
+	}
+	return "session:" + ctx.GetString("session_id")
+}
+
+// take removes a token for the client, responding with 429 if there is none.
+// Errors of the store are logged and the request is let through.
+func (limiter *rateLimiter) take(ctx *gin.Context, key string, limit rateLimit) bool {
+	allowed, wait, err := limiter.store.Take(ctx, key, limit)
+	if err != nil {
+		log.Printf("Error when checking rate limit: %v", err)
+		return true
+	}
+	if allowed {
+		return true
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	respondWithError(ctx, http.StatusTooManyRequests, "Too many requests, retry in %v seconds", seconds)
+	return false
+}
+
+// limitRequests runs before authorize and limits clients by IP,
+// so requests with made up credentials do not reach the database service
+func (limiter *rateLimiter) limitRequests(ctx *gin.Context) {
+	route := apiRoute(ctx)
+	if route == "GET /" {
+		if limit, ok := limiter.routes[route]; !ok || limiter.take(ctx, "auth:ip:"+ctx.ClientIP(), limit) {
+			ctx.Next()
+		}
+		return
+	}
+	if limiter.take(ctx, "api:ip:"+ctx.ClientIP(), limiter.ip) {
+		ctx.Next()
+	}
+}
+
+// limitClients runs after authorize, so only sessions and tokens which exist get buckets
+func (limiter *rateLimiter) limitClients(ctx *gin.Context) {
+	client := rateLimitClient(ctx)
+	if !limiter.take(ctx, "api:"+client, limiter.api) {
+		return
+	}
+	if limit, ok := limiter.routes[apiRoute(ctx)]; ok && !limiter.take(ctx, apiRoute(ctx)+":"+client, limit) {
+		return
+	}
+	ctx.Next()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkRateLimitStore takes the whole burst of a bucket and one token more
+func checkRateLimitStore(t *testing.T, store rateLimitStore, key string) {
+	t.Helper()
+	limit := rateLimit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+	for i := 0; i < limit.Burst; i++ {
+		if allowed, _, err := store.Take(ctx, key, limit); err != nil || !allowed {
+			t.Fatalf("token %v was not taken: %v", i+1, err)
+		}
+	}
+	allowed, wait, err := store.Take(ctx, key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("token was taken from an empty bucket")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("got wait %v, want up to a second", wait)
+	}
+	if allowed, _, err := store.Take(ctx, key+":other", limit); err != nil || !allowed {
+		t.Errorf("bucket of another key is empty: %v", err)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	checkRateLimitStore(t, newMemoryRateLimitStore(), "test")
+}
+
+func TestMemoryRateLimitStoreRefills(t *testing.T) {
+	store := newMemoryRateLimitStore()
+	limit := rateLimit{Rate: 1000, Burst: 1}
+	store.Take(context.Background(), "test", limit)
+	time.Sleep(5 * time.Millisecond)
+	if allowed, _, _ := store.Take(context.Background(), "test", limit); !allowed {
+		t.Error("bucket was not refilled")
+	}
+}
+
+// TestRedisRateLimitStore needs Redis at TEST_REDIS_URL, e.g. redis://localhost:6379/15
+func TestRedisRateLimitStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	store, err := newRedisRateLimitStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "test:" + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() { store.client.Del(context.Background(), "rate-limit:"+key, "rate-limit:"+key+":other") })
+	checkRateLimitStore(t, store, key)
+}
+
+// newRateLimitedRouter returns the API of newTestRouter limited the way main sets it up
+func newRateLimitedRouter(t *testing.T, limiter *rateLimiter) (*gin.Engine, *handler) {
+	t.Helper()
+	_, handler := newTestRouter(t)
+	router := gin.New()
+	router.Use(limiter.limitRequests)
+	handler.registerAPI(router.Group(apiPrefix, handler.authorize, limiter.limitClients))
+	return router, handler
+}
+
+func TestRateLimitedSessions(t *testing.T) {
+	limiter := &rateLimiter{
+		store:  newMemoryRateLimitStore(),
+		ip:     rateLimit{Rate: 1, Burst: 10},
+		api:    rateLimit{Rate: 1, Burst: 1},
+		routes: map[string]rateLimit{},
+	}
+	router, handler := newRateLimitedRouter(t, limiter)
+	cookie := login(t, handler, testAdminID)
+
+	if res := serve(router, cookie, http.MethodGet, apiPrefix+"/users/2"); res.Code != http.StatusOK {
+		t.Fatalf("got status %v: %v", res.Code, res.Body)
+	}
+	res := serve(router, cookie, http.MethodGet, apiPrefix+"/users/2")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %v, want %v", res.Code, http.StatusTooManyRequests)
+	}
+	if res.Header().Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q", res.Header().Get("Retry-After"))
+	}
+	if res := serve(router, login(t, handler, testAdminID), http.MethodGet, apiPrefix+"/users/2"); res.Code != http.StatusOK {
+		t.Errorf("another session got status %v", res.Code)
+	}
+}
+
+func TestRateLimitedClientIPs(t *testing.T) {
+	limiter := &rateLimiter{
+		store:  newMemoryRateLimitStore(),
+		ip:     rateLimit{Rate: 1, Burst: 1},
+		api:    rateLimit{Rate: 1, Burst: 10},
+		routes: map[string]rateLimit{},
+	}
+	router, handler := newRateLimitedRouter(t, limiter)
+
+	// Sessions of the same IP share its bucket
+	if res := serve(router, login(t, handler, testAdminID), http.MethodGet, apiPrefix+"/users/2"); res.Code != http.StatusOK {
+		t.Fatalf("got status %v: %v", res.Code, res.Body)
+	}
+	if res := serve(router, login(t, handler, testAdminID), http.MethodGet, apiPrefix+"/users/2"); res.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %v, want %v", res.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v2"
+)
+
+// Media types which users can be rendered as
+const (
+	jsonContentType     = "application/json"
+	yamlContentType     = "application/yaml"
+	csvContentType      = "text/csv"
+	protobufContentType = "application/x-protobuf"
+)
+
+var (
+	// userFormats are offered by routes responding with users, the first one is the default
+	userFormats = []string{jsonContentType, yamlContentType, csvContentType, protobufContentType}
+	// documentFormats are offered by routes responding with users nested in other fields
+	documentFormats = []string{jsonContentType, yamlContentType}
+)
+
+// mediaTypeAliases are other names clients use for the offered media types
+var mediaTypeAliases = map[string]string{
+	"application/x-yaml":   yamlContentType,
+	"text/yaml":            yamlContentType,
+	"application/protobuf": protobufContentType,
+}
+
+// userMarshalOptions render users of /api/v1, enums are named and fields keep names of the proto file
+var userMarshalOptions = protojson.MarshalOptions{UseProtoNames: true}
+
+type acceptedType struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept lists media types of Accept header from the most preferred one, unacceptable types are skipped
+func parseAccept(header string) []acceptedType {
+	accepted := make([]acceptedType, 0)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		if alias, ok := mediaTypeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedType{mediaType: mediaType, quality: quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+	return accepted
+}
+
+// negotiateFormat picks the offer preferred by Accept header, "" means that none is acceptable
+func negotiateFormat(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	for _, accepted := range parseAccept(header) {
+		for _, offer := range offers {
+			if accepted.mediaType == "*/*" || accepted.mediaType == offer {
+				return offer
+			}
+			if prefix, ok := strings.CutSuffix(accepted.mediaType, "*"); ok && strings.HasPrefix(offer, prefix) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// negotiate sets "format" of the context to the media type of response. Deprecated aliases
+// of routes are not negotiated, they keep responding with JSON of the previous version.
+func negotiate(offers ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetBool("legacy") {
+			ctx.Next()
+			return
+		}
+		format := negotiateFormat(ctx.GetHeader("Accept"), offers)
+		if format == "" {
+			respondWithError(ctx, http.StatusNotAcceptable, "Response can be one of: %v", strings.Join(offers, ", "))
+			return
+		}
+		ctx.Header("Vary", "Accept")
+		ctx.Set("format", format)
+		ctx.Next()
+	}
+}
+
+// userJSON encodes a user with protojson, deprecated aliases encode it with field types of Go
+func userJSON(ctx *gin.Context, user *service.User) (json.RawMessage, error) {
+	if ctx.GetBool("legacy") {
+		return json.Marshal(user)
+	}
+	return userMarshalOptions.Marshal(user)
+}
+
+// renderDocument responds with value as JSON or YAML, whichever was negotiated
+func renderDocument(ctx *gin.Context, code int, value interface{}) {
+	if ctx.GetString("format") != yamlContentType {
+		ctx.IndentedJSON(code, value)
+		return
+	}
+	// Users are encoded with protojson, so YAML is converted from JSON to keep the same fields
+	raw, err := json.Marshal(value)
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+		return
+	}
+	out, err := yaml.Marshal(yamlValue(document))
+	if err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+		return
+	}
+	ctx.Data(code, yamlContentType+"; charset=utf-8", out)
+}
+
+// yamlValue converts JSON numbers, so IDs are not written in exponent form
+func yamlValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = yamlValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = yamlValue(item)
+		}
+	case json.Number:
+		if number, err := value.Int64(); err == nil {
+			return number
+		}
+		number, _ := value.Float64()
+		return number
+	}
+	return value
+}
+
+// renderUsersCSV writes users as rows of export, columns are chosen with "columns" parameter
+func renderUsersCSV(ctx *gin.Context, code int, users []*service.User) {
+	columns, ok := parseExportColumns(ctx)
+	if !ok {
+		return
+	}
+	var out bytes.Buffer
+	writer := &csvRowWriter{writer: csv.NewWriter(&out)}
+	if err := writer.WriteRow(columnNames(columns)); err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+		return
+	}
+	for _, user := range users {
+		if err := writer.WriteRow(columnValues(columns, user)); err != nil {
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+			return
+		}
+	}
+	if err := writer.Close(); err != nil {
+		respondWithError(ctx, http.StatusInternalServerError, "Failed to encode response: %v", err)
+		return
+	}
+	ctx.Data(code, csvContentType+"; charset=utf-8", out.Bytes())
+}
+
+// usersJSON encodes users with userJSON, responding with an error if one of them cannot be encoded
+func usersJSON(ctx *gin.Context, users ...*service.User) ([]json.RawMessage, bool) {
+	encoded := make([]json.RawMessage, len(users))
+	for i, user := range users {
+		raw, err := userJSON(ctx, user)
+		if err != nil {
+			log.Printf("Failed to encode user %v: %v", user.GetId(), err)
+			respondWithError(ctx, http.StatusInternalServerError, "Failed to encode user %v", user.GetId())
+			return nil, false
+		}
+		encoded[i] = raw
+	}
+	return encoded, true
+}
+
+// renderUsers responds with users in the negotiated format
+func renderUsers(ctx *gin.Context, code int, users []*service.User) {
+	switch ctx.GetString("format") {
+	case csvContentType:
+		renderUsersCSV(ctx, code, users)
+	case protobufContentType:
+		ctx.ProtoBuf(code, &service.UserList{Users: users})
+	default:
+		if encoded, ok := usersJSON(ctx, users...); ok {
+			renderDocument(ctx, code, encoded)
+		}
+	}
+}
+
+// renderUser responds with a user in the negotiated format
+func renderUser(ctx *gin.Context, code int, user *service.User) {
+	switch ctx.GetString("format") {
+	case csvContentType:
+		renderUsersCSV(ctx, code, []*service.User{user})
+	case protobufContentType:
+		ctx.ProtoBuf(code, user)
+	default:
+		if encoded, ok := usersJSON(ctx, user); ok {
+			renderDocument(ctx, code, encoded[0])
+		}
+	}
+}
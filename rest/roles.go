@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+type roleChangeRequest struct {
+	ID            int64      `json:"id"`
+	UserID        int64      `json:"user_id"`
+	FromRole      string     `json:"from_role"`
+	RequestedRole string     `json:"requested_role"`
+	RequestedBy   int64      `json:"requested_by"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	DecidedBy     *int64     `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+}
+
+func roleChangeRequestFromProto(req *service.RoleChangeRequest) roleChangeRequest {
+	res := roleChangeRequest{
+		ID:            req.GetId(),
+		UserID:        req.GetUserId(),
+		FromRole:      req.GetFromRole().String(),
+		RequestedRole: req.GetRequestedRole().String(),
+		RequestedBy:   req.GetRequestedBy(),
+		Status:        strings.ToLower(strings.TrimPrefix(req.GetStatus().String(), "ROLE_CHANGE_STATUS_")),
+		CreatedAt:     req.GetCreatedAt().AsTime(),
+		ExpiresAt:     req.GetExpiresAt().AsTime(),
+		DecidedBy:     req.DecidedBy,
+	}
+	if req.DecidedAt != nil {
+		decidedAt := req.GetDecidedAt().AsTime()
+		res.DecidedAt = &decidedAt
+	}
+	return res
+}
+
+// getRoleChangeRequests lists role change requests, optionally filtered by "status" query parameter
+func (handler *handler) getRoleChangeRequests(ctx *gin.Context) {
+	req := &service.ListRoleChangeRequestsRequest{}
+	if name, ok := ctx.GetQuery("status"); ok {
+		requestStatus, ok := service.RoleChangeStatus_value["ROLE_CHANGE_STATUS_"+strings.ToUpper(name)]
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown request status: %v", name)
+			return
+		}
+		req.Status = service.RoleChangeStatus(requestStatus)
+	}
+
+	stream, err := handler.ListRoleChangeRequests(ctx, req)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	requests := make([]roleChangeRequest, 0)
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		requests = append(requests, roleChangeRequestFromProto(request))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, requests)
+}
+
+func (handler *handler) decideRoleChange(ctx *gin.Context, approve bool) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	request, err := handler.DecideRoleChangeRequest(ctx, &service.RoleChangeDecision{
+		Id:      id,
+		Approve: approve,
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, roleChangeRequestFromProto(request))
+}
+
+func (handler *handler) approveRoleChange(ctx *gin.Context) {
+	handler.decideRoleChange(ctx, true)
+}
+
+func (handler *handler) rejectRoleChange(ctx *gin.Context) {
+	handler.decideRoleChange(ctx, false)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookie is name of the cookie which holds signed session token
+const sessionCookie = "session"
+
+// loginStateCookie binds a login attempt to the browser which started it,
+// so a callback with state of another attempt cannot log the victim in to the attacker's account
+const loginStateCookie = "login_state"
+
+// session is a server-side record of an admin login
+type session struct {
+	// ID identifies the session in listings, unlike the token it cannot be used to authenticate
+	ID        string
+	UserID    int64
+	CreatedAt time.Time
+	LastSeen  time.Time
+	RemoteIP  string
+	UserAgent string
+	// CSRFToken must be sent with forms of the console
+	CSRFToken string
+	// ImpersonatedID is the user whose identity requests use until ImpersonationEnds, 0 if there is none
+	ImpersonatedID    int64
+	ImpersonationEnds time.Time
+}
+
+// sessionStore keeps sessions by their secret tokens
+type sessionStore struct {
+	key             []byte
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type sessionInfo struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RemoteIP   string    `json:"remote_ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+}
+
+func randomToken(length int) (string, error) {
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// newSessionStore creates a store signing cookies with key, a random key is used if it is empty
+func newSessionStore(key string, idleTimeout, absoluteTimeout time.Duration) (*sessionStore, error) {
+	if key == "" {
+		random, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		key = random
+	}
+	return &sessionStore{
+		key:             []byte(key),
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		sessions:        make(map[string]*session),
+	}, nil
+}
+
+func (store *sessionStore) sign(token string) string {
+	mac := hmac.New(sha256.New, store.key)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// expiresAt is when the session expires unless it is used again
+func (store *sessionStore) expiresAt(s *session) time.Time {
+	idle := s.LastSeen.Add(store.idleTimeout)
+	absolute := s.CreatedAt.Add(store.absoluteTimeout)
+	if idle.Before(absolute) {
+		return idle
+	}
+	return absolute
+}
+
+// removeExpired must be called with mu held
+func (store *sessionStore) removeExpired(now time.Time) {
+	for token, s := range store.sessions {
+		if !now.Before(store.expiresAt(s)) {
+			delete(store.sessions, token)
+		}
+	}
+}
+
+// create starts a session and returns signed cookie value
+func (store *sessionStore) create(userID int64, remoteIP, userAgent string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	id, err := randomToken(9)
+	if err != nil {
+		return "", err
+	}
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.removeExpired(now)
+	store.sessions[token] = &session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: now,
+		LastSeen:  now,
+		RemoteIP:  remoteIP,
+		UserAgent: userAgent,
+		CSRFToken: csrfToken,
+	}
+	return token + "." + store.sign(token), nil
+}
+
+// token verifies signature of cookie value and returns session token
+func (store *sessionStore) token(cookie string) (string, bool) {
+	token, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(store.sign(token))) {
+		return "", false
+	}
+	return token, true
+}
+
+// touch returns a session by cookie value and prolongs it, expired sessions are removed
+func (store *sessionStore) touch(cookie string) (*session, bool) {
+	token, ok := store.token(cookie)
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(store.expiresAt(s)) {
+		delete(store.sessions, token)
+		return nil, false
+	}
+	s.LastSeen = now
+	if s.ImpersonatedID != 0 && !now.Before(s.ImpersonationEnds) {
+		log.Printf("Impersonation of user %v by admin %v has expired", s.ImpersonatedID, s.UserID)
+		s.ImpersonatedID = 0
+	}
+	copied := *s
+	return &copied, true
+}
+
+// peek returns a copy of a live session without counting it as activity of the client
+func (store *sessionStore) peek(cookie string) (*session, bool) {
+	token, ok := store.token(cookie)
+	if !ok {
+		return nil, false
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[token]
+	if !ok || !time.Now().Before(store.expiresAt(s)) {
+		return nil, false
+	}
+	copied := *s
+	return &copied, true
+}
+
+// impersonate makes requests of the session act as the target until ends, returns false if the session has ended
+func (store *sessionStore) impersonate(cookie string, targetID int64, ends time.Time) bool {
+	token, ok := store.token(cookie)
+	if !ok {
+		return false
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[token]
+	if !ok {
+		return false
+	}
+	s.ImpersonatedID = targetID
+	s.ImpersonationEnds = ends
+	return true
+}
+
+// stopImpersonating ends impersonation of the session and returns the impersonated user, 0 if there was none
+func (store *sessionStore) stopImpersonating(cookie string) int64 {
+	token, ok := store.token(cookie)
+	if !ok {
+		return 0
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[token]
+	if !ok || s.ImpersonatedID == 0 || !time.Now().Before(s.ImpersonationEnds) {
+		return 0
+	}
+	targetID := s.ImpersonatedID
+	s.ImpersonatedID = 0
+	return targetID
+}
+
+// remove ends a session by cookie value
+func (store *sessionStore) remove(cookie string) {
+	token, ok := store.token(cookie)
+	if !ok {
+		return
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.sessions, token)
+}
+
+// list returns active sessions of a user, newest first
+func (store *sessionStore) list(userID int64) []session {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.removeExpired(time.Now())
+	sessions := make([]session, 0)
+	for _, s := range store.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// revoke ends a session of a user by its ID, returns false if the user has no such session
+func (store *sessionStore) revoke(userID int64, id string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for token, s := range store.sessions {
+		if s.UserID == userID && s.ID == id {
+			delete(store.sessions, token)
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *handler) setSessionCookie(ctx *gin.Context, value string, maxAge int) {
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(sessionCookie, value, maxAge, "/", "", *secureCookies, true)
+}
+
+func loginStateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (handler *handler) setLoginStateCookie(ctx *gin.Context, state string) {
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(loginStateCookie, loginStateHash(state), int(loginAttemptTTL.Seconds()), "/", "", *secureCookies, true)
+}
+
+// checkLoginState reports whether the login attempt with the state was started by this client, the cookie is cleared
+func (handler *handler) checkLoginState(ctx *gin.Context, state string) bool {
+	cookie, err := ctx.Cookie(loginStateCookie)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(loginStateCookie, "", -1, "/", "", *secureCookies, true)
+	return err == nil && subtle.ConstantTimeCompare([]byte(cookie), []byte(loginStateHash(state))) == 1
+}
+
+// startSession logs the user in on this client
+func (handler *handler) startSession(ctx *gin.Context, userID int64) error {
+	value, err := handler.sessions.create(userID, ctx.ClientIP(), ctx.Request.UserAgent())
+	if err != nil {
+		return err
+	}
+	handler.setSessionCookie(ctx, value, int(handler.sessions.absoluteTimeout.Seconds()))
+	return nil
+}
+
+// currentSession returns the session of the request, if it is active
+func (handler *handler) currentSession(ctx *gin.Context) (*session, bool) {
+	cookie, err := ctx.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	return handler.sessions.touch(cookie)
+}
+
+// logout ends the current session
+func (handler *handler) logout(ctx *gin.Context) {
+	if cookie, err := ctx.Cookie(sessionCookie); err == nil {
+		handler.sessions.remove(cookie)
+	}
+	handler.setSessionCookie(ctx, "", -1)
+	ctx.Status(http.StatusNoContent)
+}
+
+// getSessions lists active sessions of the admin
+func (handler *handler) getSessions(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	current, _ := ctx.Get("session_id")
+	sessions := make([]sessionInfo, 0)
+	for _, s := range handler.sessions.list(userID.(int64)) {
+		sessions = append(sessions, sessionInfo{
+			ID:         s.ID,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeen,
+			ExpiresAt:  handler.sessions.expiresAt(&s),
+			RemoteIP:   s.RemoteIP,
+			UserAgent:  s.UserAgent,
+			Current:    s.ID == current,
+		})
+	}
+	ctx.IndentedJSON(http.StatusOK, sessions)
+}
+
+// deleteSession revokes another login of the admin
+func (handler *handler) deleteSession(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	if !handler.sessions.revoke(userID.(int64), ctx.Param("id")) {
+		respondWithError(ctx, http.StatusNotFound, "Session %v not found", ctx.Param("id"))
+		return
+	}
+	if current, _ := ctx.Get("session_id"); current == ctx.Param("id") {
+		handler.setSessionCookie(ctx, "", -1)
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultTokenLifetime is used when expiration is not given
+const defaultTokenLifetime = 90 * 24 * time.Hour
+
+type apiToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Revoked    bool       `json:"revoked"`
+	// Token is the secret, shown only once after creation
+	Token string `json:"token,omitempty"`
+}
+
+func apiTokenFromProto(token *service.APIToken) apiToken {
+	scopes := make([]string, 0, len(token.GetScopes()))
+	for _, scope := range token.GetScopes() {
+		scopes = append(scopes, strings.ToLower(strings.TrimPrefix(scope.String(), "TOKEN_SCOPE_")))
+	}
+	res := apiToken{
+		ID:        token.GetId(),
+		Name:      token.GetName(),
+		Scopes:    scopes,
+		ExpiresAt: token.GetExpiresAt().AsTime(),
+		CreatedAt: token.GetCreatedAt().AsTime(),
+		Revoked:   token.GetRevoked(),
+		Token:     token.GetSecret(),
+	}
+	if token.LastUsedAt != nil {
+		lastUsedAt := token.GetLastUsedAt().AsTime()
+		res.LastUsedAt = &lastUsedAt
+	}
+	return res
+}
+
+// bearerToken returns the secret from Authorization header, if the request has one
+func bearerToken(ctx *gin.Context) (string, bool) {
+	scheme, secret, ok := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(secret), true
+}
+
+// hasScope reports whether token scopes of the request include scope, requests with a session have every scope
+func hasScope(ctx *gin.Context, scope service.TokenScope) bool {
+	scopes, ok := ctx.Get("token_scopes")
+	if !ok {
+		return true
+	}
+	for _, granted := range scopes.([]service.TokenScope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// getTokens lists personal access tokens of the admin
+func (handler *handler) getTokens(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	stream, err := handler.ListAPITokens(ctx, &service.ListAPITokensRequest{UserId: userID.(int64)})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	tokens := make([]apiToken, 0)
+	for {
+		token, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		tokens = append(tokens, apiTokenFromProto(token))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, tokens)
+}
+
+// createToken creates a personal access token. Body fields are name, scopes ("read_users", "write_users")
+// and either expires_at or expires_in as Go duration, e.g. "720h".
+func (handler *handler) createToken(ctx *gin.Context) {
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		ExpiresIn string     `json:"expires_in"`
+	}
+	if err := ctx.BindJSON(&req); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	scopes := make([]service.TokenScope, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		value, ok := service.TokenScope_value["TOKEN_SCOPE_"+strings.ToUpper(name)]
+		if !ok || value == int32(service.TokenScope_TOKEN_SCOPE_UNSPECIFIED) {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown scope: %v", name)
+			return
+		}
+		scopes = append(scopes, service.TokenScope(value))
+	}
+	role, _ := ctx.Get("role")
+	for _, scope := range scopes {
+		if scope == service.TokenScope_TOKEN_SCOPE_WRITE_USERS && role == service.Role_ROLE_READ_ONLY_ADMIN {
+			respondWithError(ctx, http.StatusForbidden, "Read-only admins cannot create tokens with write_users scope")
+			return
+		}
+	}
+	expiresAt := time.Now().Add(defaultTokenLifetime)
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt = *req.ExpiresAt
+	case req.ExpiresIn != "":
+		lifetime, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			respondWithError(ctx, http.StatusBadRequest, "Wrong expires_in: %v", err)
+			return
+		}
+		expiresAt = time.Now().Add(lifetime)
+	}
+	userID, _ := ctx.Get("user_id")
+
+	token, err := handler.CreateAPIToken(ctx, &service.APIToken{
+		UserId:    userID.(int64),
+		Name:      req.Name,
+		Scopes:    scopes,
+		ExpiresAt: timestamppb.New(expiresAt),
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusCreated, apiTokenFromProto(token))
+}
+
+// deleteToken revokes a personal access token of the admin
+func (handler *handler) deleteToken(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	userID, _ := ctx.Get("user_id")
+	_, err = handler.RevokeAPIToken(ctx, &service.APITokenByIDRequest{Id: id, UserId: userID.(int64)})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
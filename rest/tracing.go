@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceRequests starts a span for every request, continuing a trace of the caller if there is one.
+// Router must have ContextWithFallback enabled for calls made with gin context to join the span.
+func traceRequests(ctx *gin.Context) {
+	parent := otel.GetTextMapPropagator().Extract(ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header))
+	route := ctx.FullPath()
+	if route == "" {
+		route = "unmatched route"
+	}
+	spanCtx, span := tracing.Tracer().Start(parent, fmt.Sprintf("%v %v", ctx.Request.Method, route),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(ctx.Request.Method),
+			semconv.HTTPRoute(ctx.FullPath()),
+			semconv.HTTPTarget(ctx.Request.URL.Path),
+			semconv.HTTPClientIP(ctx.ClientIP()),
+		))
+	defer span.End()
+	ctx.Request = ctx.Request.WithContext(spanCtx)
+
+	ctx.Next()
+
+	status := ctx.Writer.Status()
+	span.SetAttributes(semconv.HTTPStatusCode(status))
+	if userID, ok := ctx.Get("user_id"); ok {
+		span.SetAttributes(semconv.EnduserID(fmt.Sprint(userID)))
+	}
+	if impersonatorID, ok := ctx.Get("impersonator_id"); ok {
+		span.SetAttributes(attribute.Int64("enduser.impersonator_id", impersonatorID.(int64)))
+	}
+	if status >= 500 {
+		span.SetStatus(codes.Error, fmt.Sprintf("HTTP %v", status))
+	}
+	for _, err := range ctx.Errors {
+		span.RecordError(err)
+	}
+}
+
+// logRequest is the access log format of gin with ID and actor of the request.
+// Impersonated requests name both the impersonated user and the admin.
+func logRequest(param gin.LogFormatterParams) string {
+	actor := "-"
+	if userID, ok := param.Keys["user_id"]; ok {
+		actor = fmt.Sprint(userID)
+	}
+	if impersonatorID, ok := param.Keys["impersonator_id"]; ok {
+		actor = fmt.Sprintf("%v (impersonated by %v)", param.Keys["impersonated_id"], impersonatorID)
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | request %v | actor %v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		param.Keys["request_id"],
+		actor,
+		param.ErrorMessage,
+	)
+}
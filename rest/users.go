@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// phoneRegex is the format the telegram bot asks users for
+var phoneRegex = regexp.MustCompile(`^\+[0-9]+ \([0-9]{3}\) [0-9]{3}-[0-9]{2}-[0-9]{2}$`)
+
+// createUserRequest is the body of POST /users
+type createUserRequest struct {
+	ID          int64             `json:"id" binding:"required,gt=0"`
+	Name        string            `json:"name" binding:"required,max=200"`
+	PhoneNumber *string           `json:"phone_number" binding:"omitempty,phone"`
+	Role        role              `json:"role" binding:"omitempty,role"`
+	Attributes  map[string]string `json:"attributes" binding:"omitempty,dive,keys,min=1,max=64,endkeys,max=1000"`
+	Groups      []string          `json:"groups" binding:"omitempty,dive,min=1,max=64"`
+}
+
+// replaceUserRequest is the body of PUT /users/:id, missing optional fields are cleared
+type replaceUserRequest struct {
+	Name        string            `json:"name" binding:"required,max=200"`
+	PhoneNumber *string           `json:"phone_number" binding:"omitempty,phone"`
+	Role        role              `json:"role" binding:"omitempty,role"`
+	Attributes  map[string]string `json:"attributes" binding:"omitempty,dive,keys,min=1,max=64,endkeys,max=1000"`
+	Groups      []string          `json:"groups" binding:"omitempty,dive,min=1,max=64"`
+}
+
+// patchUserRequest is the body of PATCH /users/:id, only present fields are changed.
+// Empty phone number clears it, null attribute value removes the attribute.
+type patchUserRequest struct {
+	Name        *string            `json:"name" binding:"omitempty,min=1,max=200"`
+	PhoneNumber *string            `json:"phone_number" binding:"omitempty,phone|len=0"`
+	Role        *role              `json:"role" binding:"omitempty,role"`
+	Attributes  map[string]*string `json:"attributes" binding:"omitempty,dive,keys,min=1,max=64,endkeys,omitempty,max=1000"`
+	Groups      []string           `json:"groups" binding:"omitempty,dive,min=1,max=64"`
+}
+
+// parseRole accepts a role number or name in any case with or without prefix,
+// e.g. "2", "read_only_admin" or "ROLE_READ_ONLY_ADMIN"
+func parseRole(raw string) (service.Role, bool) {
+	if number, err := strconv.Atoi(raw); err == nil {
+		_, ok := service.Role_name[int32(number)]
+		return service.Role(number), ok
+	}
+	name := strings.ToUpper(raw)
+	if !strings.HasPrefix(name, "ROLE_") {
+		name = "ROLE_" + name
+	}
+	value, ok := service.Role_value[name]
+	return service.Role(value), ok
+}
+
+// role is a role in request bodies, given as a number or a name which parseRole accepts.
+// Unknown roles are decoded as unknownRole and reported by the role validation with other fields.
+type role service.Role
+
+const unknownRole role = -1
+
+func (r *role) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	value, ok := parseRole(strings.Trim(string(data), `"`))
+	if !ok {
+		*r = unknownRole
+		return nil
+	}
+	*r = role(value)
+	return nil
+}
+
+func roleOrUser(value role) service.Role {
+	if value == role(service.Role_ROLE_UNSPECIFIED) {
+		return service.Role_ROLE_USER
+	}
+	return service.Role(value)
+}
+
+func (req *createUserRequest) toProto() *service.User {
+	return &service.User{
+		Id:          req.ID,
+		Name:        req.Name,
+		PhoneNumber: req.PhoneNumber,
+		Role:        roleOrUser(req.Role),
+		Attributes:  req.Attributes,
+		Groups:      req.Groups,
+	}
+}
+
+// apply replaces every field which can be set by admins
+func (req *replaceUserRequest) apply(user *service.User) {
+	user.Name = req.Name
+	user.PhoneNumber = req.PhoneNumber
+	user.Role = roleOrUser(req.Role)
+	user.Attributes = req.Attributes
+	user.Groups = req.Groups
+}
+
+// apply changes the fields present in the request
+func (req *patchUserRequest) apply(user *service.User) {
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.PhoneNumber != nil {
+		user.PhoneNumber = req.PhoneNumber
+		if *req.PhoneNumber == "" {
+			user.PhoneNumber = nil
+		}
+	}
+	if req.Role != nil {
+		user.Role = service.Role(*req.Role)
+	}
+	if req.Attributes != nil {
+		if user.Attributes == nil {
+			user.Attributes = make(map[string]string)
+		}
+		for name, value := range req.Attributes {
+			if value == nil {
+				delete(user.Attributes, name)
+			} else {
+				user.Attributes[name] = *value
+			}
+		}
+	}
+	if req.Groups != nil {
+		user.Groups = req.Groups
+	}
+}
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	engine.RegisterValidation("phone", func(field validator.FieldLevel) bool {
+		return phoneRegex.MatchString(field.Field().String())
+	})
+	// Roles which can be assigned by admins
+	engine.RegisterValidation("role", func(field validator.FieldLevel) bool {
+		_, ok := service.Role_name[int32(field.Field().Int())]
+		return ok && field.Field().Int() != int64(service.Role_ROLE_UNSPECIFIED)
+	})
+	// Errors name fields as they are spelled in JSON
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// fieldErrorMessage describes a failed validation rule
+func fieldErrorMessage(err validator.FieldError) string {
+	switch err.Tag() {
+	case "required":
+		return "is required"
+	case "gt":
+		return fmt.Sprintf("must be greater than %v", err.Param())
+	case "min":
+		if err.Kind() == reflect.String && err.Param() == "1" {
+			return "must not be empty"
+		}
+		if err.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %v characters long", err.Param())
+		}
+		return fmt.Sprintf("must be at least %v", err.Param())
+	case "max":
+		if err.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %v characters long", err.Param())
+		}
+		return fmt.Sprintf("must be at most %v", err.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %v", strings.ReplaceAll(err.Param(), " ", ", "))
+	case "phone", "phone|len=0":
+		return "must be in format +x (xxx) xxx-xx-xx"
+	case "role":
+		return "must be user, read_only_admin or read_write_admin"
+	}
+	return fmt.Sprintf("does not satisfy %v", err.Tag())
+}
+
+// decodeFieldErrors names the field which could not be decoded, it returns nil for malformed JSON
+func decodeFieldErrors(err error) map[string]string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return map[string]string{typeErr.Field: fmt.Sprintf("must be %v", typeErr.Type)}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return map[string]string{strings.Trim(field, `"`): "cannot be set"}
+	}
+	return nil
+}
+
+// validationFieldErrors validates req with binding rules and describes every invalid field,
+// it returns nil if req is valid
+func validationFieldErrors(req interface{}) (map[string]string, error) {
+	err := binding.Validator.ValidateStruct(req)
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	for _, fieldErr := range validationErrs {
+		// Namespace starts with the name of request type
+		_, field, _ := strings.Cut(fieldErr.Namespace(), ".")
+		fields[field] = fieldErrorMessage(fieldErr)
+	}
+	return fields, nil
+}
+
+// bindUserRequest decodes the body into req rejecting fields which are not in it, e.g. id or deleted,
+// and validates it. Every invalid field is reported at once.
+func bindUserRequest(ctx *gin.Context, req interface{}) bool {
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		if fields := decodeFieldErrors(err); fields != nil {
+			respondWithFieldErrors(ctx, fields)
+		} else {
+			respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		}
+		return false
+	}
+
+	fields, err := validationFieldErrors(req)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return false
+	}
+	if fields != nil {
+		respondWithFieldErrors(ctx, fields)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/gin-gonic/gin"
+)
+
+type webhook struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type webhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	EventID       int64      `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status"`
+	Attempts      int32      `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+func webhookFromProto(hook *service.Webhook) webhook {
+	eventTypes := hook.GetEventTypes()
+	if eventTypes == nil {
+		eventTypes = make([]string, 0)
+	}
+	return webhook{
+		ID:         hook.GetId(),
+		URL:        hook.GetUrl(),
+		EventTypes: eventTypes,
+		Secret:     hook.GetSecret(),
+		CreatedAt:  hook.GetCreatedAt().AsTime(),
+	}
+}
+
+func webhookDeliveryFromProto(delivery *service.WebhookDelivery) webhookDelivery {
+	res := webhookDelivery{
+		ID:            delivery.GetId(),
+		WebhookID:     delivery.GetWebhookId(),
+		EventID:       delivery.GetEventId(),
+		EventType:     delivery.GetEventType(),
+		Status:        strings.ToLower(strings.TrimPrefix(delivery.GetStatus().String(), "DELIVERY_STATUS_")),
+		Attempts:      delivery.GetAttempts(),
+		NextAttemptAt: delivery.GetNextAttemptAt().AsTime(),
+		LastError:     delivery.GetLastError(),
+	}
+	if delivery.DeliveredAt != nil {
+		deliveredAt := delivery.GetDeliveredAt().AsTime()
+		res.DeliveredAt = &deliveredAt
+	}
+	return res
+}
+
+func (handler *handler) getWebhooks(ctx *gin.Context) {
+	stream, err := handler.ListWebhooks(ctx, &service.ListWebhooksRequest{})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	webhooks := make([]webhook, 0)
+	for {
+		hook, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		webhooks = append(webhooks, webhookFromProto(hook))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, webhooks)
+}
+
+// registerWebhook subscribes an URL to events, secret is shown only in this response
+func (handler *handler) registerWebhook(ctx *gin.Context) {
+	var req webhook
+	if err := ctx.BindJSON(&req); err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	hook, err := handler.RegisterWebhook(ctx, &service.Webhook{
+		Url:        req.URL,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+	})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusCreated, webhookFromProto(hook))
+}
+
+// deleteWebhook unsubscribes a webhook, its deliveries are dropped
+func (handler *handler) deleteWebhook(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	if _, err := handler.DeleteWebhook(ctx, &service.WebhookByIDRequest{Id: id}); err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// getWebhookDeliveries lists deliveries of a webhook, optionally filtered by "status" query parameter
+func (handler *handler) getWebhookDeliveries(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+	req := &service.ListWebhookDeliveriesRequest{WebhookId: id}
+	if name, ok := ctx.GetQuery("status"); ok {
+		deliveryStatus, ok := service.DeliveryStatus_value["DELIVERY_STATUS_"+strings.ToUpper(name)]
+		if !ok {
+			respondWithError(ctx, http.StatusBadRequest, "Unknown delivery status: %v", name)
+			return
+		}
+		req.Status = service.DeliveryStatus(deliveryStatus)
+	}
+
+	stream, err := handler.ListWebhookDeliveries(ctx, req)
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+	deliveries := make([]webhookDelivery, 0)
+	for {
+		delivery, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithStatus(ctx, err)
+			return
+		}
+		deliveries = append(deliveries, webhookDeliveryFromProto(delivery))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, deliveries)
+}
+
+func (handler *handler) redeliverWebhook(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondWithError(ctx, http.StatusBadRequest, "Id has wrong format")
+		return
+	}
+
+	delivery, err := handler.RedeliverWebhookDelivery(ctx, &service.DeliveryByIDRequest{Id: id})
+	if err != nil {
+		respondWithStatus(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, webhookDeliveryFromProto(delivery))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	roleChangeCallbackPrefix = "rcr"
+	approveAction            = "approve"
+	rejectAction             = "reject"
+)
+
+// roleChangeNotifier sends pending role change requests to read-write admins
+// with buttons to approve or reject them
+type roleChangeNotifier struct {
+	bot      *tgbotapi.BotAPI
+	dbClient service.DatabaseTestClient
+	notified map[int64]bool
+}
+
+// actorContext marks calls to database service as made on behalf of the user
+func actorContext(ctx context.Context, isu int64) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, service.ActorMetadataKey, strconv.FormatInt(isu, 10))
+}
+
+func roleChangeCallbackData(action string, id int64) string {
+	return fmt.Sprintf("%v:%v:%v", roleChangeCallbackPrefix, action, id)
+}
+
+// Run checks for new requests until the program exits
+func (n *roleChangeNotifier) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, span := tracing.Tracer().Start(context.Background(), "notify about role change requests")
+		if err := n.notify(ctx); err != nil {
+			log.Printf("Error when notifying about role change requests: %v", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		<-ticker.C
+	}
+}
+
+func (n *roleChangeNotifier) pendingRequests(ctx context.Context) ([]*service.RoleChangeRequest, error) {
+	stream, err := n.dbClient.ListRoleChangeRequests(ctx, &service.ListRoleChangeRequestsRequest{
+		Status: service.RoleChangeStatus_ROLE_CHANGE_STATUS_PENDING,
+	})
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*service.RoleChangeRequest, 0)
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+}
+
+// readWriteAdmins lists admins who can approve requests and have a linked chat
+func (n *roleChangeNotifier) readWriteAdmins(ctx context.Context) ([]*service.User, error) {
+	stream, err := n.dbClient.SearchUsersByName(ctx, &service.SearchByNameRequest{})
+	if err != nil {
+		return nil, err
+	}
+	admins := make([]*service.User, 0)
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			return admins, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.GetRole() == service.Role_ROLE_READ_WRITE_ADMIN && user.TelegramChatId != nil {
+			admins = append(admins, user)
+		}
+	}
+}
+
+func (n *roleChangeNotifier) notify(ctx context.Context) error {
+	requests, err := n.pendingRequests(ctx)
+	if err != nil {
+		return err
+	}
+	pending := make(map[int64]bool, len(requests))
+	fresh := make([]*service.RoleChangeRequest, 0)
+	for _, request := range requests {
+		pending[request.GetId()] = true
+		if !n.notified[request.GetId()] {
+			fresh = append(fresh, request)
+		}
+	}
+	// Forget decided and expired requests
+	for id := range n.notified {
+		if !pending[id] {
+			delete(n.notified, id)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	admins, err := n.readWriteAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	for _, request := range fresh {
+		text := fmt.Sprintf("User %v requests role %v for user %v (currently %v).\nThe request expires at %v.",
+			request.GetRequestedBy(),
+			request.GetRequestedRole(),
+			request.GetUserId(),
+			request.GetFromRole(),
+			request.GetExpiresAt().AsTime().Format(time.RFC1123))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Approve", roleChangeCallbackData(approveAction, request.GetId())),
+			tgbotapi.NewInlineKeyboardButtonData("Reject", roleChangeCallbackData(rejectAction, request.GetId())),
+		))
+
+		for _, admin := range admins {
+			if admin.GetId() == request.GetRequestedBy() || admin.GetId() == request.GetUserId() {
+				continue
+			}
+			msg := tgbotapi.NewMessage(admin.GetTelegramChatId(), text)
+			msg.ReplyMarkup = keyboard
+			if _, err := n.bot.Send(msg); err != nil {
+				log.Printf("Error when sending role change request to %v: %v", admin.GetId(), err)
+			}
+		}
+		n.notified[request.GetId()] = true
+	}
+	return nil
+}
+
+// handleRoleChangeCallback applies a decision made with inline buttons.
+// Returns false if the callback is not about role changes.
+func handleRoleChangeCallback(ctx context.Context, bot *tgbotapi.BotAPI, dbClient service.DatabaseTestClient, sessions *sessionStore, query *tgbotapi.CallbackQuery) bool {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 || parts[0] != roleChangeCallbackPrefix {
+		return false
+	}
+
+	answer, err := decideRoleChange(ctx, dbClient, sessions, query, parts[1], parts[2])
+	if err != nil {
+		answer = err.Error()
+	} else if query.Message != nil {
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			fmt.Sprintf("%v\n\n%v", query.Message.Text, answer))
+		bot.Send(edit)
+	}
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+		log.Printf("Error when answering callback: %v", err)
+	}
+	return true
+}
+
+func decideRoleChange(ctx context.Context, dbClient service.DatabaseTestClient, sessions *sessionStore, query *tgbotapi.CallbackQuery, action string, rawID string) (string, error) {
+	if query.Message == nil {
+		return "", errors.New("Message is too old")
+	}
+	session, ok := sessions.get(query.Message.Chat.ID)
+	if !ok {
+		return "", errors.New("Please authenticate with /start first")
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return "", errors.New("Malformed request")
+	}
+
+	request, err := dbClient.DecideRoleChangeRequest(actorContext(ctx, session.Isu), &service.RoleChangeDecision{
+		Id:      id,
+		Approve: action == approveAction,
+	})
+	if err != nil {
+		return "", errors.New(status.Convert(err).Message())
+	}
+	return fmt.Sprintf("Request %v is %v.", request.GetId(),
+		strings.ToLower(strings.TrimPrefix(request.GetStatus().String(), "ROLE_CHANGE_STATUS_"))), nil
+}
@@ -2,67 +2,121 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Iamnotagenius/test/db/oidcflow"
 	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
 	"github.com/coreos/go-oidc/v3/oidc"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type authHandler struct {
-	sessions    map[int64]Session
+	sessions    *sessionStore
 	provider    *oidc.Provider
-	state       string
+	logins      *loginFlow
 	bot         *tgbotapi.BotAPI
 	handlersMap handlersMap
 	dbClient    service.DatabaseTestClient
 }
 
-type stateWithParams struct {
-	base      string
-	chatID    int64
-	firstName string
-	lastName  string
+// loginPayload is carried through the redirect in signed state,
+// custom parameters don't persist across redirect
+type loginPayload struct {
+	// State of the login attempt
+	State     string `json:"s"`
+	ChatID    int64  `json:"c"`
+	FirstName string `json:"f"`
+	LastName  string `json:"l"`
+	Invite    string `json:"i,omitempty"`
+}
+
+// loginFlow starts and finishes login attempts of telegram chats
+type loginFlow struct {
+	attempts *oidcflow.Store
+	// key signs login payloads so that chat IDs cannot be forged
+	key []byte
 }
 
 const (
-	stateLength        = 25
-	callbackEndpoint   = "/auth/itmoid/callback"
-	chatIDEncodingBase = 16
+	callbackEndpoint = "/auth/itmoid/callback"
+	loginAttemptTTL  = 10 * time.Minute
 )
 
 var (
-	oauth2Config = oauth2.Config{
-		ClientID:     os.Getenv("ITMOID_CLIENT_ID"),
-		ClientSecret: os.Getenv("ITMOID_CLIENT_SECRET"),
-		Scopes:       []string{oidc.ScopeOpenID},
-		RedirectURL:  "http://localhost:8080/",
-	}
+	oidcConfig = oidcflow.Flags("http://localhost:8080/")
+
+	oauth2Config oauth2.Config
 )
 
-// Have to use "state" for chatID because custom parameters don't persist across redirect
-func (s stateWithParams) encodeState() string {
-	return s.base + strconv.FormatInt(s.chatID, chatIDEncodingBase) + ":" + s.firstName + ":" + s.lastName
+func newLoginFlow() (*loginFlow, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return &loginFlow{attempts: oidcflow.NewStore(loginAttemptTTL), key: key}, nil
+}
+
+func (f *loginFlow) sign(payload string) string {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
 
-func decodeState(encoded string, baseLength int) (s stateWithParams, err error) {
-	s.base = encoded[:baseLength]
-	rem := strings.Split(encoded[baseLength:], ":")
-	s.chatID, err = strconv.ParseInt(rem[0], chatIDEncodingBase, 64)
-	s.firstName = rem[1]
-	s.lastName = rem[2]
-	return
+// authCodeURL starts a login attempt of the chat
+func (f *loginFlow) authCodeURL(chatID int64, firstName string, lastName string, invite string) (string, error) {
+	attempt, err := f.attempts.Begin("")
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(loginPayload{
+		State:     attempt.State,
+		ChatID:    chatID,
+		FirstName: firstName,
+		LastName:  lastName,
+		Invite:    invite,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return attempt.AuthCodeURL(&oauth2Config, payload+"."+f.sign(payload)), nil
+}
+
+// finish verifies signed state and takes its login attempt, so it cannot be used again
+func (f *loginFlow) finish(state string) (*loginPayload, *oidcflow.Attempt, error) {
+	payload, signature, ok := strings.Cut(state, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(f.sign(payload))) {
+		return nil, nil, errors.New("state signature is invalid")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	var decoded loginPayload
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, nil, err
+	}
+	attempt, ok := f.attempts.Take(decoded.State)
+	if !ok {
+		return nil, nil, errors.New("login attempt is unknown or has expired")
+	}
+	return &decoded, attempt, nil
 }
 
 func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -73,7 +127,17 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := oauth2Config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	ctx, span := tracing.Tracer().Start(r.Context(), "telegram login callback", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	combinedState, attempt, err := h.logins.finish(r.URL.Query().Get("state"))
+	if err != nil {
+		log.Printf("Error in login state: %v. CSRF attack?", err)
+		http.Error(w, "Login link is invalid or has expired, please use /start again", http.StatusBadRequest)
+		return
+	}
+
+	token, err := oauth2Config.Exchange(ctx, r.URL.Query().Get("code"), attempt.ExchangeOptions()...)
 	if err != nil {
 		log.Printf("Exchange failed: %v", err)
 		return
@@ -85,54 +149,47 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	idToken, err := h.provider.Verifier(
-		&oidc.Config{ClientID: os.Getenv("ITMOID_CLIENT_ID")}).Verify(r.Context(), rawIDToken)
+		&oidc.Config{ClientID: oidcConfig.ClientID}).Verify(ctx, rawIDToken)
 	if err != nil {
 		log.Printf("Token parse failed: %v", err)
 		return
 	}
-	combinedState, err := decodeState(r.URL.Query().Get("state"), len(h.state))
-	if err != nil {
-		log.Printf("Error decoding state: %v", err)
+	if err := attempt.VerifyNonce(idToken.Nonce); err != nil {
+		log.Printf("Token verification failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if h.state != combinedState.base {
-		log.Println("States did not match. CSRF attack?")
-		return
-	}
-
-	var claims struct {
-		Sub string `json:"sub"`
-		Isu int64  `json:"isu"`
-	}
+	var claims map[string]json.RawMessage
 	if err := idToken.Claims(&claims); err != nil {
 		log.Printf("Token unmarshal failed: %v", err)
 		return
 	}
-
+	isu, err := oidcConfig.ISU(claims)
 	if err != nil {
-		log.Printf("Error parsing chat_id: %v", err)
+		log.Printf("Token unmarshal failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	currentSession := Session{
-		ChatID:      combinedState.chatID,
-		Isu:         claims.Isu,
+		ChatID:      combinedState.ChatID,
+		Isu:         isu,
 		ChatChannel: make(chan *tgbotapi.Message),
 		Bot:         h.bot,
 	}
-	h.sessions[combinedState.chatID] = currentSession
-	h.bot.Send(tgbotapi.NewMessage(combinedState.chatID, fmt.Sprintf("Hello with isu number %v.", claims.Isu)))
+	h.sessions.set(currentSession)
+	h.bot.Send(tgbotapi.NewMessage(combinedState.ChatID, fmt.Sprintf("Hello with isu number %v.", isu)))
 
 	currentSession.Handlers = h.handlersMap
 	currentSession.DBClient = h.dbClient
 
-	user, err := h.dbClient.GetUserByID(context.Background(), &service.UserByIDRequest{Id: currentSession.Isu})
+	user, err := h.dbClient.GetUserByID(ctx, &service.UserByIDRequest{Id: currentSession.Isu})
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			user = &service.User{
 				Id:   currentSession.Isu,
-				Name: fmt.Sprintf("%v %v", combinedState.firstName, combinedState.lastName),
+				Name: fmt.Sprintf("%v %v", combinedState.FirstName, combinedState.LastName),
 				Role: service.Role_ROLE_USER,
 			}
 		} else {
@@ -140,35 +197,51 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	_, err = h.dbClient.AddOrUpdateUser(context.Background(), user)
-	if err != nil {
-		log.Printf("Error calling db service: %v", err)
+	if user != nil {
+		user.TelegramChatId = &combinedState.ChatID
+		_, err = h.dbClient.AddOrUpdateUser(actorContext(ctx, currentSession.Isu), user)
+		if err != nil {
+			log.Printf("Error calling db service: %v", err)
+		}
 	}
 
-	go currentSession.Handle()
+	if combinedState.Invite != "" {
+		redeemInvitation(ctx, h.bot, h.dbClient, currentSession, combinedState.Invite)
+	}
 
-}
+	go currentSession.Handle()
 
-func authentication(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, state string, firstName string, lastName string) {
-	authURL := getAuthCodeURL(msg.Chat.ID, state, firstName, lastName)
-	msgConfig := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(`Please authenticate with <a href="%v">this link</a>.`, authURL))
-	msgConfig.ParseMode = html
-	bot.Send(msgConfig)
 }
 
-func getAuthCodeURL(chatID int64, state string, firstName string, lastName string) string {
-	return oauth2Config.AuthCodeURL(stateWithParams{
-		base:      state,
-		chatID:    chatID,
-		firstName: firstName,
-		lastName:  lastName,
-	}.encodeState())
+// redeemInvitation grants the user role and groups from invitation code of a deep link
+func redeemInvitation(ctx context.Context, bot *tgbotapi.BotAPI, dbClient service.DatabaseTestClient, session Session, code string) {
+	res, err := dbClient.RedeemInvitation(actorContext(ctx, session.Isu), &service.RedeemInvitationRequest{
+		Code:   code,
+		UserId: session.Isu,
+	})
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(session.ChatID, fmt.Sprintf("Invitation was not accepted: %v", status.Convert(err).Message())))
+		return
+	}
+	user := res.GetUser()
+	text := fmt.Sprintf("Invitation accepted. Your role is %v", user.GetRole())
+	if len(user.GetGroups()) > 0 {
+		text += fmt.Sprintf(", groups: %v", strings.Join(user.GetGroups(), ", "))
+	}
+	if request := res.GetRoleChangeRequest(); request != nil {
+		text += fmt.Sprintf(". Role %v waits for approval of an admin", request.GetRequestedRole())
+	}
+	bot.Send(tgbotapi.NewMessage(session.ChatID, text+"."))
 }
 
-func generateState() (string, error) {
-	buffer := make([]byte, stateLength)
-	if _, err := io.ReadFull(rand.Reader, buffer); err != nil {
-		return "", err
+func authentication(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, logins *loginFlow, firstName string, lastName string, invite string) {
+	authURL, err := logins.authCodeURL(msg.Chat.ID, firstName, lastName, invite)
+	if err != nil {
+		log.Printf("Error when starting login: %v", err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Could not start authentication, please try again"))
+		return
 	}
-	return base64.RawURLEncoding.EncodeToString(buffer), nil
+	msgConfig := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(`Please authenticate with <a href="%v">this link</a>.`, authURL))
+	msgConfig.ParseMode = html
+	bot.Send(msgConfig)
 }
@@ -8,21 +8,37 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Iamnotagenius/test/db/client"
 	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
 	"github.com/coreos/go-oidc/v3/oidc"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	grpcDbServiceAddress = flag.String("db-service-addr", "localhost:50051", "Address of grpc DB service")
+	dbCallTimeout        = flag.Duration("db-call-timeout", 5*time.Second, "Default deadline of calls to DB service")
+	approvalPollInterval = flag.Duration("approval-poll-interval", 30*time.Second, "How often admins are notified about new role change requests")
+	broadcastInterval    = flag.Duration("broadcast-poll-interval", 10*time.Second, "How often due broadcasts are sent")
+	broadcastRate        = flag.Int("broadcast-rate", 25, "Messages of broadcasts sent per second, telegram allows about 30")
+	tracingConfig        = tracing.Flags("telegram-bot")
 )
 
 func main() {
 	flag.Parse()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), *tracingConfig)
+	if err != nil {
+		log.Panicf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	bot, err := tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_BOT_API"))
 	if err != nil {
 		log.Panic(err)
@@ -32,44 +48,83 @@ func main() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	provider, err := oidc.NewProvider(context.Background(), "https://id.itmo.ru/auth/realms/itmo")
+	provider, err := oidc.NewProvider(context.Background(), oidcConfig.Issuer)
 	if err != nil {
 		log.Panicf("Invalid provider: %v", err)
 	}
-	oauth2Config.Endpoint = provider.Endpoint()
+	oauth2Config = oidcConfig.OAuth2(provider.Endpoint())
 
-	state, err := generateState()
+	logins, err := newLoginFlow()
 	if err != nil {
-		log.Panicf("Error when generating state: %v", err)
+		log.Panicf("Error when generating state key: %v", err)
 	}
 
-	sessions := make(map[int64]Session)
-	grpcConn, err := grpc.Dial(*grpcDbServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	sessions := newSessionStore()
+	dbClient, err := client.Dial(*grpcDbServiceAddress,
+		client.WithTimeout(*dbCallTimeout),
+		client.WithServiceToken(os.Getenv("DB_SERVICE_TOKEN")))
 	if err != nil {
 		log.Panicf("Failed to establish connection with database service: %v", err)
 	}
+	defer dbClient.Close()
 	http.Handle("/", &authHandler{
 		sessions:    sessions,
 		provider:    provider,
-		state:       state,
+		logins:      logins,
 		handlersMap: RegisterCommands(bot, commands...),
-		dbClient:    service.NewDatabaseTestClient(grpcConn),
+		dbClient:    dbClient,
 		bot:         bot,
 	})
 	go http.ListenAndServe(":8080", nil)
 
-	defer grpcConn.Close()
+	notifier := &roleChangeNotifier{bot: bot, dbClient: dbClient, notified: make(map[int64]bool)}
+	go notifier.Run(*approvalPollInterval)
+	if *broadcastRate <= 0 {
+		log.Panic("broadcast-rate must be positive")
+	}
+	go newBroadcaster(bot, dbClient, *broadcastRate).Run(*broadcastInterval)
+
+	// Stop receiving updates on exit so that remaining spans are exported
+	go func() {
+		signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-signals.Done()
+		bot.StopReceivingUpdates()
+	}()
 
 	updates := bot.GetUpdatesChan(u)
 	for update := range updates {
-		if msg := update.Message; msg != nil && msg.Chat.IsPrivate() {
-			session, ok := sessions[msg.Chat.ID]
-			if msg.Command() == "start" || !ok {
-				authentication(bot, msg, state, msg.From.FirstName, msg.From.LastName)
-				continue
-			}
+		handleUpdate(update, bot, dbClient, sessions, logins)
+	}
+}
+
+func handleUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI, dbClient service.DatabaseTestClient, sessions *sessionStore, logins *loginFlow) {
+	ctx, span := tracing.Tracer().Start(context.Background(), "telegram update",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.Int("telegram.update_id", update.UpdateID)))
+	defer span.End()
 
-			session.ChatChannel <- msg
+	if query := update.CallbackQuery; query != nil {
+		span.SetAttributes(attribute.String("telegram.update_type", "callback_query"))
+		handleRoleChangeCallback(ctx, bot, dbClient, sessions, query)
+		return
+	}
+	if msg := update.Message; msg != nil && msg.Chat.IsPrivate() {
+		span.SetAttributes(
+			attribute.String("telegram.update_type", "message"),
+			attribute.Int64("telegram.chat_id", msg.Chat.ID),
+			attribute.String("telegram.command", msg.Command()))
+		session, ok := sessions.get(msg.Chat.ID)
+		if msg.Command() == "start" || !ok {
+			// Deep links t.me/<bot>?start=<code> carry an invitation code
+			var invite string
+			if msg.Command() == "start" {
+				invite = msg.CommandArguments()
+			}
+			authentication(bot, msg, logins, msg.From.FirstName, msg.From.LastName, invite)
+			return
 		}
+
+		session.ChatChannel <- msg
 	}
 }
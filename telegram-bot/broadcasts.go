@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/Iamnotagenius/test/db/broadcast"
+	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// chatInterval is the shortest interval between messages to one chat telegram allows
+	chatInterval = time.Second
+	// broadcastMaxAttempts is how many times sending is tried before a recipient fails,
+	// errors returned by telegram itself, e.g. a blocked bot, fail recipients at once
+	broadcastMaxAttempts = 3
+	// broadcastBatchSize is how many recipients are claimed at once,
+	// few enough to be sent before their lease in the database service is over
+	broadcastBatchSize = 50
+)
+
+// broadcaster sends broadcasts to their recipients, keeping under rate limits of telegram
+type broadcaster struct {
+	bot      *tgbotapi.BotAPI
+	dbClient service.DatabaseTestClient
+	// pace ticks when the next message can be sent to any chat
+	pace *time.Ticker
+	// lastSent is when a message was sent to a chat during the current run
+	lastSent map[int64]time.Time
+}
+
+// newBroadcaster creates a broadcaster which sends at most rate messages per second
+func newBroadcaster(bot *tgbotapi.BotAPI, dbClient service.DatabaseTestClient, rate int) *broadcaster {
+	return &broadcaster{
+		bot:      bot,
+		dbClient: dbClient,
+		pace:     time.NewTicker(time.Second / time.Duration(rate)),
+	}
+}
+
+// Run sends due broadcasts until the program exits
+func (b *broadcaster) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.lastSent = make(map[int64]time.Time)
+		if err := b.sendDue(context.Background()); err != nil {
+			log.Printf("Error when sending broadcasts: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (b *broadcaster) sendDue(ctx context.Context) error {
+	stream, err := b.dbClient.StartDueBroadcasts(ctx, &service.StartDueBroadcastsRequest{})
+	if err != nil {
+		return err
+	}
+	broadcasts := make([]*service.Broadcast, 0)
+	for {
+		sending, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		broadcasts = append(broadcasts, sending)
+	}
+
+	for _, sending := range broadcasts {
+		ctx, span := tracing.Tracer().Start(ctx, "send broadcast",
+			trace.WithAttributes(
+				attribute.Int64("broadcast.id", sending.GetId()),
+				attribute.Int("broadcast.pending", int(sending.GetPending()))))
+		err := b.send(ctx, sending)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimRecipients leases pending recipients, so other bots sending the broadcast skip them
+func (b *broadcaster) claimRecipients(ctx context.Context, id int64) ([]*service.BroadcastRecipient, error) {
+	stream, err := b.dbClient.ClaimBroadcastRecipients(ctx, &service.ClaimBroadcastRecipientsRequest{
+		BroadcastId: id,
+		Limit:       broadcastBatchSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]*service.BroadcastRecipient, 0)
+	for {
+		recipient, err := stream.Recv()
+		if err == io.EOF {
+			return recipients, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+}
+
+// send delivers a broadcast to the recipients it claims until none are left, the rest is left for the next run
+// if the database fails. Recipients to retry stay claimed, so they are sent again once their lease is over.
+func (b *broadcaster) send(ctx context.Context, sending *service.Broadcast) error {
+	tmpl, parseErr := broadcast.Parse(sending.GetTemplate())
+	processed := 0
+	for {
+		recipients, err := b.claimRecipients(ctx, sending.GetId())
+		if err != nil {
+			return err
+		}
+		if len(recipients) == 0 {
+			break
+		}
+		for _, recipient := range recipients {
+			delivery := &service.BroadcastDelivery{
+				BroadcastId: sending.GetId(),
+				UserId:      recipient.GetUserId(),
+				Status:      service.RecipientStatus_RECIPIENT_STATUS_FAILED,
+			}
+			if parseErr != nil {
+				delivery.Error = parseErr.Error()
+			} else if retry, err := b.deliver(ctx, tmpl, recipient); err != nil {
+				if retry && recipient.GetAttempts()+1 < broadcastMaxAttempts {
+					delivery.Status = service.RecipientStatus_RECIPIENT_STATUS_PENDING
+				}
+				delivery.Error = err.Error()
+				log.Printf("Error when sending broadcast %v to user %v: %v", sending.GetId(), recipient.GetUserId(), err)
+			} else {
+				delivery.Status = service.RecipientStatus_RECIPIENT_STATUS_SENT
+			}
+
+			if _, err := b.dbClient.RecordBroadcastDelivery(ctx, delivery); err != nil {
+				return err
+			}
+		}
+		processed += len(recipients)
+	}
+	log.Printf("Processed %v recipients of broadcast %v", processed, sending.GetId())
+	return nil
+}
+
+// deliver renders the message for the current data of the recipient and sends it.
+// retry is true if the error may be gone on the next attempt, e.g. a network failure.
+func (b *broadcaster) deliver(ctx context.Context, tmpl *template.Template, recipient *service.BroadcastRecipient) (retry bool, err error) {
+	user, err := b.dbClient.GetUserByID(ctx, &service.UserByIDRequest{Id: recipient.GetUserId()})
+	if err != nil {
+		return status.Code(err) != grpccodes.NotFound, err
+	}
+	text, err := broadcast.Render(tmpl, user)
+	if err != nil {
+		return false, err
+	}
+	for {
+		b.wait(recipient.GetTelegramChatId())
+		_, err := b.bot.Send(tgbotapi.NewMessage(recipient.GetTelegramChatId(), text))
+		var apiErr *tgbotapi.Error
+		if !errors.As(err, &apiErr) {
+			return err != nil, err
+		}
+		if apiErr.RetryAfter == 0 {
+			// Telegram refused the message, e.g. the user blocked the bot
+			return false, err
+		}
+		// Flood control of telegram, the message is sent again once it is over
+		log.Printf("Telegram asked to wait %v seconds before sending broadcasts", apiErr.RetryAfter)
+		time.Sleep(time.Duration(apiErr.RetryAfter) * time.Second)
+	}
+}
+
+// wait blocks until a message can be sent to the chat without exceeding limits of telegram
+func (b *broadcaster) wait(chatID int64) {
+	<-b.pace.C
+	if last, ok := b.lastSent[chatID]; ok {
+		time.Sleep(time.Until(last.Add(chatInterval)))
+	}
+	b.lastSent[chatID] = time.Now()
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -40,6 +39,16 @@ var commands = []Command{
 		Description: "Search users by part or whole name",
 		Handler:     searchHandler,
 	},
+	{
+		Name:        "profile",
+		Description: "Show and fill in profile fields",
+		Handler:     profileHandler,
+	},
+	{
+		Name:        "preview",
+		Description: "Run a command as another user without changes, for read-write admins",
+		Handler:     previewHandler,
+	},
 }
 
 // RegisterCommands makes a request to notify about the declared commands
@@ -80,18 +89,18 @@ func phoneHandler(s *Session, msg *tgbotapi.Message) error {
 	}
 
 	user, err := s.DBClient.GetUserByID(
-		context.Background(),
+		s.Context(),
 		&service.UserByIDRequest{Id: s.Isu})
 	if err != nil {
 		return errors.New(status.Convert(err).Message())
 	}
 	user.PhoneNumber = &phone
-	s.DBClient.AddOrUpdateUser(context.Background(), user)
+	s.DBClient.AddOrUpdateUser(s.ActorContext(), user)
 	return nil
 }
 
 func searchHandler(s *Session, msg *tgbotapi.Message) error {
-	stream, err := s.DBClient.SearchUsersByName(context.Background(), &service.SearchByNameRequest{Query: msg.CommandArguments()})
+	stream, err := s.DBClient.SearchUsersByName(s.Context(), &service.SearchByNameRequest{Query: msg.CommandArguments()})
 	if err != nil {
 		return errors.New(status.Convert(err).Message())
 	}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/client"
+	"github.com/Iamnotagenius/test/db/service"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// impersonationContext marks calls to database service as made on behalf of the user by the admin,
+// calls which may change data are refused
+func impersonationContext(ctx context.Context, isu, admin int64) context.Context {
+	return metadata.AppendToOutgoingContext(client.ReadOnly(actorContext(ctx, isu)),
+		service.ImpersonatorMetadataKey, strconv.FormatInt(admin, 10))
+}
+
+// previewHandler runs a command with identity of another user, e.g. "/preview 123456 profile",
+// so admins see what the user sees. Previews are read-only, the command fails when it tries to change data.
+// Admins cannot be previewed.
+func previewHandler(s *Session, msg *tgbotapi.Message) error {
+	args := strings.SplitN(strings.TrimSpace(msg.CommandArguments()), " ", 3)
+	if len(args) < 2 {
+		return errors.New("Usage: /preview <ISU> <command> [arguments]")
+	}
+
+	admin, err := s.DBClient.GetUserByID(s.Context(), &service.UserByIDRequest{Id: s.Isu})
+	if err != nil {
+		return errors.New(status.Convert(err).Message())
+	}
+	if admin.GetRole() != service.Role_ROLE_READ_WRITE_ADMIN {
+		return errors.New("Only read-write admins can preview commands")
+	}
+	isu, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errors.New("ISU has wrong format")
+	}
+	user, err := s.DBClient.GetUserByID(s.Context(), &service.UserByIDRequest{Id: isu})
+	if err != nil {
+		return errors.New(status.Convert(err).Message())
+	}
+	if user.GetRole() >= service.Role_ROLE_READ_ONLY_ADMIN {
+		return errors.New("Commands of admins cannot be previewed")
+	}
+	command := strings.TrimPrefix(args[1], "/")
+	handler, ok := s.Handlers[command]
+	if !ok || command == "preview" {
+		return fmt.Errorf("Command /%v cannot be previewed", command)
+	}
+
+	// The command sees a message as if the user sent it
+	previewed := *msg
+	previewed.Text = "/" + command
+	if len(args) == 3 {
+		previewed.Text += " " + args[2]
+	}
+	previewed.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command) + 1}}
+
+	impersonated := *s
+	impersonated.Isu = isu
+	impersonated.banner = fmt.Sprintf("[Preview as %v]\n", isu)
+	impersonated.ctx = impersonationContext(s.Context(), isu, s.Isu)
+	trace.SpanFromContext(s.Context()).SetAttributes(
+		attribute.String("telegram.previewed_command", command),
+		attribute.Int64("enduser.impersonated_id", isu))
+	log.Printf("Admin %v previews /%v as user %v", s.Isu, command, isu)
+	if err := handler(&impersonated, &previewed); err != nil {
+		return fmt.Errorf("Preview of /%v as user %v failed: %w", command, isu, err)
+	}
+	return nil
+}
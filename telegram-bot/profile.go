@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Iamnotagenius/test/db/service"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const skipAnswer = "-"
+
+func visibleAttributes(s *Session) ([]*service.AttributeDefinition, error) {
+	stream, err := s.DBClient.ListAttributeDefinitions(
+		s.Context(),
+		&service.ListAttributeDefinitionsRequest{UserVisibleOnly: true})
+	if err != nil {
+		return nil, errors.New(status.Convert(err).Message())
+	}
+	definitions := make([]*service.AttributeDefinition, 0)
+	for {
+		definition, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New(status.Convert(err).Message())
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+func attributePrompt(definition *service.AttributeDefinition) string {
+	prompt := definition.GetDescription()
+	if prompt == "" {
+		prompt = definition.GetName()
+	}
+	switch definition.GetType() {
+	case service.AttributeType_ATTRIBUTE_TYPE_INTEGER:
+		prompt += " (a number)"
+	case service.AttributeType_ATTRIBUTE_TYPE_BOOLEAN:
+		prompt += " (yes or no)"
+	}
+	if !definition.GetRequired() {
+		prompt += fmt.Sprintf("\nSend '%v' to skip.", skipAnswer)
+	}
+	return prompt
+}
+
+func normalizeAnswer(definition *service.AttributeDefinition, answer string) string {
+	if definition.GetType() != service.AttributeType_ATTRIBUTE_TYPE_BOOLEAN {
+		return answer
+	}
+	switch strings.ToLower(answer) {
+	case "yes", "y":
+		return "true"
+	case "no", "n":
+		return "false"
+	}
+	return answer
+}
+
+// askAttribute asks the user for a value until it passes validation,
+// only the attribute is sent to the database so changes made meanwhile are kept.
+// Returns false if the user cancelled the dialog by sending a command.
+func askAttribute(s *Session, user *service.User, definition *service.AttributeDefinition) (bool, error) {
+	name := definition.GetName()
+	for {
+		s.SendMessage(attributePrompt(definition))
+		answer, ok := s.WaitForNewMessage()
+		if !ok {
+			return false, errors.New("Session was closed")
+		}
+		if strings.HasPrefix(answer, "/") {
+			return false, nil
+		}
+		if answer == skipAnswer && !definition.GetRequired() {
+			return true, nil
+		}
+
+		updated, err := s.DBClient.SetUserAttribute(s.ActorContext(), &service.SetUserAttributeRequest{
+			UserId: user.GetId(),
+			Name:   name,
+			Value:  normalizeAnswer(definition, answer),
+		})
+		if status.Code(err) == codes.InvalidArgument {
+			s.SendMessage(fmt.Sprintf("%v. Please try again.", status.Convert(err).Message()))
+			continue
+		}
+		if err != nil {
+			return false, errors.New(status.Convert(err).Message())
+		}
+		user.Attributes = updated.GetAttributes()
+		return true, nil
+	}
+}
+
+func profileHandler(s *Session, msg *tgbotapi.Message) error {
+	definitions, err := visibleAttributes(s)
+	if err != nil {
+		return err
+	}
+	if len(definitions) == 0 {
+		s.SendMessage("There are no profile fields yet.")
+		return nil
+	}
+	user, err := s.DBClient.GetUserByID(
+		s.Context(),
+		&service.UserByIDRequest{Id: s.Isu})
+	if err != nil {
+		return errors.New(status.Convert(err).Message())
+	}
+
+	toAsk := make([]*service.AttributeDefinition, 0)
+	if name := msg.CommandArguments(); name != "" {
+		for _, definition := range definitions {
+			if definition.GetName() == name {
+				toAsk = append(toAsk, definition)
+			}
+		}
+		if len(toAsk) == 0 {
+			return fmt.Errorf("Unknown profile field '%v'", name)
+		}
+	} else {
+		profile := &strings.Builder{}
+		for _, definition := range definitions {
+			value, ok := user.GetAttributes()[definition.GetName()]
+			if !ok {
+				toAsk = append(toAsk, definition)
+				value = "Unset"
+			}
+			profile.WriteString(fmt.Sprintf("\n%v: %v", definition.GetName(), value))
+		}
+		s.SendMessage("Your profile:" + profile.String())
+		if len(toAsk) == 0 {
+			s.SendMessage("All fields are filled in. Use /profile <field> to change one.")
+			return nil
+		}
+	}
+
+	for _, definition := range toAsk {
+		ok, err := askAttribute(s, user, definition)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.SendMessage("Profile editing cancelled.")
+			return nil
+		}
+	}
+	s.SendMessage("Profile saved.")
+	return nil
+}
@@ -1,15 +1,47 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/Iamnotagenius/test/db/service"
+	"github.com/Iamnotagenius/test/db/tracing"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type handlersMap = map[string]func(*Session, *tgbotapi.Message) error
 
+// sessionStore keeps sessions by chat ID, it is shared by the update loop and the login callback
+type sessionStore struct {
+	mu       sync.RWMutex
+	sessions map[int64]Session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[int64]Session)}
+}
+
+// get returns the session of a chat
+func (store *sessionStore) get(chatID int64) (Session, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	session, ok := store.sessions[chatID]
+	return session, ok
+}
+
+// set replaces the session of its chat
+func (store *sessionStore) set(session Session) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[session.ChatID] = session
+}
+
 // Session represents a chat session associated with one user
 type Session struct {
 	ChatID      int64
@@ -18,6 +50,11 @@ type Session struct {
 	Bot         *tgbotapi.BotAPI
 	Handlers    handlersMap
 	DBClient    service.DatabaseTestClient
+
+	// ctx carries the span of the command being handled
+	ctx context.Context
+	// banner prefixes messages of a command previewed by an admin
+	banner string
 }
 
 const (
@@ -36,14 +73,38 @@ func (s *Session) Handle() {
 		handler, ok := s.Handlers[msg.Command()]
 		if !ok {
 			s.SendMessage("I don't know this command")
+			continue
 		}
+		var span trace.Span
+		s.ctx, span = tracing.Tracer().Start(context.Background(), "telegram /"+msg.Command(),
+			trace.WithAttributes(
+				attribute.Int64("telegram.chat_id", s.ChatID),
+				semconv.EnduserID(fmt.Sprint(s.Isu)),
+			))
 		err := handler(s, msg)
 		if err != nil {
 			log.Printf("Error when handling '%v': %v", msg.Command(), err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 			s.SendMessage(fmt.Sprintf("%v, please try again", err))
-			continue
 		}
+		span.End()
+		s.ctx = nil
+	}
+}
+
+// Context returns context of the command being handled
+func (s *Session) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
 	}
+	return s.ctx
+}
+
+// ActorContext returns context of the command for calls which change data of the user,
+// so history records the user as the author of the change
+func (s *Session) ActorContext() context.Context {
+	return actorContext(s.Context(), s.Isu)
 }
 
 // WaitForNewMessage waits for a new message from the same chat to arrive.
@@ -56,12 +117,12 @@ func (s *Session) WaitForNewMessage() (content string, ok bool) {
 
 // SendMessage sends a message to chat
 func (s *Session) SendMessage(msg string) {
-	s.Bot.Send(tgbotapi.NewMessage(s.ChatID, msg))
+	s.Bot.Send(tgbotapi.NewMessage(s.ChatID, s.banner+msg))
 }
 
 // SendMessageWithParseMode sends a message to chat specifying parse mode
 func (s *Session) SendMessageWithParseMode(msg string, parseMode string) {
-	msgConfig := tgbotapi.NewMessage(s.ChatID, msg)
+	msgConfig := tgbotapi.NewMessage(s.ChatID, s.banner+msg)
 	msgConfig.ParseMode = parseMode
 	s.Bot.Send(msgConfig)
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Iamnotagenius/test/db/service"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSessionStoreIsSafeForConcurrentUse(t *testing.T) {
+	store := newSessionStore()
+	var wg sync.WaitGroup
+	for chat := int64(1); chat <= 10; chat++ {
+		wg.Add(2)
+		go func(chat int64) {
+			defer wg.Done()
+			store.set(Session{ChatID: chat, Isu: chat * 100})
+		}(chat)
+		go func(chat int64) {
+			defer wg.Done()
+			store.get(chat)
+		}(chat)
+	}
+	wg.Wait()
+
+	for chat := int64(1); chat <= 10; chat++ {
+		if session, ok := store.get(chat); !ok || session.Isu != chat*100 {
+			t.Errorf("chat %v has session %v", chat, session)
+		}
+	}
+}
+
+func TestActorContextNamesTheUser(t *testing.T) {
+	s := &Session{Isu: 284555}
+	md, _ := metadata.FromOutgoingContext(s.ActorContext())
+	if actors := md.Get(service.ActorMetadataKey); len(actors) != 1 || actors[0] != "284555" {
+		t.Errorf("got actors %v, want the user", actors)
+	}
+}